@@ -17,17 +17,31 @@
 package db_actions
 
 import (
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"git.darknebu.la/GalaxySimulator/structs"
-	_ "github.com/lib/pq"
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/encoding/protowire"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,1248 +51,6792 @@ const (
 	DBSSLMODE = "disable"
 )
 
-var (
-	db        *sql.DB
-	treeWidth float64
-)
+// Store is a handle to a database storing galaxies. All the operations of a Store use its own database and Options,
+// so several databases or configurations can be used at once and concurrently. The package level functions taking a
+// database are shorthands creating a Store with the default Options for every call
+type Store struct {
+	db   *sql.DB
+	opts Options
 
-// connectToDB returns a pointer to an sql database writing to the database
-func ConnectToDB(dbname string) *sql.DB {
-	connStr := fmt.Sprintf("user=%s dbname=%s sslmode=%s", DBUSER, dbname, DBSSLMODE)
-	db := dbConnect(connStr)
-	return db
+	// q runs the queries of the Store. It is the database itself or a transaction on it (see InsertListTx)
+	q querier
 }
 
-// dbConnect connects to a PostgreSQL database
-func dbConnect(connStr string) *sql.DB {
-	// connect to the database
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatalf("[ E ] connection: %v", err)
-	}
+// NewStore returns a Store operating on the given database using the default Options
+func NewStore(db *sql.DB) *Store {
+	return NewStoreWithOptions(db, Options{})
+}
 
-	return db
+// NewStoreWithOptions returns a Store operating on the given database using the given Options
+func NewStoreWithOptions(db *sql.DB, opts Options) *Store {
+	return &Store{db: db, opts: opts, q: db}
 }
 
-// newTree creates a new tree with the given width
-func NewTree(database *sql.DB, width float64) {
-	db = database
-	treeWidth = width
+// withTx returns a Store with the Options of s running its queries in the given transaction
+func (s *Store) withTx(tx *sql.Tx) *Store {
+	return &Store{db: s.db, opts: s.opts, q: tx}
+}
 
-	log.Printf("Creating a new tree with a width of %f", width)
+// beginTx starts the transaction of an operation that has to be atomic and returns a Store running its queries in
+// it. If s already runs its queries in a transaction (see withTx), the operation becomes part of that transaction
+// instead: the returned transaction is nil and committing or rolling back is left to the owner of the transaction
+func (s *Store) beginTx(ctx context.Context) (*Store, *sql.Tx, error) {
+	if _, inTx := s.q.(*sql.Tx); inTx {
+		return s, nil, nil
+	}
 
-	// get the current max root id
-	query := fmt.Sprintf("SELECT COALESCE(max(root_id), 0) FROM nodes")
-	var currentMaxRootID int64
-	err := db.QueryRow(query).Scan(&currentMaxRootID)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Fatalf("[ E ] max root id query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, nil, err
 	}
 
-	// build the query creating a new node
-	query = fmt.Sprintf("INSERT INTO nodes (box_width, root_id, box_center, depth, isleaf, timestep) VALUES (%f, %d, '{0, 0}', 0, TRUE, %d)", width, currentMaxRootID+1, currentMaxRootID+1)
+	return s.withTx(tx), tx, nil
+}
 
-	// execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
-	if err != nil {
-		log.Fatalf("[ E ] insert new node query: %v\n\t\t\t query: %s\n", err, query)
+// commitTx commits the given transaction started by beginTx. A nil transaction belongs to an enclosing operation,
+// so nothing is committed
+func commitTx(tx *sql.Tx) error {
+	if tx == nil {
+		return nil
 	}
+
+	return tx.Commit()
 }
 
-// insertStar inserts the given star into the stars table and the nodes table tree
-func InsertStar(database *sql.DB, star structs.Star2D, index int64) int64 {
-	db = database
-	start := time.Now()
+// rollbackTx rolls back the given transaction started by beginTx unless it has been committed already.
+// A nil transaction belongs to an enclosing operation, so nothing is rolled back
+func rollbackTx(tx *sql.Tx) {
+	if tx != nil {
+		tx.Rollback()
+	}
+}
 
-	log.Printf("Inserting the star %v into the tree with the index %d", star, index)
+// querier is implemented by both *sql.DB and *sql.Tx, so the same queries can run inside and outside of a
+// transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	// insert the star into the stars table
-	starID := insertIntoStars(star)
+// Options configures the operations of a Store. The zero Options are the defaults
+type Options struct {
+	// MinActingMass is the mass below which stars don't exert forces on other stars. Such stars (and massless
+	// stars, which never exert a force) are test particles: they still feel the forces of the other stars and are
+	// moved accordingly
+	MinActingMass float64
 
-	// get the root node id
-	query := fmt.Sprintf("select case when exists (select node_id from nodes where root_id=%d) then (select node_id from nodes where root_id=%d) else -1 end;", index, index)
-	var id int64
-	err := db.QueryRow(query).Scan(&id)
+	// Softening is the default softening length of stars that don't have their own softening length (eps column)
+	Softening float64
 
-	// if there are no rows in the result set, create a new tree
-	if err != nil {
-		log.Fatalf("[ E ] Get root node id query: %v\n\t\t\t query: %s\n", err, query)
-	}
+	// IncludeDeleted makes the queries listing the stars of the stars table include soft deleted stars
+	IncludeDeleted bool
 
-	if id == -1 {
-		NewTree(db, 1000)
-		id = getRootNodeID(index)
-	}
+	// QueryTimeout is the time after which the queries issued by an operation are aborted with a deadline exceeded
+	// error. A QueryTimeout of zero disables the timeout
+	QueryTimeout time.Duration
 
-	log.Printf("Node id of the root node %d: %d", id, index)
+	// PartitionNodes makes NewTree create the partition of the nodes table storing the timestep of the new tree,
+	// see InitPartitionedNodesTable
+	PartitionNodes bool
 
-	// insert the star into the tree (using it's ID) starting at the root
-	insertIntoTree(starID, id)
-	elapsedTime := time.Since(start)
-	log.Printf("\t\t\t\t\t %s", elapsedTime)
-	return starID
+	// ForceCalculation is the mode used to calculate the forces acting on stars, see ForceMode
+	ForceCalculation ForceMode
+
+	// NotifyChannel is the channel a notification is sent on after stars have been inserted and after simulation
+	// steps (see WatchChanges). An empty NotifyChannel disables the notifications
+	NotifyChannel string
 }
 
-// insertIntoStars inserts the given star into the stars table
-func insertIntoStars(star structs.Star2D) int64 {
-	// unpack the star
-	x := star.C.X
-	y := star.C.Y
-	vx := star.V.X
-	vy := star.V.Y
-	m := star.M
+// ForceMode defines how the forces acting on a star are calculated when traversing the tree
+type ForceMode int
 
-	// build the request query
-	query := fmt.Sprintf("INSERT INTO stars (x, y, vx, vy, m) VALUES (%f, %f, %f, %f, %f) RETURNING star_id", x, y, vx, vy, m)
+const (
+	// ForceApprox uses the Barnes-Hut approximation: nodes that are far away relative to their width (see theta)
+	// aren't opened
+	ForceApprox ForceMode = iota
 
-	// execute the query
-	var starID int64
-	err := db.QueryRow(query).Scan(&starID)
-	if err != nil {
-		log.Fatalf("[ E ] insert query: %v\n\t\t\t query: %s\n", err, query)
+	// ForceExact always opens the nodes down to the leaves, so the forces of all the stars are summed up exactly.
+	// This is as accurate as CalcAllForcesDirect and only feasible for small galaxies
+	ForceExact
+)
+
+// deletedFilter returns the condition used to exclude soft deleted stars from queries on the stars table
+func (s *Store) deletedFilter() string {
+	if s.opts.IncludeDeleted {
+		return "TRUE"
 	}
 
-	return starID
+	return "NOT deleted"
 }
 
-// insert into tree inserts the given star into the tree starting at the node with the given node id
-func insertIntoTree(starID int64, nodeID int64) {
-	//starRaw := GetStar(starID)
-	//nodeCenter := getBoxCenter(nodeID)
-	//nodeWidth := getBoxWidth(nodeID)
-	//log.Printf("[   ] \t Inserting star %v into the node (c: %v, w: %v)", starRaw, nodeCenter, nodeWidth)
+// queryContext returns the context used for all the queries of a single operation. The context is cancelled after
+// Options.QueryTimeout (if set), so the timeout limits the whole operation and not each of its queries
+func (s *Store) queryContext() (context.Context, context.CancelFunc) {
+	return s.withQueryTimeout(context.Background())
+}
 
-	// There exist four cases:
-	//                    | Contains a Star | Does not Contain a Star |
-	// ------------------ + --------------- + ----------------------- +
-	// Node is a Leaf     | Impossible      | insert into node        |
-	//                    |                 | subdivide               |
-	// ------------------ + --------------- + ----------------------- +
-	// Node is not a Leaf | insert preexist | insert into the subtree |
-	//                    | insert new      |                         |
-	// ------------------ + --------------- + ----------------------- +
+// withQueryTimeout returns a context derived from the given context that is additionally cancelled after
+// Options.QueryTimeout (if set), see queryContext
+func (s *Store) withQueryTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.opts.QueryTimeout > 0 {
+		return context.WithTimeout(parent, s.opts.QueryTimeout)
+	}
 
-	// get the node with the given nodeID
-	// find out if the node contains a star or not
-	containsStar := containsStar(nodeID)
+	return context.WithCancel(parent)
+}
 
-	// find out if the node is a leaf
-	isLeaf := isLeaf(nodeID)
+// contextError returns the error of the given context if it is done and err otherwise, so that callers can compare
+// the errors of operations that were cancelled or timed out to context.Canceled and context.DeadlineExceeded
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 
-	// if the node is a leaf and contains a star
-	// subdivide the tree
-	// insert the preexisting star into the correct subtree
-	// insert the new star into the subtree
-	if isLeaf == true && containsStar == true {
-		//log.Printf("Case 1, \t %v \t %v", nodeWidth, nodeCenter)
-		subdivide(nodeID)
-		//tree := printTree(nodeID)
+	return err
+}
 
-		// Stage 1: Inserting the blocking star
-		blockingStarID := getStarID(nodeID)                               // get the id of the star blocking the node
-		blockingStar := GetStar(nil, blockingStarID)                      // get the actual star
-		blockingStarQuadrant := quadrant(blockingStar, nodeID)            // find out in which quadrant it belongs
-		quadrantNodeID := getQuadrantNodeID(nodeID, blockingStarQuadrant) // get the nodeID of that quadrant
-		insertIntoTree(blockingStarID, quadrantNodeID)                    // insert the star into that node
-		removeStarFromNode(nodeID)                                        // remove the blocking star from the node it was blocking
+// connectToDB returns a pointer to an sql database writing to the database
+func ConnectToDB(dbname string) *sql.DB {
+	db := dbConnect(ConnString(dbname))
+	return db
+}
 
-		// Stage 1: Inserting the actual star
-		star := GetStar(nil, starID)                             // get the actual star
-		starQuadrant := quadrant(star, nodeID)                   // find out in which quadrant it belongs
-		quadrantNodeID = getQuadrantNodeID(nodeID, starQuadrant) // get the nodeID of that quadrant
-		insertIntoTree(starID, nodeID)
-	}
+// ConnString returns the connection string ConnectToDB uses to connect to the database with the given name
+func ConnString(dbname string) string {
+	return fmt.Sprintf("user=%s dbname=%s sslmode=%s", DBUSER, dbname, DBSSLMODE)
+}
 
-	// if the node is a leaf and does not contain a star
-	// insert the star into the node and subdivide it
-	if isLeaf == true && containsStar == false {
-		//log.Printf("Case 2, \t %v \t %v", nodeWidth, nodeCenter)
-		directInsert(starID, nodeID)
+// dbConnect connects to a PostgreSQL database
+func dbConnect(connStr string) *sql.DB {
+	// connect to the database
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatalf("[ E ] connection: %v", err)
 	}
 
-	// if the node is not a leaf and contains a star
-	// insert the preexisting star into the correct subtree
-	// insert the new star into the subtree
-	if isLeaf == false && containsStar == true {
-		//log.Printf("Case 3, \t %v \t %v", nodeWidth, nodeCenter)
-		// Stage 1: Inserting the blocking star
-		blockingStarID := getStarID(nodeID)                               // get the id of the star blocking the node
-		blockingStar := GetStar(nil, blockingStarID)                      // get the actual star
-		blockingStarQuadrant := quadrant(blockingStar, nodeID)            // find out in which quadrant it belongs
-		quadrantNodeID := getQuadrantNodeID(nodeID, blockingStarQuadrant) // get the nodeID of that quadrant
-		insertIntoTree(blockingStarID, quadrantNodeID)                    // insert the star into that node
-		removeStarFromNode(nodeID)                                        // remove the blocking star from the node it was blocking
+	return db
+}
+
+// Notification is a notification sent on the Options.NotifyChannel, see WatchChanges
+type Notification struct {
+	Channel string
+
+	// Payload describes the change: "insert <index>" after stars have been inserted into the tree with the given
+	// index and "step <index>" after the tree with the given index has been built by a simulation step. Every
+	// operation sends a single notification, no matter how many stars it inserts
+	Payload string
+}
 
-		// Stage 1: Inserting the actual star
-		star := GetStar(nil, blockingStarID)                     // get the actual star
-		starQuadrant := quadrant(star, nodeID)                   // find out in which quadrant it belongs
-		quadrantNodeID = getQuadrantNodeID(nodeID, starQuadrant) // get the nodeID of that quadrant
-		insertIntoTree(starID, nodeID)
+// notifyChange sends a notification with the given payload on the Options.NotifyChannel (if set). Failing to notify
+// doesn't fail the change, so errors are only logged
+func (s *Store) notifyChange(format string, args ...interface{}) {
+	if s.opts.NotifyChannel == "" {
+		return
 	}
 
-	// if the node is not a leaf and does not contain a star
-	// insert the new star into the according subtree
-	if isLeaf == false && containsStar == false {
-		//log.Printf("Case 4, \t %v \t %v", nodeWidth, nodeCenter)
-		star := GetStar(nil, starID)                              // get the actual star
-		starQuadrant := quadrant(star, nodeID)                    // find out in which quadrant it belongs
-		quadrantNodeID := getQuadrantNodeID(nodeID, starQuadrant) // get the if of that quadrant
-		insertIntoTree(starID, quadrantNodeID)                    // insert the star into that quadrant
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	_, err := s.q.ExecContext(ctx, "SELECT pg_notify($1, $2)", s.opts.NotifyChannel, fmt.Sprintf(format, args...))
+	if err != nil {
+		log.Printf("[ W ] notifyChange query: %v", err)
 	}
 }
 
-// containsStar returns true if the node with the given id contains a star and returns false if not.
-func containsStar(id int64) bool {
-	var starID int64
+// watchPollInterval is the interval WatchChanges asks the database for the notifications sent in the meantime
+const watchPollInterval = 100 * time.Millisecond
 
-	query := fmt.Sprintf("SELECT star_id FROM nodes WHERE node_id=%d", id)
-	err := db.QueryRow(query).Scan(&starID)
+// WatchChanges listens on the given channel of the given database and returns the received notifications (see
+// Options.NotifyChannel) until ctx is done. The notifications are received using a connection taken from the pool of
+// the database, so the configuration the database has been opened with is used. The database has to be opened using
+// the pq driver (e.g. using ConnectToDB or sql.OpenDB with a pq.Connector). The connection stops listening and is
+// returned to the pool once ctx is done or the connection is lost, the returned channel is closed then
+func WatchChanges(ctx context.Context, database *sql.DB, channel string) (<-chan Notification, error) {
+	conn, err := database.Conn(ctx)
 	if err != nil {
-		log.Fatalf("[ E ] containsStar query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, contextError(ctx, fmt.Errorf("WatchChanges connection: %v", err))
 	}
 
-	if starID != 0 {
-		return true
+	// pq calls the handler while reading the responses to the queries sent on the connection
+	notifications := make(chan Notification)
+	err = conn.Raw(func(driverConn interface{}) error {
+		pq.SetNotificationHandler(driverConn.(driver.Conn), func(n *pq.Notification) {
+			select {
+			case notifications <- Notification{Channel: n.Channel, Payload: n.Extra}:
+			case <-ctx.Done():
+			}
+		})
+		return nil
+	})
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "LISTEN "+pq.QuoteIdentifier(channel))
+	}
+	if err != nil {
+		stopWatching(conn)
+		return nil, contextError(ctx, fmt.Errorf("WatchChanges listen: %v", err))
 	}
 
-	return false
+	go func() {
+		defer close(notifications)
+		defer stopWatching(conn)
+
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// the server sends the notifications as soon as they are committed, they are read (and passed to the
+			// handler) along with the response to the next query
+			if err := conn.PingContext(ctx); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[ W ] WatchChanges connection lost: %v", err)
+				}
+				return
+			}
+		}
+	}()
+
+	return notifications, nil
 }
 
-// isLeaf returns true if the node with the given id is a leaf
-func isLeaf(nodeID int64) bool {
-	var isLeaf bool
+// stopWatching removes the notification handler of WatchChanges from the given connection, stops listening on all
+// channels and returns the connection to the pool of its database
+func stopWatching(conn *sql.Conn) {
+	defer conn.Close()
 
-	query := fmt.Sprintf("SELECT COALESCE(isleaf, FALSE) FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&isLeaf)
+	err := conn.Raw(func(driverConn interface{}) error {
+		pq.SetNotificationHandler(driverConn.(driver.Conn), nil)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("[ E ] isLeaf query: %v\n\t\t\t query: %s\n", err, query)
+		return
 	}
 
-	if isLeaf == true {
-		return true
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := conn.ExecContext(ctx, "UNLISTEN *"); err != nil {
+		log.Printf("[ W ] WatchChanges unlisten: %v", err)
 	}
+}
 
-	return false
+// newTree creates a new tree with the given width
+func (s *Store) NewTree(width float64) {
+	s.newTreeIndex(width)
 }
 
-// directInsert inserts the star with the given ID into the given node inside of the given database
-func directInsert(starID int64, nodeID int64) {
-	// build the query
-	query := fmt.Sprintf("UPDATE nodes SET star_id=%d WHERE node_id=%d", starID, nodeID)
+// NewTree is Store.NewTree using the given database
+func NewTree(database *sql.DB, width float64) {
+	NewStore(database).NewTree(width)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+// newTreeIndex creates a new tree with the given width and returns the index of the new tree
+func (s *Store) newTreeIndex(width float64) int64 {
+	index, _, err := s.NewTreeAt(structs.Vec2{}, width)
 	if err != nil {
-		log.Fatalf("[ E ] directInsert query: %v\n\t\t\t query: %s\n", err, query)
+		log.Fatalf("[ E ] %v", err)
 	}
+
+	return index
 }
 
-// subdivide subdivides the given node creating four child nodes
-func subdivide(nodeID int64) {
-	boxWidth := getBoxWidth(nodeID)
-	boxCenter := getBoxCenter(nodeID)
-	originalDepth := getNodeDepth(nodeID)
-	timestep := getTimestepNode(nodeID)
-	log.Printf("Subdividing %d, setting the timestep to %d", nodeID, timestep)
+// NewTreeAt creates a new tree with the given width centered at the given center, so that galaxies that aren't
+// centered at the origin fit into a tree that isn't wider than needed. The index of the new tree and the id of its
+// root node are returned. The metadata of the new tree is stored in the tree_meta table, which is created if it
+// doesn't exist yet, so databases created before it was introduced keep working
+func (s *Store) NewTreeAt(center structs.Vec2, width float64) (int64, int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// calculate the new positions
-	newPosX := boxCenter[0] + (boxWidth / 2)
-	newPosY := boxCenter[1] + (boxWidth / 2)
-	newNegX := boxCenter[0] - (boxWidth / 2)
-	newNegY := boxCenter[1] - (boxWidth / 2)
-	newWidth := boxWidth / 2
+	return s.newTreeAt(ctx, center, width)
+}
 
-	// create new news with those positions
-	newNodeIDA := newNode(newPosX, newPosY, newWidth, originalDepth+1, timestep)
-	newNodeIDB := newNode(newPosX, newNegY, newWidth, originalDepth+1, timestep)
-	newNodeIDC := newNode(newNegX, newPosY, newWidth, originalDepth+1, timestep)
-	newNodeIDD := newNode(newNegX, newNegY, newWidth, originalDepth+1, timestep)
+// newTreeAt is NewTreeAt using the given context for the queries
+func (s *Store) newTreeAt(ctx context.Context, center structs.Vec2, width float64) (int64, int64, error) {
+	log.Printf("Creating a new tree with a width of %f centered at %v", width, center)
 
-	// Update the subtrees of the parent node
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("NewTreeAt begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	// the index of the new tree is derived from the existing trees, so trees created concurrently have to wait for
+	// each other. The lock is held until the end of the transaction (the enclosing one if s runs in a transaction),
+	// so the next tree sees the root node of this one. The width of the tree is only stored in its root node, so
+	// they don't interfere otherwise
+	if _, err := txStore.q.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", newTreeLockID); err != nil {
+		return 0, 0, fmt.Errorf("NewTreeAt lock query: %v", err)
+	}
 
-	// build the query
-	query := fmt.Sprintf("UPDATE nodes SET subnode='{%d, %d, %d, %d}', isleaf=FALSE, timestep=%d WHERE node_id=%d", newNodeIDA, newNodeIDB, newNodeIDC, newNodeIDD, timestep, nodeID)
+	// get the current max root id
+	query := "SELECT COALESCE(max(root_id), 0) FROM nodes"
+	var currentMaxRootID int64
+	err = txStore.q.QueryRowContext(ctx, query).Scan(&currentMaxRootID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("NewTreeAt max root id query: %v", err)
+	}
+	index := currentMaxRootID + 1
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+	if s.opts.PartitionNodes {
+		if err := txStore.createTimestepPartitionContext(ctx, index); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// create the root node
+	query = `INSERT INTO nodes (box_width, root_id, box_center, depth, isleaf, timestep, star_id, subnode)
+		VALUES ($1, $2, ARRAY[$3, $4]::numeric[], 0, TRUE, $2, 0, '{0, 0, 0, 0}') RETURNING node_id`
+	var rootID int64
+	err = txStore.q.QueryRowContext(ctx, query, width, index, center.X, center.Y).Scan(&rootID)
 	if err != nil {
-		log.Fatalf("[ E ] subdivide query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, 0, fmt.Errorf("NewTreeAt insert root node query: %v", err)
+	}
+
+	if err := txStore.newTreeMeta(ctx, index); err != nil {
+		return 0, 0, fmt.Errorf("NewTreeAt %v", err)
+	}
+
+	if err := commitTx(tx); err != nil {
+		return 0, 0, fmt.Errorf("NewTreeAt commit: %v", err)
 	}
+
+	return index, rootID, nil
 }
 
-// getBoxWidth gets the width of the box from the node width the given id
-func getBoxWidth(nodeID int64) float64 {
-	var boxWidth float64
+// NewTreeAt is Store.NewTreeAt using the given database
+func NewTreeAt(database *sql.DB, center structs.Vec2, width float64) (int64, int64, error) {
+	return NewStore(database).NewTreeAt(center, width)
+}
 
-	query := fmt.Sprintf("SELECT box_width FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&boxWidth)
-	if err != nil {
-		log.Fatalf("[ E ] getBoxWidth query: %v\n\t\t\t query: %s\n", err, query)
+// newTreeLockID is the key of the advisory lock held while creating a new tree, see NewTreeAt
+const newTreeLockID = 4711
+
+// TreeMeta contains the metadata of a tree
+type TreeMeta struct {
+	Index     int64
+	Name      string
+	CreatedAt time.Time
+	StarCount int64
+}
+
+// treeMetaTableQuery creates the tree_meta table storing the metadata of the trees if it doesn't exist yet
+const treeMetaTableQuery = `CREATE TABLE IF NOT EXISTS public.tree_meta
+	(
+		index bigint PRIMARY KEY,
+		name text NOT NULL DEFAULT '',
+		created_at timestamp with time zone NOT NULL DEFAULT now()
+	)
+`
+
+// newTreeMeta (re)creates the metadata row of the tree with the given index, creating the tree_meta table first if
+// the database predates it. A new tree replaces the metadata of a deleted tree that had the same index
+func (s *Store) newTreeMeta(ctx context.Context, index int64) error {
+	if _, err := s.q.ExecContext(ctx, treeMetaTableQuery); err != nil {
+		return fmt.Errorf("tree meta table query: %v", err)
 	}
 
-	return boxWidth
+	query := "INSERT INTO tree_meta (index) VALUES ($1) ON CONFLICT (index) DO UPDATE SET name='', created_at=now()"
+	if _, err := s.q.ExecContext(ctx, query, index); err != nil {
+		return fmt.Errorf("tree meta query: %v", err)
+	}
+
+	return nil
 }
 
-// getTimestepNode gets the timestep of the current node
-func getTimestepNode(nodeID int64) int64 {
-	var timestep int64
+// SetTreeName sets the human readable name of the tree with the given index
+func (s *Store) SetTreeName(index int64, name string) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.q.ExecContext(ctx, treeMetaTableQuery); err != nil {
+		return fmt.Errorf("SetTreeName tree meta table query: %v", err)
+	}
 
-	query := fmt.Sprintf("SELECT timestep FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&timestep)
+	query := "INSERT INTO tree_meta (index, name) VALUES ($1, $2) ON CONFLICT (index) DO UPDATE SET name=EXCLUDED.name"
+	_, err := s.q.ExecContext(ctx, query, index, name)
 	if err != nil {
-		log.Fatalf("[ E ] getTimeStep query: %v\n\t\t\t query: %s\n", err, query)
+		return fmt.Errorf("SetTreeName query: %v", err)
 	}
 
-	return timestep
+	return nil
 }
 
-// getBoxWidth gets the center of the box from the node width the given id
-func getBoxCenter(nodeID int64) []float64 {
-	var boxCenterX, boxCenterY []uint8
+// SetTreeName is Store.SetTreeName using the given database
+func SetTreeName(database *sql.DB, index int64, name string) error {
+	return NewStore(database).SetTreeName(index, name)
+}
+
+// GetTreeMeta returns the metadata of the tree with the given index.
+// The star count is counted when calling GetTreeMeta, so it is always up to date
+func (s *Store) GetTreeMeta(index int64) (TreeMeta, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	meta := TreeMeta{Index: index}
 
-	query := fmt.Sprintf("SELECT box_center[1], box_center[2] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&boxCenterX, &boxCenterY)
+	query := "SELECT name, created_at FROM tree_meta WHERE index=$1"
+	err := s.q.QueryRowContext(ctx, query, index).Scan(&meta.Name, &meta.CreatedAt)
+	if err == sql.ErrNoRows {
+		return meta, fmt.Errorf("GetTreeMeta: there is no metadata for the tree %d", index)
+	}
 	if err != nil {
-		log.Fatalf("[ E ] getBoxCenter query: %v\n\t\t\t query: %s\n", err, query)
+		return meta, fmt.Errorf("GetTreeMeta query: %v", err)
 	}
 
-	x, parseErr := strconv.ParseFloat(string(boxCenterX), 64)
-	y, parseErr := strconv.ParseFloat(string(boxCenterX), 64)
-
-	if parseErr != nil {
-		log.Fatalf("[ E ] parse boxCenter: %v\n\t\t\t query: %s\n", err, query)
-		log.Fatalf("[ E ] parse boxCenter: (%f, %f)\n", x, y)
+	query = "SELECT count(*) FROM nodes WHERE timestep=$1 AND COALESCE(star_id, 0)<>0"
+	err = s.q.QueryRowContext(ctx, query, index).Scan(&meta.StarCount)
+	if err != nil {
+		return meta, fmt.Errorf("GetTreeMeta star count query: %v", err)
 	}
 
-	boxCenterFloat := []float64{x, y}
+	return meta, nil
+}
 
-	return boxCenterFloat
+// GetTreeMeta is Store.GetTreeMeta using the given database
+func GetTreeMeta(database *sql.DB, index int64) (TreeMeta, error) {
+	return NewStore(database).GetTreeMeta(index)
 }
 
-// getMaxTimestep gets the maximal timestep from the nodes table
-func getMaxTimestep() float64 {
-	var maxTimestep float64
+// TreeFootprint estimates the storage used by the tree with the given index: the size of the rows of its nodes and
+// the size of the rows of the stars stored in it, in bytes. Indexes and the overhead of the tables aren't included
+func (s *Store) TreeFootprint(index int64) (nodeBytes, starBytes int64, err error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	query := fmt.Sprintf("SELECT max(timestep) FROM nodes")
-	err := db.QueryRow(query).Scan(&maxTimestep)
+	query := `SELECT
+		(SELECT COALESCE(sum(pg_column_size(nodes.*)), 0) FROM nodes WHERE timestep=$1),
+		(SELECT COALESCE(sum(pg_column_size(stars.*)), 0) FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1))`
+	err = s.q.QueryRowContext(ctx, query, index).Scan(&nodeBytes, &starBytes)
 	if err != nil {
-		log.Fatalf("[ E ] getMaxTimestep query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, 0, fmt.Errorf("TreeFootprint query: %v", err)
 	}
 
-	return maxTimestep
+	return nodeBytes, starBytes, nil
 }
 
-// newNode Inserts a new node into the database with the given parameters
-func newNode(x float64, y float64, width float64, depth int64, timestep int64) int64 {
-	// build the query creating a new node
-	query := fmt.Sprintf("INSERT INTO nodes (box_center, box_width, depth, isleaf, timestep) VALUES ('{%f, %f}', %f, %d, TRUE, %d) RETURNING node_id", x, y, width, depth, timestep)
+// TreeFootprint is Store.TreeFootprint using the given database
+func TreeFootprint(database *sql.DB, index int64) (nodeBytes, starBytes int64, err error) {
+	return NewStore(database).TreeFootprint(index)
+}
 
-	var nodeID int64
+// ErrNoCurrentTree is returned by CurrentTree if no tree has been published yet
+var ErrNoCurrentTree = errors.New("no tree has been published")
 
-	// execute the query
-	err := db.QueryRow(query).Scan(&nodeID)
-	if err != nil {
-		log.Fatalf("[ E ] newNode query: %v\n\t\t\t query: %s\n", err, query)
+// PublishTree makes the tree with the given index the current tree returned by CurrentTree. A tree should only be
+// published once it is fully built, so that readers never see a partially built tree
+func (s *Store) PublishTree(index int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.rootNodeIDContext(ctx, index); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO current_tree (index) VALUES ($1) ON CONFLICT (id) DO UPDATE SET index=EXCLUDED.index"
+	if _, err := s.q.ExecContext(ctx, query, index); err != nil {
+		return fmt.Errorf("PublishTree query: %v", err)
 	}
 
-	return nodeID
+	return nil
 }
 
-// getStarID returns the id of the star inside of the node with the given ID
-func getStarID(nodeID int64) int64 {
-	// get the star id from the node
-	var starID int64
-	query := fmt.Sprintf("SELECT star_id FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&starID)
+// PublishTree is Store.PublishTree using the given database
+func PublishTree(database *sql.DB, index int64) error {
+	return NewStore(database).PublishTree(index)
+}
+
+// CurrentTree returns the index of the tree published last using PublishTree
+func (s *Store) CurrentTree() (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var index int64
+
+	err := s.q.QueryRowContext(ctx, "SELECT index FROM current_tree").Scan(&index)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoCurrentTree
+	}
 	if err != nil {
-		log.Fatalf("[ E ] getStarID id query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, fmt.Errorf("CurrentTree query: %v", err)
 	}
 
-	return starID
+	return index, nil
 }
 
-// deleteAll Stars deletes all the rows in the stars table
-func DeleteAllStars(database *sql.DB) {
-	db = database
-	// build the query creating a new node
-	query := "DELETE FROM stars WHERE TRUE"
+// CurrentTree is Store.CurrentTree using the given database
+func CurrentTree(database *sql.DB) (int64, error) {
+	return NewStore(database).CurrentTree()
+}
 
-	// execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+// StarCountsByTimestep returns the number of stars stored in every timestep of the simulation starting with the
+// tree with the given index (the first timestep of the simulation), so lost stars show up as a decreasing count.
+// Timesteps without any stars are contained with a count of zero
+func (s *Store) StarCountsByTimestep(index int64) (map[int64]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := "SELECT timestep, count(NULLIF(star_id, 0)) FROM nodes WHERE timestep>=$1 GROUP BY timestep"
+	rows, err := s.q.QueryContext(ctx, query, index)
 	if err != nil {
-		log.Fatalf("[ E ] deleteAllStars query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, fmt.Errorf("StarCountsByTimestep query: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int64)
+	for rows.Next() {
+		var timestep, count int64
+		if err := rows.Scan(&timestep, &count); err != nil {
+			return nil, fmt.Errorf("StarCountsByTimestep scan: %v", err)
+		}
+		counts[timestep] = count
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("StarCountsByTimestep rows: %v", err)
+	}
+
+	return counts, nil
 }
 
-// deleteAll Stars deletes all the rows in the nodes table
-func DeleteAllNodes(database *sql.DB) {
-	db = database
-	// build the query creating a new node
-	query := "DELETE FROM nodes WHERE TRUE"
+// StarCountsByTimestep is Store.StarCountsByTimestep using the given database
+func StarCountsByTimestep(database *sql.DB, index int64) (map[int64]int64, error) {
+	return NewStore(database).StarCountsByTimestep(index)
+}
 
-	// execute the query
-	_, err := db.Query(query)
+// insertStar inserts the given star into the stars table and the nodes table tree
+// If there is no tree with the given index, a new tree is created using the next free index (which isn't necessarily
+// the given one) and the star is inserted into it, see InsertStarStrict
+func (s *Store) InsertStar(star structs.Star2D, index int64) int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	starID, err := s.insertStar(ctx, star, index, nil)
 	if err != nil {
-		log.Fatalf("[ E ] deleteAllStars query: %v\n\t\t\t query: %s\n", err, query)
+		log.Fatalf("[ E ] %v\n", err)
 	}
+	s.notifyChange("insert %d", index)
+
+	return starID
 }
 
-// getNodeDepth returns the depth of the given node in the tree
-func getNodeDepth(nodeID int64) int64 {
-	// build the query
-	query := fmt.Sprintf("SELECT depth FROM nodes WHERE node_id=%d", nodeID)
+// InsertStar is Store.InsertStar using the given database
+func InsertStar(database *sql.DB, star structs.Star2D, index int64) int64 {
+	return NewStore(database).InsertStar(star, index)
+}
 
-	var depth int64
+// InsertStarStrict inserts the given star into the tree with the given index like InsertStar, but doesn't create a
+// new tree if there is no tree with the given index. ErrTreeNotFound is returned instead and the star isn't
+// inserted into the stars table either
+func (s *Store) InsertStarStrict(star structs.Star2D, index int64) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// Execute the query
-	err := db.QueryRow(query).Scan(&depth)
+	starID, err := s.insertStarStrictContext(ctx, star, index)
 	if err != nil {
-		log.Fatalf("[ E ] getNodeDepth query: %v \n\t\t\t query: %s\n", err, query)
+		return 0, err
 	}
+	s.notifyChange("insert %d", index)
 
-	return depth
+	return starID, nil
 }
 
-// quadrant returns the quadrant into which the given star belongs
-func quadrant(star structs.Star2D, nodeID int64) int64 {
-	// get the center of the node the star is in
-	center := getBoxCenter(nodeID)
-	centerX := center[0]
-	centerY := center[1]
+// insertStarStrictContext is InsertStarStrict using the given context for the queries, without the notification
+func (s *Store) insertStarStrictContext(ctx context.Context, star structs.Star2D, index int64) (int64, error) {
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("Inserting the star %v into the tree with the index %d", star, index)
+
+	starID, err := s.insertIntoStars(ctx, star)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.insertIntoTree(ctx, starID, rootID); err != nil {
+		return 0, err
+	}
+
+	return starID, nil
+}
+
+// InsertStarStrict is Store.InsertStarStrict using the given database
+func InsertStarStrict(database *sql.DB, star structs.Star2D, index int64) (int64, error) {
+	return NewStore(database).InsertStarStrict(star, index)
+}
+
+// InsertStarTraced inserts the given star like InsertStar and additionally returns the ids of the nodes visited
+// while inserting the star, starting at the root and ending at the leaf the star was inserted into
+func (s *Store) InsertStarTraced(star structs.Star2D, index int64) (int64, []int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var path []int64
+	starID, err := s.insertStar(ctx, star, index, &path)
+	if err != nil {
+		return 0, nil, err
+	}
+	s.notifyChange("insert %d", index)
+
+	return starID, path, nil
+}
+
+// InsertStarTraced is Store.InsertStarTraced using the given database
+func InsertStarTraced(database *sql.DB, star structs.Star2D, index int64) (int64, []int64, error) {
+	return NewStore(database).InsertStarTraced(star, index)
+}
+
+// insertStar inserts the given star into the stars table and the nodes table tree.
+// If path is not nil, the ids of the nodes visited while inserting the star are appended to it. In contrast to
+// InsertStar, no notification is sent, so that operations inserting several stars can notify once
+func (s *Store) insertStar(ctx context.Context, star structs.Star2D, index int64, path *[]int64) (int64, error) {
+	start := time.Now()
+
+	log.Printf("Inserting the star %v into the tree with the index %d", star, index)
+
+	// insert the star into the stars table
+	starID, err := s.insertIntoStars(ctx, star)
+	if err != nil {
+		return 0, err
+	}
+
+	// get the root node id
+	query := "select case when exists (select node_id from nodes where root_id=$1) then (select node_id from nodes where root_id=$1) else -1 end;"
+	var id int64
+	err = s.q.QueryRowContext(ctx, query, index).Scan(&id)
+
+	// if there are no rows in the result set, create a new tree
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("Get root node id query: %v", err))
+	}
+
+	if id == -1 {
+		index, id, err = s.newTreeAt(ctx, structs.Vec2{}, 1000)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	log.Printf("Node id of the root node %d: %d", id, index)
+
+	// insert the star into the tree (using it's ID) starting at the root
+	if err := s.insertIntoTreeTraced(ctx, starID, id, path); err != nil {
+		return 0, err
+	}
+	elapsedTime := time.Since(start)
+	log.Printf("\t\t\t\t\t %s", elapsedTime)
+	return starID, nil
+}
+
+// insertStars inserts the given stars into the tree with the given index like InsertStar and returns their ids in
+// the order of the stars. No notification is sent, the operations using it notify once when they are done
+func (s *Store) insertStars(ctx context.Context, stars []structs.Star2D, index int64) ([]int64, error) {
+	starIDs := make([]int64, len(stars))
+	for i, star := range stars {
+		starID, err := s.insertStar(ctx, star, index, nil)
+		if err != nil {
+			return nil, err
+		}
+		starIDs[i] = starID
+	}
+
+	return starIDs, nil
+}
+
+// formatFloat formats the given float for use in exports. The formatting never depends on the locale
+// (the decimal separator is always a '.') and doesn't lose precision like %f
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatRounded formats the given float rounded to an integer, see formatFloat
+func formatRounded(f float64) string {
+	return strconv.FormatFloat(f, 'f', 0, 64)
+}
+
+// insertIntoStars inserts the given star into the stars table. The velocity of the star is stored in vx and vy,
+// the force acting on it (fx and fy) is left empty until it is calculated
+func (s *Store) insertIntoStars(ctx context.Context, star structs.Star2D) (int64, error) {
+	// unpack the star
+	x := star.C.X
+	y := star.C.Y
+	vx := star.V.X
+	vy := star.V.Y
+	m := star.M
+
+	// build the request query
+	query := "INSERT INTO stars (x, y, vx, vy, m) VALUES ($1, $2, $3, $4, $5) RETURNING star_id"
+
+	// execute the query
+	var starID int64
+	err := s.q.QueryRowContext(ctx, query, x, y, vx, vy, m).Scan(&starID)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("insert query: %v", err))
+	}
+
+	return starID, nil
+}
+
+// insert into tree inserts the given star into the tree starting at the node with the given node id
+func (s *Store) insertIntoTree(ctx context.Context, starID int64, nodeID int64) error {
+	return s.insertIntoTreeTraced(ctx, starID, nodeID, nil)
+}
+
+// insertIntoTreeTraced inserts the given star into the tree starting at the node with the given node id.
+// If path is not nil, the ids of the nodes visited by the star (not by the stars it displaces) are appended to it
+func (s *Store) insertIntoTreeTraced(ctx context.Context, starID int64, nodeID int64, path *[]int64) error {
+	// a node that is visited again after being subdivided is only recorded once
+	if path != nil && (len(*path) == 0 || (*path)[len(*path)-1] != nodeID) {
+		*path = append(*path, nodeID)
+	}
+
+	//starRaw := GetStar(starID)
+	//nodeCenter := getBoxCenter(nodeID)
+	//nodeWidth := getBoxWidth(nodeID)
+	//log.Printf("[   ] \t Inserting star %v into the node (c: %v, w: %v)", starRaw, nodeCenter, nodeWidth)
+
+	// There exist four cases:
+	//                    | Contains a Star | Does not Contain a Star |
+	// ------------------ + --------------- + ----------------------- +
+	// Node is a Leaf     | Impossible      | insert into node        |
+	//                    |                 | subdivide               |
+	// ------------------ + --------------- + ----------------------- +
+	// Node is not a Leaf | insert preexist | insert into the subtree |
+	//                    | insert new      |                         |
+	// ------------------ + --------------- + ----------------------- +
+
+	// get the node with the given nodeID
+	// find out if the node contains a star or not
+	containsStar, err := s.containsStar(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// find out if the node is a leaf
+	isLeaf, err := s.isLeaf(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// if the node is a leaf and contains a star
+	// subdivide the tree
+	// insert the preexisting star into the correct subtree
+	// insert the new star into the subtree
+	if isLeaf == true && containsStar == true {
+		//log.Printf("Case 1, \t %v \t %v", nodeWidth, nodeCenter)
+		if err := s.subdivide(ctx, nodeID); err != nil {
+			return err
+		}
+		//tree := printTree(nodeID)
+
+		// Stage 1: Inserting the blocking star
+		if err := s.moveBlockingStar(ctx, nodeID); err != nil {
+			return err
+		}
+
+		// Stage 2: Inserting the actual star into the subdivided node
+		return s.insertIntoTreeTraced(ctx, starID, nodeID, path)
+	}
+
+	// if the node is a leaf and does not contain a star
+	// insert the star into the node and subdivide it
+	if isLeaf == true && containsStar == false {
+		//log.Printf("Case 2, \t %v \t %v", nodeWidth, nodeCenter)
+		return s.directInsert(ctx, starID, nodeID)
+	}
+
+	// if the node is not a leaf and contains a star
+	// insert the preexisting star into the correct subtree
+	// insert the new star into the subtree
+	if isLeaf == false && containsStar == true {
+		//log.Printf("Case 3, \t %v \t %v", nodeWidth, nodeCenter)
+		// Stage 1: Inserting the blocking star
+		if err := s.moveBlockingStar(ctx, nodeID); err != nil {
+			return err
+		}
+
+		// Stage 2: Inserting the actual star into the node that doesn't contain a star anymore
+		return s.insertIntoTreeTraced(ctx, starID, nodeID, path)
+	}
+
+	// if the node is not a leaf and does not contain a star
+	// insert the new star into the according subtree
+	//log.Printf("Case 4, \t %v \t %v", nodeWidth, nodeCenter)
+	star, err := s.getStarContext(ctx, starID) // get the actual star
+	if err != nil {
+		return err
+	}
+	starQuadrant, err := s.quadrant(ctx, star, nodeID) // find out in which quadrant it belongs
+	if err != nil {
+		return err
+	}
+	quadrantNodeID, err := s.getQuadrantNodeID(ctx, nodeID, starQuadrant) // get the if of that quadrant
+	if err != nil {
+		return err
+	}
+	return s.insertIntoTreeTraced(ctx, starID, quadrantNodeID, path) // insert the star into that quadrant
+}
+
+// moveBlockingStar moves the star stored in the subdivided node with the given id into the child of the node it
+// belongs to, so that the node doesn't contain a star anymore
+func (s *Store) moveBlockingStar(ctx context.Context, nodeID int64) error {
+	blockingStarID, err := s.getStarIDContext(ctx, nodeID) // get the id of the star blocking the node
+	if err != nil {
+		return err
+	}
+	blockingStar, err := s.getStarContext(ctx, blockingStarID) // get the actual star
+	if err != nil {
+		return err
+	}
+	blockingStarQuadrant, err := s.quadrant(ctx, blockingStar, nodeID) // find out in which quadrant it belongs
+	if err != nil {
+		return err
+	}
+	quadrantNodeID, err := s.getQuadrantNodeID(ctx, nodeID, blockingStarQuadrant) // get the nodeID of that quadrant
+	if err != nil {
+		return err
+	}
+	if err := s.insertIntoTree(ctx, blockingStarID, quadrantNodeID); err != nil { // insert the star into that node
+		return err
+	}
+
+	return s.removeStarFromNode(ctx, nodeID) // remove the blocking star from the node it was blocking
+}
+
+// containsStar returns true if the node with the given id contains a star and returns false if not.
+func (s *Store) containsStar(ctx context.Context, id int64) (bool, error) {
+	var starID int64
+
+	query := "SELECT star_id FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, id).Scan(&starID)
+	if err != nil {
+		return false, contextError(ctx, fmt.Errorf("containsStar query: %v", err))
+	}
+
+	if starID != 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// isLeaf returns true if the node with the given id is a leaf.
+// This is derived from the subnode array instead of the isleaf column, which can be out of sync (see HasChildren)
+func (s *Store) isLeaf(ctx context.Context, nodeID int64) (bool, error) {
+	children, err := s.hasChildren(ctx, nodeID)
+	return !children, err
+}
+
+// directInsert inserts the star with the given ID into the given node inside of the given database
+func (s *Store) directInsert(ctx context.Context, starID int64, nodeID int64) error {
+	// build the query
+	query := "UPDATE nodes SET star_id=$1 WHERE node_id=$2"
+
+	// Execute the query
+	_, err := s.q.ExecContext(ctx, query, starID, nodeID)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("directInsert query: %v", err))
+	}
+
+	return nil
+}
+
+// subdivide subdivides the given node creating four child nodes.
+// If the node already has children, nothing is done: creating new children would overwrite the existing ones,
+// orphaning them and the stars stored inside of them
+func (s *Store) subdivide(ctx context.Context, nodeID int64) error {
+	children, err := s.hasChildren(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if children {
+		log.Printf("[ ! ] Not subdividing %d, the node already has children", nodeID)
+		return nil
+	}
+
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	timestep, err := s.getTimestepNode(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Subdividing %d, setting the timestep to %d", nodeID, timestep)
+
+	// calculate the new positions: box_width is the full width of a box, so the children are half as wide and
+	// centered a quarter of the width away from the center. Trees subdivided by earlier versions stored half widths
+	// instead, MigrateTables converts them
+	newPosX := n.BoxCenter.X + (n.BoxWidth / 4)
+	newPosY := n.BoxCenter.Y + (n.BoxWidth / 4)
+	newNegX := n.BoxCenter.X - (n.BoxWidth / 4)
+	newNegY := n.BoxCenter.Y - (n.BoxWidth / 4)
+	newWidth := n.BoxWidth / 2
+
+	// create new news with those positions
+	var newNodeIDs [4]int64
+	for i, position := range [4]structs.Vec2{
+		{X: newPosX, Y: newPosY}, {X: newPosX, Y: newNegY}, {X: newNegX, Y: newPosY}, {X: newNegX, Y: newNegY},
+	} {
+		newNodeIDs[i], err = s.newNode(ctx, position.X, position.Y, newWidth, n.Depth+1, timestep)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update the subtrees of the parent node
+
+	// build the query
+	query := "UPDATE nodes SET subnode=ARRAY[$1, $2, $3, $4]::bigint[], isleaf=FALSE, timestep=$5 WHERE node_id=$6"
+
+	// Execute the query
+	_, err = s.q.ExecContext(ctx, query, newNodeIDs[0], newNodeIDs[1], newNodeIDs[2], newNodeIDs[3], timestep, nodeID)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("subdivide query: %v", err))
+	}
+
+	return nil
+}
+
+// RepairSubnodeArrays normalizes the subnode arrays of all the nodes that aren't made up of exactly four
+// non-NULL node ids: missing subnode arrays and missing elements are replaced by 0 (no child) and additional
+// elements are dropped. The number of repaired nodes is returned
+func (s *Store) RepairSubnodeArrays() (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `UPDATE nodes SET subnode=ARRAY[COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0),
+		COALESCE(subnode[4], 0)]::bigint[]
+		WHERE subnode IS NULL OR array_length(subnode, 1) IS DISTINCT FROM 4 OR array_position(subnode, NULL) IS NOT NULL`
+	result, err := s.q.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("RepairSubnodeArrays query: %v", err)
+	}
+
+	repaired, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("RepairSubnodeArrays rows affected: %v", err)
+	}
+
+	return repaired, nil
+}
+
+// RepairSubnodeArrays is Store.RepairSubnodeArrays using the given database
+func RepairSubnodeArrays(database *sql.DB) (int64, error) {
+	return NewStore(database).RepairSubnodeArrays()
+}
+
+// HasChildren returns true if the subnode array of the node with the given id references any children.
+// This is the authoritative way to tell whether a node is a leaf, the isleaf column is only kept for compatibility
+// and can be out of sync with the subnode array (see InconsistentLeafNodes)
+func (s *Store) HasChildren(nodeID int64) (bool, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.hasChildren(ctx, nodeID)
+}
+
+// hasChildren is HasChildren using the given context for the query
+func (s *Store) hasChildren(ctx context.Context, nodeID int64) (bool, error) {
+	var children bool
+
+	query := "SELECT COALESCE(0<>ANY(subnode), FALSE) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&children)
+	if err != nil {
+		return false, contextError(ctx, fmt.Errorf("HasChildren query (node %d): %v", nodeID, err))
+	}
+
+	return children, nil
+}
+
+// HasChildren is Store.HasChildren using the given database
+func HasChildren(database *sql.DB, nodeID int64) (bool, error) {
+	return NewStore(database).HasChildren(nodeID)
+}
+
+// InconsistentLeafNodes returns the ids of the nodes whose isleaf column doesn't match their subnode array, i.e.
+// leaves that have children or inner nodes without any children
+func (s *Store) InconsistentLeafNodes() ([]int64, error) {
+	query := "SELECT node_id FROM nodes WHERE COALESCE(isleaf, FALSE) = COALESCE(0<>ANY(subnode), FALSE) ORDER BY node_id"
+	ids, err := s.queryIDs(query)
+	if err != nil {
+		return nil, fmt.Errorf("InconsistentLeafNodes query: %v", err)
+	}
+
+	return ids, nil
+}
+
+// InconsistentLeafNodes is Store.InconsistentLeafNodes using the given database
+func InconsistentLeafNodes(database *sql.DB) ([]int64, error) {
+	return NewStore(database).InconsistentLeafNodes()
+}
+
+// ValidateNodeInvariant checks that the node with the given id is either a leaf (possibly storing a star) or an
+// internal node (with children, but without a star). An error describing the violation is returned for internal
+// nodes storing a star, which insertIntoTree never leaves behind, and for leaves whose isleaf column claims that
+// they are internal nodes
+func (s *Store) ValidateNodeInvariant(nodeID int64) error {
+	n, err := s.getNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if n.hasSubnodes() && n.StarID != 0 {
+		return fmt.Errorf("node %d has children and stores the star %d", nodeID, n.StarID)
+	}
+	if !n.hasSubnodes() && !n.IsLeaf {
+		return fmt.Errorf("node %d doesn't have any children, but isn't marked as a leaf", nodeID)
+	}
+
+	return nil
+}
+
+// ValidateNodeInvariant is Store.ValidateNodeInvariant using the given database
+func ValidateNodeInvariant(database *sql.DB, nodeID int64) error {
+	return NewStore(database).ValidateNodeInvariant(nodeID)
+}
+
+// ValidateSubdivision checks that the boxes of the four children of the node with the given id exactly partition
+// the box of the node: every child has to be half as wide as the node and be centered in a different quadrant of
+// it, so that there are neither gaps nor overlaps. Leaves are always valid. An error describing the violation is
+// returned otherwise
+func (s *Store) ValidateSubdivision(nodeID int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	if !n.hasSubnodes() {
+		return nil
+	}
+
+	// allow for rounding errors when comparing the geometry
+	tolerance := n.BoxWidth * 1e-9
+	childWidth := n.BoxWidth / 2
+
+	var covered [4]bool
+	for _, subnodeID := range n.Subnodes {
+		if subnodeID == 0 {
+			return fmt.Errorf("node %d has less than four children", nodeID)
+		}
+
+		child, err := s.getNodeContext(ctx, subnodeID)
+		if err != nil {
+			return err
+		}
+
+		if math.Abs(child.BoxWidth-childWidth) > tolerance {
+			return fmt.Errorf("child %d of node %d is %v wide, want %v", subnodeID, nodeID, child.BoxWidth, childWidth)
+		}
+
+		dx := child.BoxCenter.X - n.BoxCenter.X
+		dy := child.BoxCenter.Y - n.BoxCenter.Y
+		if math.Abs(math.Abs(dx)-childWidth/2) > tolerance || math.Abs(math.Abs(dy)-childWidth/2) > tolerance {
+			return fmt.Errorf("child %d of node %d isn't centered in a quadrant of the node", subnodeID, nodeID)
+		}
+
+		q := quadrantOf(child.BoxCenter, n.BoxCenter)
+		if covered[q] {
+			return fmt.Errorf("children of node %d overlap in quadrant %d", nodeID, q)
+		}
+		covered[q] = true
+	}
+
+	return nil
+}
+
+// ValidateSubdivision is Store.ValidateSubdivision using the given database
+func ValidateSubdivision(database *sql.DB, nodeID int64) error {
+	return NewStore(database).ValidateSubdivision(nodeID)
+}
+
+// getBoxWidth gets the width of the box from the node width the given id
+func (s *Store) getBoxWidth(nodeID int64) float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	boxWidth, err := s.getBoxWidthContext(ctx, nodeID)
+	if err != nil {
+		log.Fatalf("[ E ] %v\n", err)
+	}
+
+	return boxWidth
+}
+
+// getBoxWidthContext is getBoxWidth using the given context for the query
+func (s *Store) getBoxWidthContext(ctx context.Context, nodeID int64) (float64, error) {
+	var boxWidth float64
+
+	query := "SELECT box_width FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&boxWidth)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("getBoxWidth query: %v", err))
+	}
+
+	return boxWidth, nil
+}
+
+// getTimestepNode gets the timestep of the current node
+func (s *Store) getTimestepNode(ctx context.Context, nodeID int64) (int64, error) {
+	var timestep int64
+
+	query := "SELECT timestep FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&timestep)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("getTimeStep query: %v", err))
+	}
+
+	return timestep, nil
+}
+
+// getBoxCenter gets the center of the box from the node width the given id
+func (s *Store) getBoxCenter(nodeID int64) []float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var boxCenterX, boxCenterY []uint8
+
+	query := "SELECT box_center[1], box_center[2] FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&boxCenterX, &boxCenterY)
+	if err != nil {
+		log.Fatalf("[ E ] getBoxCenter query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	x, err := strconv.ParseFloat(string(boxCenterX), 64)
+	if err != nil {
+		log.Fatalf("[ E ] parse boxCenter x: %v\n\t\t\t query: %s\n", err, query)
+	}
+	y, err := strconv.ParseFloat(string(boxCenterY), 64)
+	if err != nil {
+		log.Fatalf("[ E ] parse boxCenter y: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	boxCenterFloat := []float64{x, y}
+
+	return boxCenterFloat
+}
+
+// getMaxTimestep gets the maximal timestep from the nodes table
+func (s *Store) getMaxTimestep() float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var maxTimestep float64
+
+	query := "SELECT max(timestep) FROM nodes"
+	err := s.q.QueryRowContext(ctx, query).Scan(&maxTimestep)
+	if err != nil {
+		log.Fatalf("[ E ] getMaxTimestep query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	return maxTimestep
+}
+
+// newNode Inserts a new node into the database with the given parameters
+func (s *Store) newNode(ctx context.Context, x float64, y float64, width float64, depth int64, timestep int64) (int64, error) {
+	// build the query creating a new node
+	query := "INSERT INTO nodes (box_center, box_width, depth, isleaf, timestep, star_id, subnode) VALUES (ARRAY[$1, $2]::numeric[], $3, $4, TRUE, $5, 0, '{0, 0, 0, 0}') RETURNING node_id"
+
+	var nodeID int64
+
+	// execute the query
+	err := s.q.QueryRowContext(ctx, query, x, y, width, depth, timestep).Scan(&nodeID)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("newNode query: %v", err))
+	}
+
+	return nodeID, nil
+}
+
+// getStarID returns the id of the star inside of the node with the given ID
+func (s *Store) getStarID(nodeID int64) int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	starID, err := s.getStarIDContext(ctx, nodeID)
+	if err != nil {
+		log.Fatalf("[ E ] %v\n", err)
+	}
+
+	return starID
+}
+
+// getStarIDContext is getStarID using the given context for the query
+func (s *Store) getStarIDContext(ctx context.Context, nodeID int64) (int64, error) {
+	// get the star id from the node
+	var starID int64
+	query := "SELECT star_id FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&starID)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("getStarID id query: %v", err))
+	}
+
+	return starID, nil
+}
+
+// deleteAll Stars deletes all the rows in the stars table
+func (s *Store) DeleteAllStars() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if err := s.deleteAllStarsContext(ctx); err != nil {
+		log.Fatalf("[ E ] %v", err)
+	}
+}
+
+// deleteAllStarsContext is DeleteAllStars using the given context for the query, returning the error
+func (s *Store) deleteAllStarsContext(ctx context.Context) error {
+	query := "DELETE FROM stars WHERE TRUE"
+	if _, err := s.q.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("deleteAllStars query: %v\n\t\t\t query: %s", err, query)
+	}
+
+	return nil
+}
+
+// DeleteAllStars is Store.DeleteAllStars using the given database
+func DeleteAllStars(database *sql.DB) {
+	NewStore(database).DeleteAllStars()
+}
+
+// deleteAll Stars deletes all the rows in the nodes table
+func (s *Store) DeleteAllNodes() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if err := s.deleteAllNodesContext(ctx); err != nil {
+		log.Fatalf("[ E ] %v", err)
+	}
+}
+
+// deleteAllNodesContext is DeleteAllNodes using the given context for the query, returning the error
+func (s *Store) deleteAllNodesContext(ctx context.Context) error {
+	query := "DELETE FROM nodes WHERE TRUE"
+	if _, err := s.q.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("deleteAllNodes query: %v\n\t\t\t query: %s", err, query)
+	}
+
+	return nil
+}
+
+// DeleteAllNodes is Store.DeleteAllNodes using the given database
+func DeleteAllNodes(database *sql.DB) {
+	NewStore(database).DeleteAllNodes()
+}
+
+// reachableNodesCTE is a common table expression named reachable containing the ids of all the nodes that can be
+// reached by walking down the subnode arrays starting at the root nodes
+const reachableNodesCTE = `WITH RECURSIVE reachable(node_id) AS (
+	SELECT node_id FROM nodes WHERE COALESCE(root_id, 0)<>0
+	UNION
+	SELECT child FROM reachable JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+)`
+
+// treeNodesCTE is a common table expression named tree containing the ids of all the nodes of the tree whose index
+// is given as the first query parameter
+const treeNodesCTE = `WITH RECURSIVE tree(node_id) AS (
+	SELECT node_id FROM nodes WHERE root_id=$1
+	UNION
+	SELECT child FROM tree JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+)`
+
+// subtreeNodesCTE is a common table expression named subtree containing the ids of the node given as the first
+// query parameter and all of its descendants
+const subtreeNodesCTE = `WITH RECURSIVE subtree(node_id) AS (
+	SELECT $1::bigint
+	UNION
+	SELECT child FROM subtree JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+)`
+
+// FindOrphans returns the ids of the nodes that aren't reachable from any root node and the ids of the stars that
+// aren't referenced by any node. Soft deleted stars aren't referenced by any node on purpose, so they aren't orphans
+func (s *Store) FindOrphans() (orphanNodes, orphanStars []int64, err error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := reachableNodesCTE + " SELECT node_id FROM nodes WHERE node_id NOT IN (SELECT node_id FROM reachable) ORDER BY node_id"
+	orphanNodes, err = s.queryIDsContext(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("FindOrphans nodes query: %v", err)
+	}
+
+	orphanStars, err = s.queryIDsContext(ctx, unplacedStarsQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("FindOrphans stars query: %v", err)
+	}
+
+	return orphanNodes, orphanStars, nil
+}
+
+// FindOrphans is Store.FindOrphans using the given database
+func FindOrphans(database *sql.DB) (orphanNodes, orphanStars []int64, err error) {
+	return NewStore(database).FindOrphans()
+}
+
+// unplacedStarsQuery selects the ids of the stars that aren't soft deleted and not referenced by any node
+const unplacedStarsQuery = "SELECT star_id FROM stars WHERE NOT deleted AND star_id NOT IN (SELECT star_id FROM nodes WHERE star_id IS NOT NULL) ORDER BY star_id"
+
+// UnplacedStars returns the ids of the stars that are stored in the stars table, but not referenced by any node, e.g.
+// because inserting them into a tree failed. Such stars are invisible to the tree queries, but are contained in
+// GetListOfStarIDs. Soft deleted stars aren't referenced by any node on purpose, so they aren't returned
+func (s *Store) UnplacedStars() ([]int64, error) {
+	ids, err := s.queryIDs(unplacedStarsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("UnplacedStars query: %v", err)
+	}
+
+	return ids, nil
+}
+
+// UnplacedStars is Store.UnplacedStars using the given database
+func UnplacedStars(database *sql.DB) ([]int64, error) {
+	return NewStore(database).UnplacedStars()
+}
+
+// PlaceUnplacedStars inserts all the stars returned by UnplacedStars into the tree with the given index and returns
+// the number of placed stars. Like InsertStar, the total masses and centers of mass of the tree aren't updated.
+// ErrTreeNotFound is returned if there is no tree with the given index
+func (s *Store) PlaceUnplacedStars(index int64) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+
+	starIDs, err := s.queryIDsContext(ctx, unplacedStarsQuery)
+	if err != nil {
+		return 0, fmt.Errorf("UnplacedStars query: %v", err)
+	}
+
+	for _, starID := range starIDs {
+		if err := s.insertIntoTree(ctx, starID, rootID); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(starIDs)), nil
+}
+
+// PlaceUnplacedStars is Store.PlaceUnplacedStars using the given database
+func PlaceUnplacedStars(database *sql.DB, index int64) (int64, error) {
+	return NewStore(database).PlaceUnplacedStars(index)
+}
+
+// GarbageCollect deletes all the nodes that aren't reachable from any root node and all the stars that aren't
+// referenced by any (remaining) node (except for soft deleted stars) in a single transaction and returns the amount of deleted nodes and stars.
+// If dryRun is true, the transaction is rolled back, so only the amounts that would be deleted are returned
+func (s *Store) GarbageCollect(dryRun bool) (removedNodes, removedStars int64, err error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	// the enclosing transaction of a dry run can't be rolled back, only the changes since a savepoint are
+	if tx == nil && dryRun {
+		if _, err := txStore.q.ExecContext(ctx, "SAVEPOINT garbage_collect"); err != nil {
+			return 0, 0, fmt.Errorf("GarbageCollect savepoint: %v", err)
+		}
+	}
+
+	query := reachableNodesCTE + " DELETE FROM nodes WHERE node_id NOT IN (SELECT node_id FROM reachable)"
+	result, err := txStore.q.ExecContext(ctx, query)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect delete nodes query: %v", err)
+	}
+	removedNodes, err = result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect deleted nodes: %v", err)
+	}
+
+	// the stars stored in the deleted nodes are unreferenced now, so they get deleted as well
+	query = "DELETE FROM stars WHERE NOT deleted AND star_id NOT IN (SELECT star_id FROM nodes WHERE star_id IS NOT NULL)"
+	result, err = txStore.q.ExecContext(ctx, query)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect delete stars query: %v", err)
+	}
+	removedStars, err = result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect deleted stars: %v", err)
+	}
+
+	if dryRun {
+		if tx == nil {
+			if _, err := txStore.q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT garbage_collect"); err != nil {
+				return 0, 0, fmt.Errorf("GarbageCollect rollback to savepoint: %v", err)
+			}
+		}
+		return removedNodes, removedStars, nil
+	}
+
+	if err := commitTx(tx); err != nil {
+		return 0, 0, fmt.Errorf("GarbageCollect commit: %v", err)
+	}
+
+	return removedNodes, removedStars, nil
+}
+
+// GarbageCollect is Store.GarbageCollect using the given database
+func GarbageCollect(database *sql.DB, dryRun bool) (removedNodes, removedStars int64, err error) {
+	return NewStore(database).GarbageCollect(dryRun)
+}
+
+// queryIDs executes the given query and returns the ids from the first column of the returned rows
+func (s *Store) queryIDs(query string, args ...interface{}) ([]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.queryIDsContext(ctx, query, args...)
+}
+
+// queryIDsContext is queryIDs using the given context for the query
+func (s *Store) queryIDsContext(ctx context.Context, query string, args ...interface{}) ([]int64, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// RecomputeDepths sets the depth of every node of the tree with the given index to the depth of its parent plus one
+// (the root has the depth 0), repairing depths that don't match the structure of the tree anymore, e.g. after
+// editing the tree manually. The tree is traversed from the root using a single query. ErrTreeNotFound is returned
+// if there is no tree with the given index
+func (s *Store) RecomputeDepths(index int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.rootNodeIDContext(ctx, index); err != nil {
+		return err
+	}
+
+	query := `WITH RECURSIVE tree(node_id, depth) AS (
+			SELECT node_id, 0 FROM nodes WHERE root_id=$1
+			UNION
+			SELECT child, tree.depth+1 FROM tree JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+		)
+		UPDATE nodes SET depth=tree.depth FROM tree WHERE nodes.node_id=tree.node_id AND nodes.depth IS DISTINCT FROM tree.depth`
+	if _, err := s.q.ExecContext(ctx, query, index); err != nil {
+		return fmt.Errorf("RecomputeDepths query: %v", err)
+	}
+
+	return nil
+}
+
+// RecomputeDepths is Store.RecomputeDepths using the given database
+func RecomputeDepths(database *sql.DB, index int64) error {
+	return NewStore(database).RecomputeDepths(index)
+}
+
+// getNodeDepth returns the depth of the given node in the tree
+func (s *Store) getNodeDepth(nodeID int64) int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := "SELECT depth FROM nodes WHERE node_id=$1"
+
+	var depth int64
+
+	// Execute the query
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&depth)
+	if err != nil {
+		log.Fatalf("[ E ] getNodeDepth query: %v \n\t\t\t query: %s\n", err, query)
+	}
+
+	return depth
+}
+
+// quadrant returns the quadrant into which the given star belongs
+func (s *Store) quadrant(ctx context.Context, star structs.Star2D, nodeID int64) (int64, error) {
+	// get the center of the node the star is in
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	return quadrantOf(star.C, n.BoxCenter), nil
+}
+
+// quadrantOf returns the quadrant of a node with the given center into which the position p belongs.
+// This is the only boundary rule used for inserting and finding stars: only positions strictly greater than the
+// center are east or north, so a position exactly on a center line belongs to the west or south
+func quadrantOf(p structs.Vec2, center structs.Vec2) int64 {
+	if p.X > center.X {
+		if p.Y > center.Y {
+			// North East condition
+			return 1
+		}
+		// South East condition
+		return 3
+	}
+
+	if p.Y > center.Y {
+		// North West condition
+		return 0
+	}
+	// South West condition
+	return 2
+}
+
+// getQuadrantNodeID returns the id of the requested child-node
+// Example: if a parent has four children and quadrant 0 is requested, the function returns the north east child id
+func (s *Store) getQuadrantNodeID(ctx context.Context, parentNodeID int64, quadrant int64) (int64, error) {
+	var a, b, c, d []uint8
+
+	// get the star from the stars table
+	query := "SELECT COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, parentNodeID).Scan(&a, &b, &c, &d)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("getQuadrantNodeID star query: %v", err))
+	}
+
+	returnA, _ := strconv.ParseInt(string(a), 10, 64)
+	returnB, _ := strconv.ParseInt(string(b), 10, 64)
+	returnC, _ := strconv.ParseInt(string(c), 10, 64)
+	returnD, _ := strconv.ParseInt(string(d), 10, 64)
+
+	switch quadrant {
+	case 0:
+		return returnA, nil
+	case 1:
+		return returnB, nil
+	case 2:
+		return returnC, nil
+	case 3:
+		return returnD, nil
+	}
+
+	return -1, nil
+}
+
+// GetStar returns the star with the given ID from the stars table.
+// The velocity of the returned star is its velocity, the force last calculated for it is stored separately (see
+// StarsWithForces)
+func (s *Store) GetStar(starID int64) structs.Star2D {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	star, err := s.getStarContext(ctx, starID)
+	if err != nil {
+		log.Fatalf("[ E ] %v\n", err)
+	}
+
+	return star
+}
+
+// getStarContext is GetStar using the given context for the query
+func (s *Store) getStarContext(ctx context.Context, starID int64) (structs.Star2D, error) {
+	var x, y, vx, vy, m float64
+
+	// get the star from the stars table
+	query := "SELECT x, y, vx, vy, m FROM stars WHERE star_id=$1"
+	err := s.q.QueryRowContext(ctx, query, starID).Scan(&x, &y, &vx, &vy, &m)
+	if err != nil {
+		return structs.Star2D{}, contextError(ctx, fmt.Errorf("GetStar query: %v", err))
+	}
+
+	star := structs.Star2D{
+		C: structs.Vec2{
+			X: x,
+			Y: y,
+		},
+		V: structs.Vec2{
+			X: vx,
+			Y: vy,
+		},
+		M: m,
+	}
+
+	return star, nil
+}
+
+// GetStar is Store.GetStar using the given database
+func GetStar(database *sql.DB, starID int64) structs.Star2D {
+	return NewStore(database).GetStar(starID)
+}
+
+// ErrStarNotFound is returned if there is no star with the requested id
+var ErrStarNotFound = errors.New("star not found")
+
+// SetStarMeta stores the given metadata (e.g. the name or the spectral type) with the star with the given id,
+// replacing its previous metadata. The metadata is stored as JSON in the meta column, so the values have to be
+// encodable using encoding/json. ErrStarNotFound is returned if there is no such star
+func (s *Store) SetStarMeta(starID int64, meta map[string]interface{}) error {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("SetStarMeta encode: %v", err)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	result, err := s.q.ExecContext(ctx, "UPDATE stars SET meta=$1 WHERE star_id=$2", string(encoded), starID)
+	if err != nil {
+		return fmt.Errorf("SetStarMeta query: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("SetStarMeta query: %v", err)
+	}
+	if updated == 0 {
+		return ErrStarNotFound
+	}
+
+	return nil
+}
+
+// SetStarMeta is Store.SetStarMeta using the given database
+func SetStarMeta(database *sql.DB, starID int64, meta map[string]interface{}) error {
+	return NewStore(database).SetStarMeta(starID, meta)
+}
+
+// GetStarMeta returns the metadata stored with the star with the given id using SetStarMeta. The metadata is
+// decoded using encoding/json, so numbers are float64s. An empty map is returned if the star doesn't have any
+// metadata, ErrStarNotFound if there is no such star
+func (s *Store) GetStarMeta(starID int64) (map[string]interface{}, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var encoded sql.NullString
+	err := s.q.QueryRowContext(ctx, "SELECT meta FROM stars WHERE star_id=$1", starID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, ErrStarNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetStarMeta query: %v", err)
+	}
+
+	meta := make(map[string]interface{})
+	if encoded.Valid {
+		if err := json.Unmarshal([]byte(encoded.String), &meta); err != nil {
+			return nil, fmt.Errorf("GetStarMeta decode: %v", err)
+		}
+	}
+
+	return meta, nil
+}
+
+// GetStarMeta is Store.GetStarMeta using the given database
+func GetStarMeta(database *sql.DB, starID int64) (map[string]interface{}, error) {
+	return NewStore(database).GetStarMeta(starID)
+}
+
+// StarByExtID returns the id of the star with the given external id (the id of the star in the catalog it was
+// imported from, see UpsertStarByExtID) and the star itself. ErrStarNotFound is returned if there is no such star
+func (s *Store) StarByExtID(extID string) (int64, structs.Star2D, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.starByExtIDContext(ctx, extID)
+}
+
+// starByExtIDContext is StarByExtID using the given context for the query
+func (s *Store) starByExtIDContext(ctx context.Context, extID string) (int64, structs.Star2D, error) {
+	var starID int64
+	var star structs.Star2D
+
+	query := "SELECT star_id, x, y, vx, vy, m FROM stars WHERE ext_id=$1"
+	err := s.q.QueryRowContext(ctx, query, extID).Scan(&starID, &star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M)
+	if err == sql.ErrNoRows {
+		return 0, star, ErrStarNotFound
+	}
+	if err != nil {
+		return 0, star, fmt.Errorf("StarByExtID query: %v", err)
+	}
+
+	return starID, star, nil
+}
+
+// StarByExtID is Store.StarByExtID using the given database
+func StarByExtID(database *sql.DB, extID string) (int64, structs.Star2D, error) {
+	return NewStore(database).StarByExtID(extID)
+}
+
+// UpsertStarByExtID inserts the given star with the given external id into the tree with the given index. If there
+// already is a star with that external id, the star is updated (and moved inside of the tree, see MoveStar) instead.
+// The id of the inserted or updated star is returned. ErrTreeNotFound is returned if there is no tree with the
+// given index
+func (s *Store) UpsertStarByExtID(index int64, extID string, star structs.Star2D) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	starID, _, err := s.starByExtIDContext(ctx, extID)
+	if err == ErrStarNotFound {
+		starID, err = s.insertStarStrictContext(ctx, star, index)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := s.q.ExecContext(ctx, "UPDATE stars SET ext_id=$1 WHERE star_id=$2", extID, starID); err != nil {
+			return 0, fmt.Errorf("UpsertStarByExtID query: %v", err)
+		}
+		s.notifyChange("insert %d", index)
+
+		return starID, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.q.ExecContext(ctx, "UPDATE stars SET vx=$1, vy=$2, m=$3 WHERE star_id=$4", star.V.X, star.V.Y, star.M, starID)
+	if err != nil {
+		return 0, fmt.Errorf("UpsertStarByExtID query: %v", err)
+	}
+
+	if err := s.moveStarContext(ctx, starID, index, star.C); err != nil {
+		return 0, err
+	}
+
+	return starID, nil
+}
+
+// UpsertStarByExtID is Store.UpsertStarByExtID using the given database
+func UpsertStarByExtID(database *sql.DB, index int64, extID string, star structs.Star2D) (int64, error) {
+	return NewStore(database).UpsertStarByExtID(index, extID, star)
+}
+
+// StarAtCoordinates returns the id of the star closest to the position p and the star itself if it is at most tol
+// away from p. The returned bool is false if there is no such star
+func (s *Store) StarAtCoordinates(p structs.Vec2, tol float64) (int64, structs.Star2D, bool, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var starID int64
+	var star structs.Star2D
+
+	query := fmt.Sprintf(`SELECT star_id, x, y, vx, vy, m FROM stars
+		WHERE %s AND x BETWEEN $1::numeric-$3::numeric AND $1::numeric+$3::numeric
+		AND y BETWEEN $2::numeric-$3::numeric AND $2::numeric+$3::numeric
+		AND (x-$1::numeric)^2 + (y-$2::numeric)^2 <= $3::numeric^2
+		ORDER BY (x-$1::numeric)^2 + (y-$2::numeric)^2, star_id LIMIT 1`, s.deletedFilter())
+	err := s.q.QueryRowContext(ctx, query, p.X, p.Y, tol).Scan(&starID, &star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M)
+	if err == sql.ErrNoRows {
+		return 0, star, false, nil
+	}
+	if err != nil {
+		return 0, star, false, fmt.Errorf("StarAtCoordinates query: %v", err)
+	}
+
+	return starID, star, true, nil
+}
+
+// StarAtCoordinates is Store.StarAtCoordinates using the given database
+func StarAtCoordinates(database *sql.DB, p structs.Vec2, tol float64) (int64, structs.Star2D, bool, error) {
+	return NewStore(database).StarAtCoordinates(p, tol)
+}
+
+// getStarIDTimestep returns the timestep the given starID is currently inside of
+func (s *Store) GetStarIDTimestep(starID int64) int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var timestep int64
+
+	// get the star from the stars table
+	query := "SELECT timestep FROM nodes WHERE star_id=$1"
+	err := s.q.QueryRowContext(ctx, query, starID).Scan(&timestep)
+	if err != nil {
+		log.Fatalf("[ E ] GetStar query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+
+	return timestep
+}
+
+// GetStarIDTimestep is Store.GetStarIDTimestep using the given database
+func GetStarIDTimestep(database *sql.DB, starID int64) int64 {
+	return NewStore(database).GetStarIDTimestep(starID)
+}
+
+// getStarMass returns the mass if the star with the given ID
+func (s *Store) getStarMass(starID int64) float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var mass float64
+
+	// get the star from the stars table
+	query := "SELECT m FROM stars WHERE star_id=$1"
+	err := s.q.QueryRowContext(ctx, query, starID).Scan(&mass)
+	if err != nil {
+		log.Fatalf("[ E ] getStarMass query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+
+	return mass
+}
+
+// StarMass returns the mass of the star with the given ID
+func (s *Store) StarMass(starID int64) (float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var mass float64
+
+	err := s.q.QueryRowContext(ctx, "SELECT m FROM stars WHERE star_id=$1", starID).Scan(&mass)
+	if err != nil {
+		return 0, fmt.Errorf("StarMass query: %v", err)
+	}
+
+	return mass, nil
+}
+
+// StarMass is Store.StarMass using the given database
+func StarMass(database *sql.DB, starID int64) (float64, error) {
+	return NewStore(database).StarMass(starID)
+}
+
+// StarMasses returns the masses of the stars with the given IDs using a single query, e.g. for converting the
+// forces acting on many stars into accelerations. Stars that don't exist are missing from the returned map
+func (s *Store) StarMasses(ids []int64) (map[int64]float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT star_id, m FROM stars WHERE star_id=ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("StarMasses query: %v", err)
+	}
+	defer rows.Close()
+
+	masses := make(map[int64]float64, len(ids))
+	for rows.Next() {
+		var id int64
+		var mass float64
+		if err := rows.Scan(&id, &mass); err != nil {
+			return nil, fmt.Errorf("StarMasses scan: %v", err)
+		}
+		masses[id] = mass
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("StarMasses rows: %v", err)
+	}
+
+	return masses, nil
+}
+
+// StarMasses is Store.StarMasses using the given database
+func StarMasses(database *sql.DB, ids []int64) (map[int64]float64, error) {
+	return NewStore(database).StarMasses(ids)
+}
+
+// SetStarMass sets the mass of the star with the given ID. The total masses of the node containing the star and
+// of all of its ancestors are adjusted by the difference in the same transaction, so they stay valid.
+// The centers of mass depend on the masses as well and have to be updated using UpdateCenterOfMass
+func (s *Store) SetStarMass(starID int64, m float64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("SetStarMass begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	var oldMass float64
+	err = txStore.q.QueryRowContext(ctx, "SELECT m FROM stars WHERE star_id=$1", starID).Scan(&oldMass)
+	if err != nil {
+		return fmt.Errorf("SetStarMass query: %v", err)
+	}
+
+	_, err = txStore.q.ExecContext(ctx, "UPDATE stars SET m=$1 WHERE star_id=$2", m, starID)
+	if err != nil {
+		return fmt.Errorf("SetStarMass update query: %v", err)
+	}
+
+	// walk up the tree starting at the node containing the star
+	query := `WITH RECURSIVE ancestors(node_id) AS (
+		SELECT node_id FROM nodes WHERE star_id=$1
+		UNION
+		SELECT nodes.node_id FROM nodes JOIN ancestors ON ancestors.node_id=ANY(nodes.subnode)
+	)
+	UPDATE nodes SET total_mass=COALESCE(total_mass, 0)+$2 WHERE node_id IN (SELECT node_id FROM ancestors)`
+	_, err = txStore.q.ExecContext(ctx, query, starID, m-oldMass)
+	if err != nil {
+		return fmt.Errorf("SetStarMass total mass query: %v", err)
+	}
+
+	return commitTx(tx)
+}
+
+// SetStarMass is Store.SetStarMass using the given database
+func SetStarMass(database *sql.DB, starID int64, m float64) error {
+	return NewStore(database).SetStarMass(starID, m)
+}
+
+// getNodeTotalMass returns the total mass of the node with the given ID and its children
+func (s *Store) getNodeTotalMass(nodeID int64) float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var mass float64
+
+	// get the star from the stars table
+	query := "SELECT total_mass FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&mass)
+	if err != nil {
+		log.Fatalf("[ E ] getStarMass query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+
+	return mass
+}
+
+// ComputeSubtreeMass returns the sum of the masses of all the stars inside of the node with the given ID and its
+// descendants. In contrast to getNodeTotalMass, the mass is computed by traversing the subtree and is independent
+// of the (possibly stale) total_mass column
+func (s *Store) ComputeSubtreeMass(nodeID int64) (float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var mass float64
+
+	query := subtreeNodesCTE + ` SELECT COALESCE(sum(m), 0) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE node_id IN (SELECT node_id FROM subtree))`
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&mass)
+	if err != nil {
+		return 0, fmt.Errorf("ComputeSubtreeMass query: %v", err)
+	}
+
+	return mass, nil
+}
+
+// ComputeSubtreeMass is Store.ComputeSubtreeMass using the given database
+func ComputeSubtreeMass(database *sql.DB, nodeID int64) (float64, error) {
+	return NewStore(database).ComputeSubtreeMass(nodeID)
+}
+
+// SubtreeOccupancy returns the number of stars stored below each of the four subnodes of the node with the given
+// ID, in the order of the subnode array. All four counts are computed in a single recursive query. The counts of a
+// node without children are all zero
+func (s *Store) SubtreeOccupancy(nodeID int64) ([4]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var occupancy [4]int64
+
+	query := `WITH RECURSIVE quadrants(quadrant, node_id) AS (
+			SELECT q, child FROM nodes, unnest(nodes.subnode) WITH ORDINALITY AS s(child, q)
+			WHERE nodes.node_id=$1 AND child<>0
+			UNION
+			SELECT quadrant, child FROM quadrants JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+		)
+		SELECT quadrant, count(NULLIF(star_id, 0)) FROM quadrants JOIN nodes USING (node_id) GROUP BY quadrant`
+	rows, err := s.q.QueryContext(ctx, query, nodeID)
+	if err != nil {
+		return occupancy, fmt.Errorf("SubtreeOccupancy query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quadrant, count int64
+		if err := rows.Scan(&quadrant, &count); err != nil {
+			return occupancy, fmt.Errorf("SubtreeOccupancy scan: %v", err)
+		}
+		if quadrant >= 1 && quadrant <= 4 {
+			occupancy[quadrant-1] = count
+		}
+	}
+
+	return occupancy, rows.Err()
+}
+
+// SubtreeOccupancy is Store.SubtreeOccupancy using the given database
+func SubtreeOccupancy(database *sql.DB, nodeID int64) ([4]int64, error) {
+	return NewStore(database).SubtreeOccupancy(nodeID)
+}
+
+// SoftDeleteStar marks the star with the given ID as deleted and removes it from the node it is stored in.
+// The row in the stars table is kept, so the star can still be fetched using its id, but it is excluded from the
+// star lists (unless Options.IncludeDeleted is set) and doesn't exert forces anymore
+func (s *Store) SoftDeleteStar(starID int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("SoftDeleteStar begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	_, err = txStore.q.ExecContext(ctx, "UPDATE stars SET deleted=TRUE WHERE star_id=$1", starID)
+	if err != nil {
+		return fmt.Errorf("SoftDeleteStar query: %v", err)
+	}
+
+	_, err = txStore.q.ExecContext(ctx, "UPDATE nodes SET star_id=0 WHERE star_id=$1", starID)
+	if err != nil {
+		return fmt.Errorf("SoftDeleteStar remove from node query: %v", err)
+	}
+
+	return commitTx(tx)
+}
+
+// SoftDeleteStar is Store.SoftDeleteStar using the given database
+func SoftDeleteStar(database *sql.DB, starID int64) error {
+	return NewStore(database).SoftDeleteStar(starID)
+}
+
+// DeleteStar deletes the star with the given ID from the stars table and removes it from the nodes it is stored in.
+// Nodes whose children are all empty leaves afterwards are turned back into leaves, so the tree stays consistent and
+// doesn't keep empty subtrees. Like InsertStar, the total masses and centers of mass of the tree aren't updated.
+// ErrStarNotFound is returned if there is no star with the given ID
+func (s *Store) DeleteStar(starID int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("DeleteStar begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	nodeIDs, err := txStore.queryIDsContext(ctx, "SELECT node_id FROM nodes WHERE star_id=$1", starID)
+	if err != nil {
+		return fmt.Errorf("DeleteStar nodes query: %v", err)
+	}
+	for _, nodeID := range nodeIDs {
+		if err := txStore.removeStarFromNode(ctx, nodeID); err != nil {
+			return err
+		}
+		if err := txStore.collapseEmptyParents(ctx, nodeID); err != nil {
+			return err
+		}
+	}
+
+	res, err := txStore.q.ExecContext(ctx, "DELETE FROM stars WHERE star_id=$1", starID)
+	if err != nil {
+		return fmt.Errorf("DeleteStar query: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrStarNotFound
+	}
+
+	return commitTx(tx)
+}
+
+// DeleteStar is Store.DeleteStar using the given database
+func DeleteStar(database *sql.DB, starID int64) error {
+	return NewStore(database).DeleteStar(starID)
+}
+
+// collapseEmptyParents deletes the children of the parent of the node with the given ID and turns the parent back
+// into a leaf if all of the children are empty leaves. This is repeated up the tree until a node keeps its children
+func (s *Store) collapseEmptyParents(ctx context.Context, nodeID int64) error {
+	for {
+		var parentID int64
+		err := s.q.QueryRowContext(ctx, "SELECT node_id FROM nodes WHERE $1=ANY(subnode)", nodeID).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			// the root node doesn't have a parent
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("collapseEmptyParents parent query: %v", err)
+		}
+
+		var empty bool
+		query := `SELECT count(*)=4 FROM nodes
+			WHERE node_id IN (SELECT unnest(subnode) FROM nodes WHERE node_id=$1)
+			AND COALESCE(star_id, 0)=0 AND NOT COALESCE(0<>ANY(subnode), FALSE)`
+		if err := s.q.QueryRowContext(ctx, query, parentID).Scan(&empty); err != nil {
+			return fmt.Errorf("collapseEmptyParents children query: %v", err)
+		}
+		if !empty {
+			return nil
+		}
+
+		query = "DELETE FROM nodes WHERE node_id IN (SELECT unnest(subnode) FROM nodes WHERE node_id=$1)"
+		if _, err := s.q.ExecContext(ctx, query, parentID); err != nil {
+			return fmt.Errorf("collapseEmptyParents delete query: %v", err)
+		}
+
+		query = "UPDATE nodes SET subnode='{0, 0, 0, 0}', isleaf=TRUE WHERE node_id=$1"
+		if _, err := s.q.ExecContext(ctx, query, parentID); err != nil {
+			return fmt.Errorf("collapseEmptyParents update query: %v", err)
+		}
+
+		nodeID = parentID
+	}
+}
+
+// MoveStar moves the star with the given id to the position p in the tree with the given index. The star is removed
+// from the node it is stored in and inserted again starting at the root, so it ends up in the same leaf findLeaf
+// returns for p, even if p lies exactly on the center lines of a node. Like InsertStar, the total masses and
+// centers of mass of the tree aren't updated
+func (s *Store) MoveStar(starID int64, index int64, p structs.Vec2) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.moveStarContext(ctx, starID, index, p)
+}
+
+// moveStarContext is MoveStar using the given context for the queries
+func (s *Store) moveStarContext(ctx context.Context, starID int64, index int64, p structs.Vec2) error {
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.q.ExecContext(ctx, "UPDATE stars SET x=$1, y=$2 WHERE star_id=$3", p.X, p.Y, starID)
+	if err != nil {
+		return fmt.Errorf("MoveStar query: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("MoveStar: there is no star with the id %d", starID)
+	}
+
+	_, err = s.q.ExecContext(ctx, "UPDATE nodes SET star_id=0 WHERE star_id=$1 AND timestep=$2", starID, index)
+	if err != nil {
+		return fmt.Errorf("MoveStar remove from node query: %v", err)
+	}
+
+	return s.insertIntoTree(ctx, starID, rootID)
+}
+
+// MoveStar is Store.MoveStar using the given database
+func MoveStar(database *sql.DB, starID int64, index int64, p structs.Vec2) error {
+	return NewStore(database).MoveStar(starID, index, p)
+}
+
+// ErrInseparable is returned by SubdivisionDepthForPair if the two positions can't be separated by subdividing
+var ErrInseparable = errors.New("positions can't be separated")
+
+// SubdivisionDepthForPair returns the depth of the nodes the tree has to be subdivided to, so that the positions a
+// and b are stored in different nodes. The tree is assumed to be centered at the origin and to have the given
+// width (like the trees created by NewTree) and the positions are assigned to the subnodes using the same boundary
+// rule as when inserting stars. The depth grows with the logarithm of the width divided by the distance of the
+// positions, which helps choosing a maximal depth or softening length for close pairs of stars.
+// Nothing is queried from the database. ErrInseparable is returned for equal positions
+func (s *Store) SubdivisionDepthForPair(a, b structs.Vec2, width float64) (int64, error) {
+	var center structs.Vec2
+	for depth := int64(0); width > 0; depth++ {
+		if quadrantOf(a, center) != quadrantOf(b, center) {
+			return depth + 1, nil
+		}
+
+		// move on to the subnode both positions belong to, see subdivide
+		width /= 2
+		if a.X > center.X {
+			center.X += width / 2
+		} else {
+			center.X -= width / 2
+		}
+		if a.Y > center.Y {
+			center.Y += width / 2
+		} else {
+			center.Y -= width / 2
+		}
+	}
+
+	return 0, ErrInseparable
+}
+
+// SubdivisionDepthForPair is Store.SubdivisionDepthForPair using the given database
+func SubdivisionDepthForPair(database *sql.DB, a, b structs.Vec2, width float64) (int64, error) {
+	return NewStore(database).SubdivisionDepthForPair(a, b, width)
+}
+
+// findLeaf returns the id of the leaf below the node with the given id covering the position p. The subnodes are
+// chosen using quadrant, like when inserting a star, so a star at p is always stored in the returned leaf
+func (s *Store) findLeaf(nodeID int64, p structs.Vec2) int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	for {
+		isLeaf, err := s.isLeaf(ctx, nodeID)
+		if err != nil {
+			log.Fatalf("[ E ] findLeaf: %v", err)
+		}
+		if isLeaf {
+			return nodeID
+		}
+
+		quadrant, err := s.quadrant(ctx, structs.Star2D{C: p}, nodeID)
+		if err != nil {
+			log.Fatalf("[ E ] findLeaf: %v", err)
+		}
+		nodeID, err = s.getQuadrantNodeID(ctx, nodeID, quadrant)
+		if err != nil {
+			log.Fatalf("[ E ] findLeaf: %v", err)
+		}
+	}
+}
+
+// removeStarFromNode removes the star from the node with the given ID
+func (s *Store) removeStarFromNode(ctx context.Context, nodeID int64) error {
+	// build the query
+	query := "UPDATE nodes SET star_id=0 WHERE node_id=$1"
+
+	// Execute the query
+	_, err := s.q.ExecContext(ctx, query, nodeID)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("removeStarFromNode query: %v", err))
+	}
+
+	return nil
+}
+
+// getListOfStarsGo returns the list of stars in go struct format
+func (s *Store) GetListOfStarsGo() []structs.Star2D {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE %s", s.deletedFilter())
+
+	// Execute the query
+	rows, err := s.q.QueryContext(ctx, query)
+	defer rows.Close()
+	if err != nil {
+		log.Fatalf("[ E ] removeStarFromNode query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	var starList []structs.Star2D
+
+	// iterate over the returned rows
+	for rows.Next() {
+
+		var starID int64
+		var x, y, vx, vy, m float64
+		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
+		if scanErr != nil {
+			log.Fatalf("[ E ] scan error: %v", scanErr)
+		}
+
+		star := structs.Star2D{
+			C: structs.Vec2{
+				X: x,
+				Y: y,
+			},
+			V: structs.Vec2{
+				X: vx,
+				Y: vy,
+			},
+			M: m,
+		}
+
+		starList = append(starList, star)
+	}
+
+	return starList
+}
+
+// GetListOfStarsGo is Store.GetListOfStarsGo using the given database
+func GetListOfStarsGo(database *sql.DB) []structs.Star2D {
+	return NewStore(database).GetListOfStarsGo()
+}
+
+// GetListOfStarIDs returns a list of all star ids in the stars table
+func (s *Store) GetListOfStarIDs() []int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := fmt.Sprintf("SELECT star_id FROM stars WHERE %s", s.deletedFilter())
+
+	// Execute the query
+	rows, err := s.q.QueryContext(ctx, query)
+	defer rows.Close()
+	if err != nil {
+		log.Fatalf("[ E ] GetListOfStarIDs query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	var starIDList []int64
+
+	// iterate over the returned rows
+	for rows.Next() {
+
+		var starID int64
+		scanErr := rows.Scan(&starID)
+		if scanErr != nil {
+			log.Fatalf("[ E ] scan error: %v", scanErr)
+		}
+
+		starIDList = append(starIDList, starID)
+	}
+
+	return starIDList
+}
+
+// GetListOfStarIDs is Store.GetListOfStarIDs using the given database
+func GetListOfStarIDs(database *sql.DB) []int64 {
+	return NewStore(database).GetListOfStarIDs()
+}
+
+// GetListOfStarIDs returns a list of all star ids in the stars table with the given timestep
+func (s *Store) GetListOfStarIDsTimestep(timestep int64) []int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := "SELECT star_id FROM nodes WHERE star_id<>0 AND timestep=$1"
+
+	// Execute the query
+	rows, err := s.q.QueryContext(ctx, query, timestep)
+	defer rows.Close()
+	if err != nil {
+		log.Fatalf("[ E ] GetListOfStarIDsTimestep query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	var starIDList []int64
+
+	// iterate over the returned rows
+	for rows.Next() {
+
+		var starID int64
+		scanErr := rows.Scan(&starID)
+		if scanErr != nil {
+			log.Fatalf("[ E ] scan error: %v", scanErr)
+		}
+
+		starIDList = append(starIDList, starID)
+	}
+
+	return starIDList
+}
+
+// GetListOfStarIDsTimestep is Store.GetListOfStarIDsTimestep using the given database
+func GetListOfStarIDsTimestep(database *sql.DB, timestep int64) []int64 {
+	return NewStore(database).GetListOfStarIDsTimestep(timestep)
+}
+
+// TimestepNodeIDs returns the ids of all the nodes with the given timestep. If the nodes table is partitioned by
+// the timestep (see InitPartitionedNodesTable), only the partition of the given timestep is scanned
+func (s *Store) TimestepNodeIDs(timestep int64) ([]int64, error) {
+	ids, err := s.queryIDs(timestepNodeIDsQuery, timestep)
+	if err != nil {
+		return nil, fmt.Errorf("TimestepNodeIDs query: %v", err)
+	}
+
+	return ids, nil
+}
+
+// TimestepNodeIDs is Store.TimestepNodeIDs using the given database
+func TimestepNodeIDs(database *sql.DB, timestep int64) ([]int64, error) {
+	return NewStore(database).TimestepNodeIDs(timestep)
+}
+
+// timestepNodeIDsQuery is the query used by TimestepNodeIDs
+const timestepNodeIDsQuery = "SELECT node_id FROM nodes WHERE timestep=$1 ORDER BY node_id"
+
+// getListOfStarsCsv returns an array of strings containing the coordinates of all the stars in the stars table
+func (s *Store) GetListOfStarsCsv() []string {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE %s", s.deletedFilter())
+
+	// Execute the query
+	rows, err := s.q.QueryContext(ctx, query)
+	defer rows.Close()
+	if err != nil {
+		log.Fatalf("[ E ] getListOfStarsCsv query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	var starList []string
+
+	// iterate over the returned rows
+	for rows.Next() {
+
+		var starID int64
+		var x, y, vx, vy, m float64
+		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
+		if scanErr != nil {
+			log.Fatalf("[ E ] scan error: %v", scanErr)
+		}
+
+		row := fmt.Sprintf("%d, %s, %s, %s, %s, %s", starID, formatFloat(x), formatFloat(y), formatFloat(vx), formatFloat(vy), formatFloat(m))
+		starList = append(starList, row)
+	}
+
+	return starList
+}
+
+// GetListOfStarsCsv is Store.GetListOfStarsCsv using the given database
+func GetListOfStarsCsv(database *sql.DB) []string {
+	return NewStore(database).GetListOfStarsCsv()
+}
+
+// getListOfStarsTreeCsv returns an array of strings containing the coordinates of all the stars in the given tree.
+// ErrTreeNotFound is returned if there is no tree with the given index
+func (s *Store) GetListOfStarsTree(treeindex int64) ([]structs.Star2D, error) {
+	if _, err := s.getRootNodeID(treeindex); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// build the query
+	query := "SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN(SELECT star_id FROM nodes WHERE timestep=$1)"
+
+	// Execute the query
+	rows, err := s.q.QueryContext(ctx, query, treeindex)
+	if err != nil {
+		return nil, fmt.Errorf("GetListOfStarsTree query: %v", err)
+	}
+	defer rows.Close()
+
+	var starList []structs.Star2D
+
+	// iterate over the returned rows
+	for rows.Next() {
+
+		var starID int64
+		var x, y, vx, vy, m float64
+		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
+		if scanErr != nil {
+			return nil, fmt.Errorf("GetListOfStarsTree scan: %v", scanErr)
+		}
+
+		star := structs.Star2D{
+			C: structs.Vec2{
+				X: x,
+				Y: y,
+			},
+			V: structs.Vec2{
+				X: vx,
+				Y: vy,
+			},
+			M: m,
+		}
+
+		starList = append(starList, star)
+	}
+
+	return starList, rows.Err()
+}
+
+// GetListOfStarsTree is Store.GetListOfStarsTree using the given database
+func GetListOfStarsTree(database *sql.DB, treeindex int64) ([]structs.Star2D, error) {
+	return NewStore(database).GetListOfStarsTree(treeindex)
+}
+
+// NthStarInTree returns the nth star (counting from zero, ordered by the star id) of the tree with the given index
+// and its id. This makes it possible to reference a specific star without knowing its id
+func (s *Store) NthStarInTree(index int64, n int64) (structs.Star2D, int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var starID int64
+	var star structs.Star2D
+
+	query := "SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) ORDER BY star_id OFFSET $2 LIMIT 1"
+	err := s.q.QueryRowContext(ctx, query, index, n).Scan(&starID, &star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M)
+	if err == sql.ErrNoRows {
+		return star, 0, fmt.Errorf("NthStarInTree: the tree %d contains less than %d stars", index, n+1)
+	}
+	if err != nil {
+		return star, 0, fmt.Errorf("NthStarInTree query: %v", err)
+	}
+
+	return star, starID, nil
+}
+
+// NthStarInTree is Store.NthStarInTree using the given database
+func NthStarInTree(database *sql.DB, index int64, n int64) (structs.Star2D, int64, error) {
+	return NewStore(database).NthStarInTree(index, n)
+}
+
+// StarRecord is a star together with its id
+type StarRecord struct {
+	ID   int64
+	Star structs.Star2D
+}
+
+// StarsChangedSince returns the stars of the tree with the given index whose state (position, velocity or mass)
+// differs from the state stored in the snapshot of the simulation at the given timestep, so that a viewer only has
+// to transfer the deltas in between two frames. Like in OrbitTrace, the stars are matched using the origin recorded
+// by the steps, as every step inserts new stars. Stars without a counterpart in the snapshot are always returned,
+// stars dropped since the snapshot are ignored. ErrTreeNotFound is returned if one of the trees doesn't exist
+func (s *Store) StarsChangedSince(index int64, snapshotTimestep int64) ([]StarRecord, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	for _, timestep := range []int64{index, snapshotTimestep} {
+		if _, err := s.rootNodeIDContext(ctx, timestep); err != nil {
+			return nil, err
+		}
+	}
+
+	query := fmt.Sprintf(`WITH cur AS (
+			SELECT COALESCE(origin_id, star_id) AS origin, star_id, x, y, vx, vy, m FROM stars
+			WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %[1]s
+		), snap AS (
+			SELECT COALESCE(origin_id, star_id) AS origin, x, y, vx, vy, m FROM stars
+			WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$2) AND %[1]s
+		)
+		SELECT cur.star_id, cur.x, cur.y, cur.vx, cur.vy, cur.m
+		FROM cur LEFT JOIN snap USING (origin)
+		WHERE (cur.x, cur.y, cur.vx, cur.vy, cur.m) IS DISTINCT FROM
+			(snap.x, snap.y, snap.vx, snap.vy, snap.m)
+		ORDER BY cur.star_id`, s.deletedFilter())
+	records, err := s.queryStarRecordsContext(ctx, query, index, snapshotTimestep)
+	if err != nil {
+		return nil, fmt.Errorf("StarsChangedSince query: %v", err)
+	}
+
+	return records, nil
+}
+
+// StarsChangedSince is Store.StarsChangedSince using the given database
+func StarsChangedSince(database *sql.DB, index int64, snapshotTimestep int64) ([]StarRecord, error) {
+	return NewStore(database).StarsChangedSince(index, snapshotTimestep)
+}
+
+// HeaviestStars returns the topN most massive stars of the tree with the given index, the heaviest first
+func (s *Store) HeaviestStars(index int64, topN int) ([]StarRecord, error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY m DESC, star_id LIMIT $2", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index, topN)
+	if err != nil {
+		return nil, fmt.Errorf("HeaviestStars query: %v", err)
+	}
+
+	return records, nil
+}
+
+// HeaviestStars is Store.HeaviestStars using the given database
+func HeaviestStars(database *sql.DB, index int64, topN int) ([]StarRecord, error) {
+	return NewStore(database).HeaviestStars(index, topN)
+}
+
+// StarsDownsampled returns at most maxStars randomly chosen stars of the tree with the given index, a representative
+// subset of the galaxy for previews. Every call returns a different subset
+func (s *Store) StarsDownsampled(index int64, maxStars int64) ([]structs.Star2D, error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY random() LIMIT $2", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index, maxStars)
+	if err != nil {
+		return nil, fmt.Errorf("StarsDownsampled query: %v", err)
+	}
+
+	var starList []structs.Star2D
+	for _, r := range records {
+		starList = append(starList, r.Star)
+	}
+
+	return starList, nil
+}
+
+// StarsDownsampled is Store.StarsDownsampled using the given database
+func StarsDownsampled(database *sql.DB, index int64, maxStars int64) ([]structs.Star2D, error) {
+	return NewStore(database).StarsDownsampled(index, maxStars)
+}
+
+// ClosestPair returns the ids of the two stars of the tree with the given index that are closest to each other and
+// their distance, the candidates for an imminent merger. The stars are sorted by their x coordinate, so only the
+// pairs whose distance along the x axis is below the closest distance found so far are compared. The id of the
+// first star is the smaller one. An error is returned if the tree contains less than two stars
+func (s *Store) ClosestPair(index int64) (idA, idB int64, dist float64, err error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY x, star_id", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("ClosestPair query: %v", err)
+	}
+	if len(records) < 2 {
+		return 0, 0, 0, fmt.Errorf("ClosestPair: the tree %d contains %d stars, at least 2 are needed", index, len(records))
+	}
+
+	dist = math.Inf(1)
+	for i, a := range records {
+		for _, b := range records[i+1:] {
+			if b.Star.C.X-a.Star.C.X >= dist {
+				break
+			}
+
+			d := math.Hypot(b.Star.C.X-a.Star.C.X, b.Star.C.Y-a.Star.C.Y)
+			if d < dist {
+				idA, idB, dist = a.ID, b.ID, d
+			}
+		}
+	}
+
+	if idA > idB {
+		idA, idB = idB, idA
+	}
+
+	return idA, idB, dist, nil
+}
+
+// ClosestPair is Store.ClosestPair using the given database
+func ClosestPair(database *sql.DB, index int64) (idA, idB int64, dist float64, err error) {
+	return NewStore(database).ClosestPair(index)
+}
+
+// DetectEncounters returns the ids of all the pairs of stars of the tree with the given index that are at most the
+// given radius apart, so that they can be merged or flagged. The stars are sorted into a grid of cells as wide as the
+// radius, so only stars in neighbouring cells have to be compared. The first id of each pair is the smaller one,
+// the pairs are ordered by their ids
+func (s *Store) DetectEncounters(index int64, radius float64) ([][2]int64, error) {
+	if radius <= 0 {
+		return nil, fmt.Errorf("DetectEncounters: the radius must be positive, got %v", radius)
+	}
+
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index)
+	if err != nil {
+		return nil, fmt.Errorf("DetectEncounters query: %v", err)
+	}
+
+	cellOf := func(p structs.Vec2) [2]int64 {
+		return [2]int64{int64(math.Floor(p.X / radius)), int64(math.Floor(p.Y / radius))}
+	}
+
+	// compare every star with the stars sorted into the grid before it, then add it to the grid
+	var pairs [][2]int64
+	grid := make(map[[2]int64][]int)
+	for i, a := range records {
+		cell := cellOf(a.Star.C)
+		for dx := int64(-1); dx <= 1; dx++ {
+			for dy := int64(-1); dy <= 1; dy++ {
+				for _, j := range grid[[2]int64{cell[0] + dx, cell[1] + dy}] {
+					b := records[j]
+					if math.Hypot(a.Star.C.X-b.Star.C.X, a.Star.C.Y-b.Star.C.Y) <= radius {
+						pairs = append(pairs, [2]int64{b.ID, a.ID})
+					}
+				}
+			}
+		}
+		grid[cell] = append(grid[cell], i)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	return pairs, nil
+}
+
+// DetectEncounters is Store.DetectEncounters using the given database
+func DetectEncounters(database *sql.DB, index int64, radius float64) ([][2]int64, error) {
+	return NewStore(database).DetectEncounters(index, radius)
+}
+
+// ColoredStar is a star together with its id and the color it is rendered with
+type ColoredStar struct {
+	ID    int64
+	Star  structs.Star2D
+	Color [3]uint8
+}
+
+// viridis contains the colors of the viridis colormap at 0, 0.25, 0.5, 0.75 and 1
+var viridis = [][3]float64{{68, 1, 84}, {59, 82, 139}, {33, 145, 140}, {94, 201, 98}, {253, 231, 37}}
+
+// ViridisColormap is a colormap for StarsWithColor approximating viridis: the lightest stars are dark purple, the
+// heaviest ones yellow. If all the stars have the same mass, they are colored like the lightest stars
+func ViridisColormap(m, minM, maxM float64) [3]uint8 {
+	var t float64
+	if maxM > minM {
+		t = math.Max(0, math.Min(1, (m-minM)/(maxM-minM)))
+	}
+
+	// interpolate linearly in between the two surrounding colors
+	pos := t * float64(len(viridis)-1)
+	i := int(math.Min(pos, float64(len(viridis)-2)))
+	frac := pos - float64(i)
+
+	var color [3]uint8
+	for c := range color {
+		color[c] = uint8(math.Round(viridis[i][c] + frac*(viridis[i+1][c]-viridis[i][c])))
+	}
+
+	return color
+}
+
+// StarsWithColor returns the stars of the tree with the given index ordered by their id, colored by their mass using
+// the given colormap. The colormap gets the mass of a star and the smallest and largest mass of the stars in the
+// tree, see ViridisColormap
+func (s *Store) StarsWithColor(index int64, cmap func(m, minM, maxM float64) [3]uint8) ([]ColoredStar, error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index)
+	if err != nil {
+		return nil, fmt.Errorf("StarsWithColor query: %v", err)
+	}
+
+	minM, maxM := math.Inf(1), math.Inf(-1)
+	for _, r := range records {
+		minM = math.Min(minM, r.Star.M)
+		maxM = math.Max(maxM, r.Star.M)
+	}
+
+	stars := make([]ColoredStar, len(records))
+	for i, r := range records {
+		stars[i] = ColoredStar{ID: r.ID, Star: r.Star, Color: cmap(r.Star.M, minM, maxM)}
+	}
+
+	return stars, nil
+}
+
+// StarsWithColor is Store.StarsWithColor using the given database
+func StarsWithColor(database *sql.DB, index int64, cmap func(m, minM, maxM float64) [3]uint8) ([]ColoredStar, error) {
+	return NewStore(database).StarsWithColor(index, cmap)
+}
+
+// StarsWithIDGreaterThan returns at most limit stars of the stars table whose id is greater than lastID, ordered by
+// their id. Passing the id of the last returned star as lastID returns the next page, so append-only consumers
+// only get the stars inserted since they last asked
+func (s *Store) StarsWithIDGreaterThan(lastID int64, limit int64) ([]StarRecord, error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE %s AND star_id>$1 ORDER BY star_id LIMIT $2", s.deletedFilter())
+	records, err := s.queryStarRecords(query, lastID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("StarsWithIDGreaterThan query: %v", err)
+	}
+
+	return records, nil
+}
+
+// StarsWithIDGreaterThan is Store.StarsWithIDGreaterThan using the given database
+func StarsWithIDGreaterThan(database *sql.DB, lastID int64, limit int64) ([]StarRecord, error) {
+	return NewStore(database).StarsWithIDGreaterThan(lastID, limit)
+}
+
+// queryStarRecords executes the given query selecting the star_id, x, y, vx, vy and m of stars and returns the
+// stars from the returned rows
+func (s *Store) queryStarRecords(query string, args ...interface{}) ([]StarRecord, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.queryStarRecordsContext(ctx, query, args...)
+}
+
+// queryStarRecordsContext is queryStarRecords using the given context for the query
+func (s *Store) queryStarRecordsContext(ctx context.Context, query string, args ...interface{}) ([]StarRecord, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []StarRecord
+	for rows.Next() {
+		var r StarRecord
+		if err := rows.Scan(&r.ID, &r.Star.C.X, &r.Star.C.Y, &r.Star.V.X, &r.Star.V.Y, &r.Star.M); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// StarsInTreeAtTimestep returns the stars of the galaxy stored in the tree with the given index as they are at the
+// given timestep. Like in OrbitTrace, the stars are followed through the timesteps by their origin, so only the
+// stars of the tree of the given timestep sharing their origin with a star of the tree with the given index are
+// returned. Both trees are found by walking down from their roots
+func (s *Store) StarsInTreeAtTimestep(index, timestep int64) ([]structs.Star2D, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := treeNodesCTE + `, step(node_id) AS (
+		SELECT node_id FROM nodes WHERE root_id=$2
+		UNION
+		SELECT child FROM step JOIN nodes USING (node_id), unnest(nodes.subnode) AS child WHERE child<>0
+	) SELECT x, y, vx, vy, m FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE node_id IN (SELECT node_id FROM step))
+		AND COALESCE(origin_id, star_id) IN (
+			SELECT COALESCE(origin_id, star_id) FROM stars
+			WHERE star_id IN (SELECT star_id FROM nodes WHERE node_id IN (SELECT node_id FROM tree))
+		) ORDER BY star_id`
+	rows, err := s.q.QueryContext(ctx, query, index, timestep)
+	if err != nil {
+		return nil, fmt.Errorf("StarsInTreeAtTimestep query: %v", err)
+	}
+	defer rows.Close()
+
+	var starList []structs.Star2D
+	for rows.Next() {
+		var star structs.Star2D
+		if err := rows.Scan(&star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M); err != nil {
+			return nil, fmt.Errorf("StarsInTreeAtTimestep scan: %v", err)
+		}
+		starList = append(starList, star)
+	}
+
+	return starList, rows.Err()
+}
+
+// StarsInTreeAtTimestep is Store.StarsInTreeAtTimestep using the given database
+func StarsInTreeAtTimestep(database *sql.DB, index, timestep int64) ([]structs.Star2D, error) {
+	return NewStore(database).StarsInTreeAtTimestep(index, timestep)
+}
+
+// StarsForTimestepRange returns the stars of the timesteps from to to (both inclusive) of the simulation starting
+// with the tree with the given index, keyed by timestep. Timesteps before the given index are never included.
+// All the frames are fetched using a single query, timesteps without any stars are missing from the map
+func (s *Store) StarsForTimestepRange(index int64, from, to int64) (map[int64][]structs.Star2D, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.starsForTimestepRangeContext(ctx, index, from, to)
+}
+
+// starsForTimestepRangeContext is StarsForTimestepRange using the given context for the query
+func (s *Store) starsForTimestepRangeContext(ctx context.Context, index int64, from, to int64) (map[int64][]structs.Star2D, error) {
+	query := fmt.Sprintf(`SELECT nodes.timestep, stars.x, stars.y, stars.vx, stars.vy, stars.m
+		FROM nodes JOIN stars ON stars.star_id=nodes.star_id
+		WHERE nodes.timestep BETWEEN GREATEST($1::bigint, $2::bigint) AND $3 AND %s
+		ORDER BY nodes.timestep, stars.star_id`, s.deletedFilter())
+	rows, err := s.q.QueryContext(ctx, query, index, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("StarsForTimestepRange query: %v", err)
+	}
+	defer rows.Close()
+
+	frames := make(map[int64][]structs.Star2D)
+	for rows.Next() {
+		var timestep int64
+		var star structs.Star2D
+		if err := rows.Scan(&timestep, &star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M); err != nil {
+			return nil, fmt.Errorf("StarsForTimestepRange scan: %v", err)
+		}
+		frames[timestep] = append(frames[timestep], star)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("StarsForTimestepRange rows: %v", err)
+	}
+
+	return frames, nil
+}
+
+// StarsForTimestepRange is Store.StarsForTimestepRange using the given database
+func StarsForTimestepRange(database *sql.DB, index int64, from, to int64) (map[int64][]structs.Star2D, error) {
+	return NewStore(database).StarsForTimestepRange(index, from, to)
+}
+
+// FramePair returns the frames of the two timesteps bracketing the fractional timestep t of the simulation starting
+// with the tree with the given index, and the weight alpha of the later frame for interpolating in between them:
+// t = 1.25 returns the frames of the timesteps 1 and 2 and an alpha of 0.25. For an integer t, both frames are the
+// frame of the timestep t and alpha is 0. The stars of both frames are ordered by their id, like the frames
+// returned by StarsForTimestepRange. ErrTreeNotFound is returned if a bracketing timestep doesn't exist
+func (s *Store) FramePair(index int64, t float64) (before, after []structs.Star2D, alpha float64, err error) {
+	first := int64(math.Floor(t))
+	alpha = t - math.Floor(t)
+
+	last := first
+	if alpha > 0 {
+		last = first + 1
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	for _, timestep := range []int64{first, last} {
+		if timestep < index {
+			return nil, nil, 0, ErrTreeNotFound
+		}
+		if _, err := s.rootNodeIDContext(ctx, timestep); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	frames, err := s.starsForTimestepRangeContext(ctx, index, first, last)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return frames[first], frames[last], alpha, nil
+}
+
+// FramePair is Store.FramePair using the given database
+func FramePair(database *sql.DB, index int64, t float64) (before, after []structs.Star2D, alpha float64, err error) {
+	return NewStore(database).FramePair(index, t)
+}
+
+// ErrStarDropped is returned by OrbitTrace if the followed star isn't part of one of the timesteps, e.g. because it
+// was deleted
+var ErrStarDropped = errors.New("star dropped from the timestep")
+
+// OrbitTrace returns the positions of the star nearest to startPos in the tree with the given index over the
+// given number of following timesteps, starting with its position in the tree itself. Every step inserts new stars,
+// so the star is followed using the origin the steps record for the stars they insert (see StepSimulation) and not
+// by its position in the frames. ErrTreeNotFound is returned if one of the timesteps is missing, ErrStarDropped
+// (together with the positions traced until then) if the star isn't part of one of the timesteps
+func (s *Store) OrbitTrace(index int64, startPos structs.Vec2, steps int) ([]structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	for timestep := index; timestep <= index+int64(steps); timestep++ {
+		if _, err := s.rootNodeIDContext(ctx, timestep); err != nil {
+			return nil, err
+		}
+	}
+
+	// find the star nearest to the start position
+	var origin int64
+	var position structs.Vec2
+	query := fmt.Sprintf(`SELECT COALESCE(origin_id, star_id), x, y FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s
+		ORDER BY (x-$2)*(x-$2) + (y-$3)*(y-$3), star_id LIMIT 1`, s.deletedFilter())
+	err := s.q.QueryRowContext(ctx, query, index, startPos.X, startPos.Y).Scan(&origin, &position.X, &position.Y)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("OrbitTrace: the tree %d doesn't contain any stars", index)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("OrbitTrace nearest star query: %v", err)
+	}
+
+	trace := make([]structs.Vec2, 0, steps+1)
+	trace = append(trace, position)
+
+	query = fmt.Sprintf(`SELECT x, y FROM stars
+		WHERE COALESCE(origin_id, star_id)=$1 AND star_id IN (SELECT star_id FROM nodes WHERE timestep=$2) AND %s`,
+		s.deletedFilter())
+	for timestep := index + 1; timestep <= index+int64(steps); timestep++ {
+		err := s.q.QueryRowContext(ctx, query, origin, timestep).Scan(&position.X, &position.Y)
+		if err == sql.ErrNoRows {
+			return trace, ErrStarDropped
+		}
+		if err != nil {
+			return trace, fmt.Errorf("OrbitTrace query: %v", err)
+		}
+		trace = append(trace, position)
+	}
+
+	return trace, nil
+}
+
+// OrbitTrace is Store.OrbitTrace using the given database
+func OrbitTrace(database *sql.DB, index int64, startPos structs.Vec2, steps int) ([]structs.Vec2, error) {
+	return NewStore(database).OrbitTrace(index, startPos, steps)
+}
+
+// StarFilter selects stars by their mass, speed and position. A zero maximum (MaxMass, MaxSpeed) doesn't limit the
+// stars, neither does a region whose corners Min and Max are equal
+type StarFilter struct {
+	MinMass, MaxMass   float64
+	MinSpeed, MaxSpeed float64
+	Min, Max           structs.Vec2
+}
+
+// conditions returns the SQL conditions on the stars table selecting the stars matching the filter and the query
+// arguments they reference. The arguments are numbered starting at $firstArg
+func (f StarFilter) conditions(firstArg int) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+
+	add := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i := range values {
+			placeholders[i] = fmt.Sprintf("$%d", firstArg+len(args)+i)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+		args = append(args, values...)
+	}
+
+	if f.MinMass != 0 {
+		add("m>=%s", f.MinMass)
+	}
+	if f.MaxMass != 0 {
+		add("m<=%s", f.MaxMass)
+	}
+	if f.MinSpeed != 0 {
+		add("vx*vx + vy*vy>=%s", f.MinSpeed*f.MinSpeed)
+	}
+	if f.MaxSpeed != 0 {
+		add("vx*vx + vy*vy<=%s", f.MaxSpeed*f.MaxSpeed)
+	}
+	if f.Min != f.Max {
+		add("x BETWEEN %s AND %s AND y BETWEEN %s AND %s", f.Min.X, f.Max.X, f.Min.Y, f.Max.Y)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// StarsInTreeWhere returns the stars of the tree with the given index matching the given filter. The filter is
+// applied by the database, so only the matching stars are fetched
+func (s *Store) StarsInTreeWhere(index int64, f StarFilter) ([]structs.Star2D, error) {
+	conditions, args := f.conditions(2)
+	query := fmt.Sprintf(`SELECT star_id, x, y, vx, vy, m FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id`, conditions)
+
+	records, err := s.queryStarRecords(query, append([]interface{}{index}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("StarsInTreeWhere query: %v", err)
+	}
+
+	var starList []structs.Star2D
+	for _, r := range records {
+		starList = append(starList, r.Star)
+	}
+
+	return starList, nil
+}
+
+// StarsInTreeWhere is Store.StarsInTreeWhere using the given database
+func StarsInTreeWhere(database *sql.DB, index int64, f StarFilter) ([]structs.Star2D, error) {
+	return NewStore(database).StarsInTreeWhere(index, f)
+}
+
+// QueryStarsRaw returns the stars of the stars table matching the given WHERE clause, ordered by their id, for
+// filters StarFilter doesn't cover. The clause is inserted into the query as is, so it has to be a constant written
+// by the programmer: all the values (especially untrusted ones) must be passed as args and referenced using the
+// placeholders $1, $2, ... in the clause, they are never interpolated into the query. Soft deleted stars aren't
+// returned unless Options.IncludeDeleted is set
+func (s *Store) QueryStarsRaw(whereClause string, args ...interface{}) ([]structs.Star2D, error) {
+	if strings.TrimSpace(whereClause) == "" {
+		whereClause = "TRUE"
+	}
+
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE (%s) AND %s ORDER BY star_id", whereClause, s.deletedFilter())
+	records, err := s.queryStarRecords(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("QueryStarsRaw query: %v", err)
+	}
+
+	var starList []structs.Star2D
+	for _, r := range records {
+		starList = append(starList, r.Star)
+	}
+
+	return starList, nil
+}
+
+// QueryStarsRaw is Store.QueryStarsRaw using the given database
+func QueryStarsRaw(database *sql.DB, whereClause string, args ...interface{}) ([]structs.Star2D, error) {
+	return NewStore(database).QueryStarsRaw(whereClause, args...)
+}
+
+// StarsInPolygon returns the stars of the tree with the given index inside of the given polygon (e.g. selected using
+// a lasso tool). The polygon may be non-convex and may repeat its first vertex at its end. The candidates are
+// selected using the bounding box of the polygon, the stars inside of the polygon are filtered using the even-odd
+// rule afterwards
+func (s *Store) StarsInPolygon(index int64, polygon []structs.Vec2) ([]structs.Star2D, error) {
+	// a closing vertex equal to the first one doesn't add an edge
+	if len(polygon) > 1 && polygon[0] == polygon[len(polygon)-1] {
+		polygon = polygon[:len(polygon)-1]
+	}
+	if len(polygon) < 3 {
+		return nil, fmt.Errorf("StarsInPolygon: a polygon needs at least 3 vertices, got %d", len(polygon))
+	}
+
+	min, max := polygon[0], polygon[0]
+	for _, p := range polygon[1:] {
+		min.X, min.Y = math.Min(min.X, p.X), math.Min(min.Y, p.Y)
+		max.X, max.Y = math.Max(max.X, p.X), math.Max(max.Y, p.Y)
+	}
+
+	query := `SELECT star_id, x, y, vx, vy, m FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND x BETWEEN $2 AND $3 AND y BETWEEN $4 AND $5
+		ORDER BY star_id`
+	candidates, err := s.queryStarRecords(query, index, min.X, max.X, min.Y, max.Y)
+	if err != nil {
+		return nil, fmt.Errorf("StarsInPolygon query: %v", err)
+	}
+
+	var starList []structs.Star2D
+	for _, candidate := range candidates {
+		if inPolygon(candidate.Star.C, polygon) {
+			starList = append(starList, candidate.Star)
+		}
+	}
+
+	return starList, nil
+}
+
+// StarsInPolygon is Store.StarsInPolygon using the given database
+func StarsInPolygon(database *sql.DB, index int64, polygon []structs.Vec2) ([]structs.Star2D, error) {
+	return NewStore(database).StarsInPolygon(index, polygon)
+}
+
+// inPolygon returns true if the given point is inside of the given polygon. A ray is cast from the point, the
+// point is inside if the ray crosses the edges of the polygon an odd number of times (even-odd rule)
+func inPolygon(p structs.Vec2, polygon []structs.Vec2) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// streamFlushInterval is the amount of stars after which StreamStarsJSON flushes its writer
+const streamFlushInterval = 1000
+
+// StreamStarsJSON writes the stars of the tree with the given index as a JSON array to the given writer while
+// iterating over the rows, so the stars are never buffered as a whole. If the writer is a http.Flusher (e.g. a
+// http.ResponseWriter), it is flushed periodically
+func (s *Store) StreamStarsJSON(index int64, w io.Writer) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := "SELECT x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) ORDER BY star_id"
+	rows, err := s.q.QueryContext(ctx, query, index)
+	if err != nil {
+		return fmt.Errorf("StreamStarsJSON query: %v", err)
+	}
+	defer rows.Close()
+
+	flusher, canFlush := w.(http.Flusher)
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	count := 0
+	for rows.Next() {
+		var star structs.Star2D
+		if err := rows.Scan(&star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M); err != nil {
+			return fmt.Errorf("StreamStarsJSON scan: %v", err)
+		}
+
+		encoded, err := json.Marshal(star)
+		if err != nil {
+			return err
+		}
+
+		// separate the stars using commas
+		if count > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+
+		count++
+		if canFlush && count%streamFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("StreamStarsJSON rows: %v", err)
+	}
+
+	if _, err := io.WriteString(w, "]"); err != nil {
+		return err
+	}
+	if canFlush {
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// StreamStarsJSON is Store.StreamStarsJSON using the given database
+func StreamStarsJSON(database *sql.DB, index int64, w io.Writer) error {
+	return NewStore(database).StreamStarsJSON(index, w)
+}
+
+// WriteStarsCSVGzip writes the stars of the tree with the given index as gzip compressed CSV to the given writer.
+// Every row contains the star_id, x, y, vx, vy and m of a star. The rows are compressed while iterating over them,
+// so neither the CSV nor the compressed data is buffered as a whole
+func (s *Store) WriteStarsCSVGzip(index int64, w io.Writer) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := "SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) ORDER BY star_id"
+	rows, err := s.q.QueryContext(ctx, query, index)
+	if err != nil {
+		return fmt.Errorf("WriteStarsCSVGzip query: %v", err)
+	}
+	defer rows.Close()
+
+	gz := gzip.NewWriter(w)
+	writer := csv.NewWriter(gz)
+
+	for rows.Next() {
+		var starID int64
+		var x, y, vx, vy, m float64
+		if err := rows.Scan(&starID, &x, &y, &vx, &vy, &m); err != nil {
+			return fmt.Errorf("WriteStarsCSVGzip scan: %v", err)
+		}
+
+		record := []string{strconv.FormatInt(starID, 10)}
+		for _, value := range []float64{x, y, vx, vy, m} {
+			record = append(record, strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("WriteStarsCSVGzip rows: %v", err)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// WriteStarsCSVGzip is Store.WriteStarsCSVGzip using the given database
+func WriteStarsCSVGzip(database *sql.DB, index int64, w io.Writer) error {
+	return NewStore(database).WriteStarsCSVGzip(index, w)
+}
+
+// StarProto is a star in the protobuf wire format used by StarsProto. It corresponds to the message
+//
+//	message Star {
+//		int64 id = 1;
+//		double x = 2;
+//		double y = 3;
+//		double vx = 4;
+//		double vy = 5;
+//		double m = 6;
+//	}
+type StarProto struct {
+	ID     int64
+	X, Y   float64
+	VX, VY float64
+	M      float64
+}
+
+// StarListProto is the list of stars of a tree in the protobuf wire format used by StarsProto. It corresponds to
+// the message
+//
+//	message StarList {
+//		int64 index = 1;
+//		repeated Star stars = 2;
+//	}
+type StarListProto struct {
+	Index int64
+	Stars []StarProto
+}
+
+// Marshal encodes the star in the protobuf wire format. Like in proto3, fields with a zero value are omitted
+func (s StarProto) Marshal() []byte {
+	var buf []byte
+	buf = appendProtoVarint(buf, 1, uint64(s.ID))
+	buf = appendProtoDouble(buf, 2, s.X)
+	buf = appendProtoDouble(buf, 3, s.Y)
+	buf = appendProtoDouble(buf, 4, s.VX)
+	buf = appendProtoDouble(buf, 5, s.VY)
+	buf = appendProtoDouble(buf, 6, s.M)
+	return buf
+}
+
+// Unmarshal decodes the star from the protobuf wire format. Unknown fields are skipped
+func (s *StarProto) Unmarshal(data []byte) error {
+	*s = StarProto{}
+	return readProtoFields(data, func(num protowire.Number, typ protowire.Type, value uint64, _ []byte) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			s.ID = int64(value)
+		case num == 2 && typ == protowire.Fixed64Type:
+			s.X = math.Float64frombits(value)
+		case num == 3 && typ == protowire.Fixed64Type:
+			s.Y = math.Float64frombits(value)
+		case num == 4 && typ == protowire.Fixed64Type:
+			s.VX = math.Float64frombits(value)
+		case num == 5 && typ == protowire.Fixed64Type:
+			s.VY = math.Float64frombits(value)
+		case num == 6 && typ == protowire.Fixed64Type:
+			s.M = math.Float64frombits(value)
+		}
+	})
+}
+
+// Marshal encodes the list of stars in the protobuf wire format
+func (l StarListProto) Marshal() []byte {
+	var buf []byte
+	buf = appendProtoVarint(buf, 1, uint64(l.Index))
+	for _, s := range l.Stars {
+		buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, s.Marshal())
+	}
+	return buf
+}
+
+// Unmarshal decodes the list of stars from the protobuf wire format. Unknown fields are skipped
+func (l *StarListProto) Unmarshal(data []byte) error {
+	*l = StarListProto{}
+
+	var starErr error
+	err := readProtoFields(data, func(num protowire.Number, typ protowire.Type, value uint64, b []byte) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			l.Index = int64(value)
+		case num == 2 && typ == protowire.BytesType:
+			var s StarProto
+			if err := s.Unmarshal(b); err != nil && starErr == nil {
+				starErr = err
+			}
+			l.Stars = append(l.Stars, s)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return starErr
+}
+
+// appendProtoVarint appends the given field as a varint to buf, omitting zero values
+func appendProtoVarint(buf []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, v)
+}
+
+// appendProtoDouble appends the given field as a double to buf, omitting zero values
+func appendProtoDouble(buf []byte, num protowire.Number, f float64) []byte {
+	if f == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(buf, math.Float64bits(f))
+}
+
+// readProtoFields reads the fields of a protobuf message and calls fn for each of them. Varint and fixed fields are
+// passed as value, length delimited fields as b. Groups are skipped
+func readProtoFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value uint64, b []byte)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("protobuf: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			value = uint64(v)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("protobuf: field %d: %v", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if typ != protowire.StartGroupType {
+			fn(num, typ, value, b)
+		}
+	}
+
+	return nil
+}
+
+// StarsProto returns the stars of the tree with the given index as a StarListProto message encoded in the protobuf
+// wire format. This is a lot more compact than JSON for large galaxies and can be sent to gRPC clients as is
+func (s *Store) StarsProto(index int64) ([]byte, error) {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index)
+	if err != nil {
+		return nil, fmt.Errorf("StarsProto query: %v", err)
+	}
+
+	list := StarListProto{Index: index}
+	for _, r := range records {
+		list.Stars = append(list.Stars, StarProto{
+			ID: r.ID,
+			X:  r.Star.C.X,
+			Y:  r.Star.C.Y,
+			VX: r.Star.V.X,
+			VY: r.Star.V.Y,
+			M:  r.Star.M,
+		})
+	}
+
+	return list.Marshal(), nil
+}
+
+// StarsProto is Store.StarsProto using the given database
+func StarsProto(database *sql.DB, index int64) ([]byte, error) {
+	return NewStore(database).StarsProto(index)
+}
+
+// arrowSchema is the schema of the Arrow streams written by StarsArrow, all of its columns are float64 columns
+// without nulls
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "x", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "y", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "vx", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "vy", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "m", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// StarsArrow writes the stars of the tree with the given index to w as an Arrow IPC stream
+// (https://arrow.apache.org/docs/format/Columnar.html#ipc-streaming-format) containing a single record batch with
+// the columns x, y, vx, vy and m, ordered by the id of the stars. The stream can be read by any Arrow
+// implementation (e.g. pyarrow.ipc.open_stream) and is a lot more efficient than CSV for data science workflows
+func (s *Store) StarsArrow(index int64, w io.Writer) error {
+	query := fmt.Sprintf("SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id", s.deletedFilter())
+	records, err := s.queryStarRecords(query, index)
+	if err != nil {
+		return fmt.Errorf("StarsArrow query: %v", err)
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	defer b.Release()
+	for _, r := range records {
+		for i, v := range []float64{r.Star.C.X, r.Star.C.Y, r.Star.V.X, r.Star.V.Y, r.Star.M} {
+			b.Field(i).(*array.Float64Builder).Append(v)
+		}
+	}
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	writer := ipc.NewWriter(w, ipc.WithSchema(arrowSchema))
+	if err := writer.Write(rec); err != nil {
+		return fmt.Errorf("StarsArrow write: %v", err)
+	}
+
+	// closing the writer marks the end of the stream
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("StarsArrow write: %v", err)
+	}
+
+	return nil
+}
+
+// StarsArrow is Store.StarsArrow using the given database
+func StarsArrow(database *sql.DB, index int64, w io.Writer) error {
+	return NewStore(database).StarsArrow(index, w)
+}
+
+// insertList inserts all the stars in the given .csv into the stars and nodes table
+func (s *Store) InsertList(filename string) {
+	// open the file
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		panic(readErr)
+	}
+
+	in := string(content)
+	reader := csv.NewReader(strings.NewReader(in))
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// insert all the stars into the db
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			log.Println("EOF")
+			break
+		}
+		if err != nil {
+			log.Println("insertListErr")
+			panic(err)
+		}
+
+		x, _ := strconv.ParseFloat(record[0], 64)
+		y, _ := strconv.ParseFloat(record[1], 64)
+
+		star := structs.Star2D{
+			C: structs.Vec2{
+				X: x / 100000,
+				Y: y / 100000,
+			},
+			V: structs.Vec2{
+				X: 0,
+				Y: 0,
+			},
+			M: 1000,
+		}
+
+		fmt.Printf("Inserting (%f, %f)\n", star.C.X, star.C.Y)
+		if _, err := s.insertStar(ctx, star, 1, nil); err != nil {
+			log.Fatalf("[ E ] %v\n", err)
+		}
+	}
+
+	s.notifyChange("insert %d", 1)
+}
+
+// InsertList is Store.InsertList using the given database
+func InsertList(database *sql.DB, filename string) {
+	NewStore(database).InsertList(filename)
+}
+
+// InsertListTx inserts all the stars in the given .csv into the stars and nodes table like InsertList, but the whole
+// import is done in a single transaction: if a record can't be parsed or inserted, none of the stars are inserted.
+// The stars are inserted into the stars table in batches. If there is no tree with the index 1 yet, a new tree is
+// created in the same transaction, so a failed import doesn't leave an empty tree behind. The number of inserted
+// stars is returned
+func (s *Store) InsertListTx(filename string) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("InsertListTx open: %v", err)
+	}
+	defer f.Close()
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("InsertListTx begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	index := int64(1)
+	rootID, err := txStore.rootNodeIDContext(ctx, index)
+	if err == ErrTreeNotFound {
+		index, rootID, err = txStore.newTreeAt(ctx, structs.Vec2{}, 1000)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, err := txStore.insertStarsFromReader(ctx, f, rootID, InsertListOptions{Scale: 100000, Mass: 1000}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("InsertListTx: %v", err)
+	}
+
+	if err := commitTx(tx); err != nil {
+		return 0, fmt.Errorf("InsertListTx commit: %v", err)
+	}
+	s.notifyChange("insert %d", index)
+
+	return inserted, nil
+}
+
+// InsertListTx is Store.InsertListTx using the given database
+func InsertListTx(database *sql.DB, filename string) (int64, error) {
+	return NewStore(database).InsertListTx(filename)
+}
+
+// InsertListOptions configures how the stars of a .csv list are inserted by InsertStarsFromReader
+type InsertListOptions struct {
+	// Scale is the factor the coordinates in the list are divided by, 0 keeps them as they are
+	Scale float64
+
+	// Mass is the mass of the stars whose mass isn't contained in the list
+	Mass float64
+
+	// MapLines makes InsertListWithOptions return the id of the star inserted for every record
+	MapLines bool
+
+	// BatchSize is the number of stars inserted into the stars table using a single query, 0 uses batches of 1000.
+	// Every batch is inserted into the stars table and placed into the tree in its own transaction, which is
+	// committed before the next batch is read, so the insertion of a large list doesn't hold a huge transaction.
+	// This isn't atomic: if the insertion fails, the failing batch is rolled back, but the stars of the batches
+	// committed before stay inserted
+	BatchSize int
+}
+
+// InsertStarsFromReader reads a .csv list of stars from the given reader and inserts the stars into the tree with the
+// given index. Every record contains the position of a star (x, y) optionally followed by its velocity (vx, vy) and
+// its mass (m). The list is streamed instead of read into memory at once, so r can be a large upload (e.g. the body
+// of an HTTP request). The number of inserted stars is returned, also if an error interrupts the insertion (see
+// InsertListOptions.BatchSize). ErrTreeNotFound is returned if there is no tree with the given index
+func (s *Store) InsertStarsFromReader(r io.Reader, index int64, opts InsertListOptions) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, err := s.insertStarsFromReader(ctx, r, rootID, opts, nil)
+	if inserted > 0 {
+		s.notifyChange("insert %d", index)
+	}
+
+	return inserted, err
+}
+
+// InsertStarsFromReader is Store.InsertStarsFromReader using the given database
+func InsertStarsFromReader(database *sql.DB, r io.Reader, index int64, opts InsertListOptions) (int64, error) {
+	return NewStore(database).InsertStarsFromReader(r, index, opts)
+}
+
+// CSVStarMapping maps a record of a .csv list of stars to the id of the star inserted for it
+type CSVStarMapping struct {
+	// Line is the number of the record in the list starting at 1. Empty lines aren't counted
+	Line int
+
+	StarID int64
+}
+
+// InsertListWithOptions inserts the stars of the .csv list in the given file into the tree with the given index like
+// InsertStarsFromReader. If opts.MapLines is set, the returned mapping contains the id of the star inserted for every
+// record, so a bad star can be traced back to its source. The mapping also contains the stars inserted before an
+// error interrupted the insertion
+func (s *Store) InsertListWithOptions(filename string, index int64, opts InsertListOptions) ([]CSVStarMapping, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("InsertListWithOptions open: %v", err)
+	}
+	defer f.Close()
+
+	var mapping []CSVStarMapping
+	var mappingPtr *[]CSVStarMapping
+	if opts.MapLines {
+		mappingPtr = &mapping
+	}
+
+	inserted, err := s.insertStarsFromReader(ctx, f, rootID, opts, mappingPtr)
+	if inserted > 0 {
+		s.notifyChange("insert %d", index)
+	}
+
+	return mapping, err
+}
+
+// InsertListWithOptions is Store.InsertListWithOptions using the given database
+func InsertListWithOptions(database *sql.DB, filename string, index int64, opts InsertListOptions) ([]CSVStarMapping, error) {
+	return NewStore(database).InsertListWithOptions(filename, index, opts)
+}
+
+// insertStarsFromReader inserts the stars of the .csv list read from r into the tree with the given root node, see
+// InsertStarsFromReader. If mapping isn't nil, the ids of the inserted stars are appended to it
+func (s *Store) insertStarsFromReader(ctx context.Context, r io.Reader, rootID int64, opts InsertListOptions, mapping *[]CSVStarMapping) (int64, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var inserted, records int64
+	var batch []structs.Star2D
+	for {
+		record, err := reader.Read()
+		if err != nil && err != io.EOF {
+			return inserted, fmt.Errorf("InsertStarsFromReader read: %v", err)
+		}
+
+		if err == nil {
+			records++
+			star, parseErr := parseStarRecord(record, opts)
+			if parseErr != nil {
+				return inserted, fmt.Errorf("InsertStarsFromReader record %d: %v", records, parseErr)
+			}
+			batch = append(batch, star)
+		}
+
+		if len(batch) == batchSize || (err == io.EOF && len(batch) > 0) {
+			starIDs, insertErr := s.placeStarBatch(ctx, batch, rootID)
+			if insertErr != nil {
+				return inserted, fmt.Errorf("InsertStarsFromReader insert: %v", insertErr)
+			}
+			for _, starID := range starIDs {
+				inserted++
+				if mapping != nil {
+					*mapping = append(*mapping, CSVStarMapping{Line: int(inserted), StarID: starID})
+				}
+			}
+			batch = batch[:0]
+		}
+
+		if err == io.EOF {
+			return inserted, nil
+		}
+	}
+}
+
+// parseStarRecord parses a record of a .csv list of stars, see InsertStarsFromReader
+func parseStarRecord(record []string, opts InsertListOptions) (structs.Star2D, error) {
+	if len(record) != 2 && len(record) != 4 && len(record) != 5 {
+		return structs.Star2D{}, fmt.Errorf("expected 2, 4 or 5 fields, got %d", len(record))
+	}
+
+	values := make([]float64, len(record))
+	for i, field := range record {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return structs.Star2D{}, err
+		}
+		values[i] = v
+	}
+
+	star := structs.Star2D{C: structs.Vec2{X: values[0], Y: values[1]}, M: opts.Mass}
+	if opts.Scale != 0 {
+		star.C.X /= opts.Scale
+		star.C.Y /= opts.Scale
+	}
+	if len(values) >= 4 {
+		star.V = structs.Vec2{X: values[2], Y: values[3]}
+	}
+	if len(values) == 5 {
+		star.M = values[4]
+	}
+
+	return star, nil
+}
+
+// placeStarBatch inserts the given stars into the stars table and places them into the tree with the given root node
+// in a single transaction, so either all or none of the stars are inserted. If s already runs its queries in a
+// transaction (see InsertListTx), the stars are inserted as part of it and nothing is committed
+func (s *Store) placeStarBatch(ctx context.Context, stars []structs.Star2D, rootID int64) ([]int64, error) {
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	starIDs, err := txStore.insertStarBatch(ctx, stars)
+	if err != nil {
+		return nil, err
+	}
+	for _, starID := range starIDs {
+		if err := txStore.insertIntoTree(ctx, starID, rootID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("commit: %v", err)
+	}
+
+	return starIDs, nil
+}
+
+// insertStarBatch inserts the given stars into the stars table using a single query and returns their ids in the
+// order of the stars
+func (s *Store) insertStarBatch(ctx context.Context, stars []structs.Star2D) ([]int64, error) {
+	xs := make([]float64, len(stars))
+	ys := make([]float64, len(stars))
+	vxs := make([]float64, len(stars))
+	vys := make([]float64, len(stars))
+	ms := make([]float64, len(stars))
+	for i, star := range stars {
+		xs[i], ys[i] = star.C.X, star.C.Y
+		vxs[i], vys[i] = star.V.X, star.V.Y
+		ms[i] = star.M
+	}
+
+	// the ids are assigned in the order of the stars, so the sorted ids are in the same order as the stars
+	query := `INSERT INTO stars (x, y, vx, vy, m)
+		SELECT x, y, vx, vy, m FROM unnest($1::numeric[], $2::numeric[], $3::numeric[], $4::numeric[], $5::numeric[])
+			WITH ORDINALITY AS s(x, y, vx, vy, m, position) ORDER BY position
+		RETURNING star_id`
+	ids, err := s.queryIDsContext(ctx, query, pq.Array(xs), pq.Array(ys), pq.Array(vxs), pq.Array(vys), pq.Array(ms))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// ErrTreeNotFound is returned if there is no tree with the requested index
+var ErrTreeNotFound = errors.New("tree not found")
+
+// getRootNodeID gets a tree index and returns the nodeID of its root node
+// ErrTreeNotFound is returned if there is no tree with the requested index
+func (s *Store) getRootNodeID(index int64) (int64, error) {
+	return s.RootNodeID(index)
+}
+
+// RootNodeID returns the id of the root node of the tree with the given index.
+// ErrTreeNotFound is returned if there is no tree with the requested index
+func (s *Store) RootNodeID(index int64) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.rootNodeIDContext(ctx, index)
+}
+
+// rootNodeIDContext is RootNodeID using the given context for the query
+func (s *Store) rootNodeIDContext(ctx context.Context, index int64) (int64, error) {
+	var nodeID int64
+
+	log.Printf("Preparing query with the root id %d", index)
+	query := "SELECT node_id FROM nodes WHERE root_id=$1"
+	log.Printf("Sending query")
+	err := s.q.QueryRowContext(ctx, query, index).Scan(&nodeID)
+	if err == sql.ErrNoRows {
+		return 0, ErrTreeNotFound
+	}
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("RootNodeID query: %v", err))
+	}
+	log.Printf("Done Sending query")
+
+	return nodeID, nil
+}
+
+// RootNodeID is Store.RootNodeID using the given database
+func RootNodeID(database *sql.DB, index int64) (int64, error) {
+	return NewStore(database).RootNodeID(index)
+}
+
+// updateTotalMass gets a tree index and returns the nodeID of the trees root node
+func (s *Store) UpdateTotalMass(index int64) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if err := s.updateTotalMassContext(ctx, index); err != nil {
+		log.Fatalf("[ E ] %v", err)
+	}
+}
+
+// updateTotalMassContext is UpdateTotalMass using the given context for the queries, returning the errors
+func (s *Store) updateTotalMassContext(ctx context.Context, index int64) error {
+	rootNodeID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return err
+	}
+	log.Printf("RootID: %d", rootNodeID)
+	_, err = s.updateTotalMassNode(ctx, rootNodeID)
+	return err
+}
+
+// UpdateTotalMass is Store.UpdateTotalMass using the given database
+func UpdateTotalMass(database *sql.DB, index int64) {
+	NewStore(database).UpdateTotalMass(index)
+}
+
+// updateTotalMassNode updates the total mass of the given node
+func (s *Store) updateTotalMassNode(ctx context.Context, nodeID int64) (float64, error) {
+	var totalmass float64
+
+	// get the subnode ids
+	var subnode [4]int64
+
+	query := "SELECT COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3])
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("updateTotalMassNode query: %v", err))
+	}
+	// TODO: implement the getSubtreeIDs(nodeID) []int64 {...} function
+	// iterate over all subnodes updating their total masses
+	for _, subnodeID := range subnode {
+		fmt.Println("----------------------------")
+		fmt.Printf("SubdnodeID: %d\n", subnodeID)
+		if subnodeID != 0 {
+			mass, err := s.updateTotalMassNode(ctx, subnodeID)
+			if err != nil {
+				return 0, err
+			}
+			totalmass += mass
+		} else {
+			// get the starID for getting the star mass
+			starID, err := s.getStarIDContext(ctx, nodeID)
+			if err != nil {
+				return 0, err
+			}
+			fmt.Printf("StarID: %d\n", starID)
+			if starID != 0 {
+				star, err := s.getStarContext(ctx, starID)
+				if err != nil {
+					return 0, err
+				}
+				log.Printf("starID=%d \t mass: %f", starID, star.M)
+				totalmass += star.M
+			}
+
+			// break, this stops a star from being counted multiple (4) times
+			break
+		}
+		fmt.Println("----------------------------")
+	}
+
+	query = "UPDATE nodes SET total_mass=$1 WHERE node_id=$2"
+	_, err = s.q.ExecContext(ctx, query, totalmass, nodeID)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("insert total_mass query: %v", err))
+	}
+
+	fmt.Printf("nodeID: %d \t totalMass: %f\n", nodeID, totalmass)
+
+	return totalmass, nil
+}
+
+// updateCenterOfMass recursively updates the center of mass of all the nodes starting at the node with the given
+// root index
+func (s *Store) UpdateCenterOfMass(index int64) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if err := s.updateCenterOfMassContext(ctx, index); err != nil {
+		log.Fatalf("[ E ] %v", err)
+	}
+}
+
+// updateCenterOfMassContext is UpdateCenterOfMass using the given context for the queries, returning the errors
+func (s *Store) updateCenterOfMassContext(ctx context.Context, index int64) error {
+	rootNodeID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return err
+	}
+	log.Printf("RootID: %d", rootNodeID)
+	_, _, err = s.updateCenterOfMassNode(ctx, rootNodeID)
+	return err
+}
+
+// UpdateCenterOfMass is Store.UpdateCenterOfMass using the given database
+func UpdateCenterOfMass(database *sql.DB, index int64) {
+	NewStore(database).UpdateCenterOfMass(index)
+}
+
+// updateCenterOfMassNode updates the center of mass of the node with the given nodeID recursively
+// center of mass := ((x_1 * m) + (x_2 * m) + ... + (x_n * m)) / m
+// The velocity of the center of mass (the mass-weighted mean velocity) is calculated in the same way and stored in
+// the com_velocity column. Both are returned
+func (s *Store) updateCenterOfMassNode(ctx context.Context, nodeID int64) (structs.Vec2, structs.Vec2, error) {
+	fmt.Println("++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
+
+	var centerOfMass structs.Vec2
+	var centerOfMassVelocity structs.Vec2
+
+	// get the subnode ids
+	var subnode [4]int64
+	var starID int64
+
+	query := "SELECT COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0), COALESCE(star_id, 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3], &starID)
+	if err != nil {
+		return structs.Vec2{}, structs.Vec2{}, contextError(ctx, fmt.Errorf("updateCenterOfMassNode query: %v", err))
+	}
+
+	// if the nodes does not contain a star but has children, update the center of mass
+	if subnode != ([4]int64{0, 0, 0, 0}) {
+		log.Println("[   ] recursing deeper")
+
+		// define variables storing the values of the subnodes
+		var totalMass float64
+		var centerOfMassX float64
+		var centerOfMassY float64
+		var momentumX float64
+		var momentumY float64
+
+		// iterate over all the subnodes and calculate the center of mass of each node
+		for _, subnodeID := range subnode {
+			subnodeCenterOfMass, subnodeVelocity, err := s.updateCenterOfMassNode(ctx, subnodeID)
+			if err != nil {
+				return structs.Vec2{}, structs.Vec2{}, err
+			}
+
+			subnode, err := s.getNodeContext(ctx, subnodeID)
+			if err != nil {
+				return structs.Vec2{}, structs.Vec2{}, err
+			}
+
+			// only subnodes containing mass contribute, a subnode whose center of mass lies on one of the axes
+			// is weighted like every other subnode
+			if subnode.TotalMass > 0 {
+				fmt.Printf("SubnodeCenterOfMass: (%f, %f)\n", subnodeCenterOfMass.X, subnodeCenterOfMass.Y)
+				subnodeMass := subnode.TotalMass
+				totalMass += subnodeMass
+
+				centerOfMassX += subnodeCenterOfMass.X * subnodeMass
+				centerOfMassY += subnodeCenterOfMass.Y * subnodeMass
+				momentumX += subnodeVelocity.X * subnodeMass
+				momentumY += subnodeVelocity.Y * subnodeMass
+			}
+		}
+
+		// calculate the overall center of mass of the subtree, a massless subtree keeps the zero values
+		if totalMass > 0 {
+			centerOfMass = structs.Vec2{
+				X: centerOfMassX / totalMass,
+				Y: centerOfMassY / totalMass,
+			}
+			centerOfMassVelocity = structs.Vec2{
+				X: momentumX / totalMass,
+				Y: momentumY / totalMass,
+			}
+		}
+
+		// else, use the star as the center of mass (this can be done, because of the rule defining that there
+		// can only be one star in a cell)
+	} else {
+		log.Println("[   ] using the star in the node as the center of mass")
+		log.Printf("[   ] NodeID: %v", nodeID)
+
+		if starID == 0 {
+			log.Println("[   ] StarID == 0...")
+			centerOfMass = structs.Vec2{
+				X: 0,
+				Y: 0,
+			}
+		} else {
+			log.Printf("[   ] NodeID: %v", starID)
+			star, err := s.getStarContext(ctx, starID)
+			if err != nil {
+				return structs.Vec2{}, structs.Vec2{}, err
+			}
+			centerOfMassX := star.C.X
+			centerOfMassY := star.C.Y
+			centerOfMass = structs.Vec2{
+				X: centerOfMassX,
+				Y: centerOfMassY,
+			}
+			centerOfMassVelocity = star.V
+		}
+	}
+
+	// build the query
+	query = "UPDATE nodes SET center_of_mass=ARRAY[$1, $2]::numeric[], com_velocity=ARRAY[$3, $4]::numeric[] WHERE node_id=$5"
+
+	// Execute the query
+	_, err = s.q.ExecContext(ctx, query, centerOfMass.X, centerOfMass.Y, centerOfMassVelocity.X, centerOfMassVelocity.Y, nodeID)
+	if err != nil {
+		return structs.Vec2{}, structs.Vec2{}, contextError(ctx, fmt.Errorf("update center of mass query: %v", err))
+	}
+
+	fmt.Printf("[   ] CenterOfMass: (%f, %f)\n", centerOfMass.X, centerOfMass.Y)
+
+	return centerOfMass, centerOfMassVelocity, nil
+}
+
+// GetNodeCOMVelocity returns the velocity of the center of mass of the node with the given id, the mass-weighted
+// mean velocity of all the stars in the node. It is calculated by UpdateCenterOfMass, a zero vector is returned
+// for nodes that haven't been updated yet
+func (s *Store) GetNodeCOMVelocity(nodeID int64) (structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var v structs.Vec2
+
+	query := "SELECT COALESCE(com_velocity[1], 0), COALESCE(com_velocity[2], 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&v.X, &v.Y)
+	if err == sql.ErrNoRows {
+		return v, fmt.Errorf("GetNodeCOMVelocity: there is no node with the id %d", nodeID)
+	}
+	if err != nil {
+		return v, fmt.Errorf("GetNodeCOMVelocity query: %v", err)
+	}
+
+	return v, nil
+}
+
+// GetNodeCOMVelocity is Store.GetNodeCOMVelocity using the given database
+func GetNodeCOMVelocity(database *sql.DB, nodeID int64) (structs.Vec2, error) {
+	return NewStore(database).GetNodeCOMVelocity(nodeID)
+}
+
+// genForestTree generates a forest representation of the tree with the given index
+func (s *Store) GenForestTree(index int64) string {
+	rootNodeID, err := s.getRootNodeID(index)
+	if err != nil {
+		log.Fatalf("[ E ] %v", err)
+	}
+	return s.genForestTreeNode(rootNodeID)
+}
+
+// GenForestTree is Store.GenForestTree using the given database
+func GenForestTree(database *sql.DB, index int64) string {
+	return NewStore(database).GenForestTree(index)
+}
+
+// genForestTreeNodes returns a sub-representation of a given node in forest format
+func (s *Store) genForestTreeNode(nodeID int64) string {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var returnString string
+
+	// get the subnode ids
+	var subnode [4]int64
+
+	query := "SELECT COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3])
+	if err != nil {
+		log.Fatalf("[ E ] updateTotalMassNode query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	returnString += "["
+
+	// iterate over all subnodes updating their total masses
+	for _, subnodeID := range subnode {
+		if subnodeID != 0 {
+			centerOfMass := s.getCenterOfMass(nodeID)
+			mass := s.getNodeTotalMass(nodeID)
+			returnString += fmt.Sprintf("%s %s %s", formatRounded(centerOfMass.X), formatRounded(centerOfMass.Y), formatRounded(mass))
+			returnString += s.genForestTreeNode(subnodeID)
+		} else {
+			if s.getStarID(nodeID) != 0 {
+				coords := s.getStarCoordinates(nodeID)
+				starID := s.getStarID(nodeID)
+				mass := s.getStarMass(starID)
+				returnString += fmt.Sprintf("[%s %s %s]", formatRounded(coords.X), formatRounded(coords.Y), formatRounded(mass))
+			} else {
+				returnString += fmt.Sprintf("[0 0]")
+			}
+			// break, this stops a star from being counted multiple (4) times
+			break
+		}
+	}
+
+	returnString += "]"
+
+	return returnString
+}
+
+// TreeAdjacency returns the children of the nodes of the tree with the given index keyed by the id of their parent.
+// The children are ordered like in the subnode array of the parent, leaves aren't contained as keys
+func (s *Store) TreeAdjacency(index int64) (map[int64][]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.treeAdjacencyContext(ctx, index)
+}
+
+// treeAdjacencyContext is TreeAdjacency using the given context for the query
+func (s *Store) treeAdjacencyContext(ctx context.Context, index int64) (map[int64][]int64, error) {
+	query := treeNodesCTE + ` SELECT nodes.node_id, s.child FROM nodes, unnest(nodes.subnode) WITH ORDINALITY AS s(child, position)
+		WHERE nodes.node_id IN (SELECT node_id FROM tree) AND s.child<>0 ORDER BY nodes.node_id, s.position`
+	rows, err := s.q.QueryContext(ctx, query, index)
+	if err != nil {
+		return nil, fmt.Errorf("TreeAdjacency query: %v", err)
+	}
+	defer rows.Close()
+
+	adjacency := make(map[int64][]int64)
+	for rows.Next() {
+		var parent, child int64
+		if err := rows.Scan(&parent, &child); err != nil {
+			return nil, fmt.Errorf("TreeAdjacency scan: %v", err)
+		}
+		adjacency[parent] = append(adjacency[parent], child)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("TreeAdjacency rows: %v", err)
+	}
+
+	return adjacency, nil
+}
+
+// TreeAdjacency is Store.TreeAdjacency using the given database
+func TreeAdjacency(database *sql.DB, index int64) (map[int64][]int64, error) {
+	return NewStore(database).TreeAdjacency(index)
+}
+
+// TreeDOT writes the tree with the given index to w as a GraphViz DOT graph. Every node of the tree is labeled with
+// its depth, its total mass and the id of the star stored inside of it (if any); the edges point from the parents
+// to their children. ErrTreeNotFound is returned if there is no tree with the given index
+func (s *Store) TreeDOT(index int64, w io.Writer) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.rootNodeIDContext(ctx, index); err != nil {
+		return err
+	}
+
+	adjacency, err := s.treeAdjacencyContext(ctx, index)
+	if err != nil {
+		return err
+	}
+
+	query := treeNodesCTE + ` SELECT node_id, COALESCE(depth, 0), COALESCE(total_mass, 0), COALESCE(star_id, 0) FROM nodes
+		WHERE node_id IN (SELECT node_id FROM tree) ORDER BY node_id`
+	rows, err := s.q.QueryContext(ctx, query, index)
+	if err != nil {
+		return fmt.Errorf("TreeDOT query: %v", err)
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	b.WriteString("digraph tree {\n")
+
+	var nodeIDs []int64
+	for rows.Next() {
+		var nodeID, depth, starID int64
+		var mass float64
+		if err := rows.Scan(&nodeID, &depth, &mass, &starID); err != nil {
+			return fmt.Errorf("TreeDOT scan: %v", err)
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+
+		label := fmt.Sprintf("depth %d\\nmass %s", depth, formatFloat(mass))
+		if starID != 0 {
+			label += fmt.Sprintf("\\nstar %d", starID)
+		}
+		fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n", nodeID, label)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("TreeDOT rows: %v", err)
+	}
+
+	for _, nodeID := range nodeIDs {
+		for _, child := range adjacency[nodeID] {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", nodeID, child)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// TreeDOT is Store.TreeDOT using the given database
+func TreeDOT(database *sql.DB, index int64, w io.Writer) error {
+	return NewStore(database).TreeDOT(index, w)
+}
+
+// getCenterOfMass returns the center of mass of the given nodeID
+func (s *Store) getCenterOfMass(nodeID int64) structs.Vec2 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var CenterOfMass [2]float64
+
+	// get the star from the stars table
+	query := "SELECT center_of_mass[1], center_of_mass[2] FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&CenterOfMass[0], &CenterOfMass[1])
+	if err != nil {
+		log.Fatalf("[ E ] getCenterOfMass query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+
+	return structs.Vec2{X: CenterOfMass[0], Y: CenterOfMass[1]}
+}
+
+// getStarCoordinates gets the star coordinates of a star using a given nodeID.
+// It returns a vector describing the coordinates
+func (s *Store) getStarCoordinates(nodeID int64) structs.Vec2 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var Coordinates [2]float64
+
+	starID := s.getStarID(nodeID)
+
+	// get the star from the stars table
+	query := "SELECT x, y FROM stars WHERE star_id=$1"
+	err := s.q.QueryRowContext(ctx, query, starID).Scan(&Coordinates[0], &Coordinates[1])
+	if err != nil {
+		log.Fatalf("[ E ] getStarCoordinates query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+
+	fmt.Printf("%v\n", Coordinates)
+
+	return structs.Vec2{X: Coordinates[0], Y: Coordinates[1]}
+}
+
+// nodeRow contains the columns of a single row of the nodes table
+type nodeRow struct {
+	ID           int64
+	BoxCenter    structs.Vec2
+	BoxWidth     float64
+	Depth        int64
+	TotalMass    float64
+	CenterOfMass structs.Vec2
+	StarID       int64
+	IsLeaf       bool
+	Subnodes     [4]int64
+}
+
+// hasSubnodes returns true if the subnode array of the node references any children
+func (n nodeRow) hasSubnodes() bool {
+	return n.Subnodes != ([4]int64{0, 0, 0, 0})
+}
+
+// contains returns true if the given position is inside of the box of the node
+func (n nodeRow) contains(p structs.Vec2) bool {
+	return math.Abs(p.X-n.BoxCenter.X) <= n.BoxWidth/2 && math.Abs(p.Y-n.BoxCenter.Y) <= n.BoxWidth/2
+}
+
+// getNode returns the node with the given id, columns that are NULL are returned as their zero values
+func (s *Store) getNode(nodeID int64) (nodeRow, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.getNodeContext(ctx, nodeID)
+}
+
+// getNodeContext is getNode using the given context for the query
+func (s *Store) getNodeContext(ctx context.Context, nodeID int64) (nodeRow, error) {
+	n := nodeRow{ID: nodeID}
+
+	query := `SELECT box_center[1], box_center[2], box_width, COALESCE(depth, 0), COALESCE(total_mass, 0),
+		COALESCE(center_of_mass[1], 0), COALESCE(center_of_mass[2], 0), COALESCE(star_id, 0), COALESCE(isleaf, FALSE),
+		COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0)
+		FROM nodes WHERE node_id=$1`
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&n.BoxCenter.X, &n.BoxCenter.Y, &n.BoxWidth, &n.Depth, &n.TotalMass,
+		&n.CenterOfMass.X, &n.CenterOfMass.Y, &n.StarID, &n.IsLeaf,
+		&n.Subnodes[0], &n.Subnodes[1], &n.Subnodes[2], &n.Subnodes[3])
+	if err != nil {
+		return n, contextError(ctx, fmt.Errorf("getNode query (node %d): %v", nodeID, err))
+	}
+
+	return n, nil
+}
+
+// TreeNodeJSON is the JSON representation of a node used by ExportTreeJSON and ImportTreeJSON
+type TreeNodeJSON struct {
+	NodeID       int64          `json:"node_id,omitempty"`
+	BoxCenter    structs.Vec2   `json:"box_center"`
+	BoxWidth     float64        `json:"box_width"`
+	Depth        int64          `json:"depth"`
+	TotalMass    float64        `json:"total_mass"`
+	CenterOfMass structs.Vec2   `json:"center_of_mass"`
+	Star         *TreeStarJSON  `json:"star,omitempty"`
+	Subnodes     []TreeNodeJSON `json:"subnodes,omitempty"`
+}
+
+// TreeStarJSON is the JSON representation of the star stored inside of a node
+type TreeStarJSON struct {
+	StarID int64          `json:"star_id,omitempty"`
+	Star   structs.Star2D `json:"star"`
+}
+
+// ExportTreeJSON returns a JSON representation of the tree with the given index.
+// If includeIDs is true, the node_id and star_id of every node and star is exported as well, so that the export
+// can be cross-referenced with the database and imported again using the same ids
+func (s *Store) ExportTreeJSON(index int64, includeIDs bool) ([]byte, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootNodeID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := s.exportTreeJSONNode(ctx, rootNodeID, includeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(root)
+}
+
+// ExportTreeJSON is Store.ExportTreeJSON using the given database
+func ExportTreeJSON(database *sql.DB, index int64, includeIDs bool) ([]byte, error) {
+	return NewStore(database).ExportTreeJSON(index, includeIDs)
+}
+
+// exportTreeJSONNode returns the JSON representation of the node with the given id and all of its children
+func (s *Store) exportTreeJSONNode(ctx context.Context, nodeID int64, includeIDs bool) (TreeNodeJSON, error) {
+	info, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return TreeNodeJSON{}, err
+	}
+
+	node := TreeNodeJSON{
+		BoxCenter:    info.BoxCenter,
+		BoxWidth:     info.BoxWidth,
+		Depth:        info.Depth,
+		TotalMass:    info.TotalMass,
+		CenterOfMass: info.CenterOfMass,
+	}
+
+	if includeIDs {
+		node.NodeID = nodeID
+	}
+
+	if info.StarID != 0 {
+		star, err := s.getStarContext(ctx, info.StarID)
+		if err != nil {
+			return node, err
+		}
+		node.Star = &TreeStarJSON{Star: star}
+		if includeIDs {
+			node.Star.StarID = info.StarID
+		}
+	}
+
+	// a node either has all four children or none at all
+	if info.hasSubnodes() {
+		for _, subnodeID := range info.Subnodes {
+			child, err := s.exportTreeJSONNode(ctx, subnodeID, includeIDs)
+			if err != nil {
+				return node, err
+			}
+			node.Subnodes = append(node.Subnodes, child)
+		}
+	}
+
+	return node, nil
+}
+
+// ImportTreeJSON inserts the tree described by the given JSON (as generated by ExportTreeJSON) as a new tree and
+// returns the index of that tree. Nodes and stars carrying an id are inserted using exactly that id and the id
+// sequences are adjusted afterwards, nodes and stars without an id get a fresh one.
+// The import is done in a single transaction, so either the whole tree is imported or nothing at all
+func (s *Store) ImportTreeJSON(data []byte) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var root TreeNodeJSON
+	if err := json.Unmarshal(data, &root); err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON unmarshal: %v", err)
+	}
+
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	// the imported tree gets the next free index, see NewTreeAt
+	if _, err := txStore.q.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", newTreeLockID); err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON lock query: %v", err)
+	}
+	var index int64
+	err = txStore.q.QueryRowContext(ctx, "SELECT COALESCE(max(root_id), 0) + 1 FROM nodes").Scan(&index)
+	if err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON max root id query: %v", err)
+	}
+
+	rootNodeID, err := txStore.importTreeJSONNode(ctx, root, index)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = txStore.q.ExecContext(ctx, "UPDATE nodes SET root_id=$1 WHERE node_id=$2", index, rootNodeID)
+	if err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON set root id: %v", err)
+	}
+
+	if err := txStore.newTreeMeta(ctx, index); err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON %v", err)
+	}
+
+	// move the sequences past the explicitly inserted ids, so that later inserts don't collide with them
+	sequences := []string{
+		"SELECT setval('stars_star_id_seq', (SELECT max(star_id) FROM stars))",
+		"SELECT setval('nodes_node_id_seq', (SELECT max(node_id) FROM nodes))",
+	}
+	for _, query := range sequences {
+		if _, err := txStore.q.ExecContext(ctx, query); err != nil {
+			return 0, fmt.Errorf("ImportTreeJSON adjust sequence: %v\n\t\t\t query: %s", err, query)
+		}
+	}
+
+	if err := commitTx(tx); err != nil {
+		return 0, fmt.Errorf("ImportTreeJSON commit: %v", err)
+	}
+
+	return index, nil
+}
+
+// ImportTreeJSON is Store.ImportTreeJSON using the given database
+func ImportTreeJSON(database *sql.DB, data []byte) (int64, error) {
+	return NewStore(database).ImportTreeJSON(data)
+}
+
+// importTreeJSONNode inserts the given node, its star and all of its children and returns the id of the inserted node
+func (s *Store) importTreeJSONNode(ctx context.Context, node TreeNodeJSON, timestep int64) (int64, error) {
+	if len(node.Subnodes) != 0 && len(node.Subnodes) != 4 {
+		return 0, fmt.Errorf("importTreeJSONNode: a node must have zero or four subnodes, got %d", len(node.Subnodes))
+	}
+
+	// insert the star stored in the node (if there is one)
+	var starID int64
+	if node.Star != nil {
+		star := node.Star.Star
+		query := `INSERT INTO stars (star_id, x, y, vx, vy, m)
+			VALUES (COALESCE(NULLIF($1::bigint, 0), nextval('stars_star_id_seq')), $2, $3, $4, $5, $6)
+			RETURNING star_id`
+		err := s.q.QueryRowContext(ctx, query, node.Star.StarID, star.C.X, star.C.Y, star.V.X, star.V.Y, star.M).Scan(&starID)
+		if err != nil {
+			return 0, fmt.Errorf("importTreeJSONNode insert star: %v", err)
+		}
+	}
+
+	// insert the children first, their ids are needed for the subnode array of this node
+	var subnode [4]int64
+	for i, child := range node.Subnodes {
+		childID, err := s.importTreeJSONNode(ctx, child, timestep)
+		if err != nil {
+			return 0, err
+		}
+		subnode[i] = childID
+	}
+
+	query := `INSERT INTO nodes (node_id, box_center, box_width, depth, isleaf, timestep, star_id, total_mass,
+		center_of_mass, subnode)
+		VALUES (COALESCE(NULLIF($1::bigint, 0), nextval('nodes_node_id_seq')), ARRAY[$2::numeric, $3::numeric], $4,
+		$5, $6, $7, $8, $9, ARRAY[$10::numeric, $11::numeric], ARRAY[$12::bigint, $13::bigint, $14::bigint, $15::bigint])
+		RETURNING node_id`
+	var nodeID int64
+	err := s.q.QueryRowContext(ctx, query, node.NodeID, node.BoxCenter.X, node.BoxCenter.Y, node.BoxWidth, node.Depth,
+		len(node.Subnodes) == 0, timestep, starID, node.TotalMass, node.CenterOfMass.X, node.CenterOfMass.Y,
+		subnode[0], subnode[1], subnode[2], subnode[3]).Scan(&nodeID)
+	if err != nil {
+		return 0, fmt.Errorf("importTreeJSONNode insert node: %v", err)
+	}
+
+	return nodeID, nil
+}
+
+// Particle is a point mass used for rendering. It is either a single star or a pseudo-particle located at the
+// center of mass of a node carrying the total mass of all the stars inside of that node
+type Particle struct {
+	C      structs.Vec2
+	M      float64
+	Pseudo bool
+}
+
+// RenderParticles returns the particles needed to render the tree with the given index at a level of detail
+// defined by theta. The tree is walked down from the root, a node whose width relative to the width of the whole
+// tree (the angle it subtends when viewing the whole galaxy) is smaller than theta is rendered as a single
+// pseudo-particle instead of descending into it. The total masses and centers of mass of the tree must be up to date
+func (s *Store) RenderParticles(index int64, theta float64) ([]Particle, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootNodeID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := s.getNodeContext(ctx, rootNodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.renderParticlesNode(ctx, root, root.BoxWidth, theta)
+}
+
+// RenderParticles is Store.RenderParticles using the given database
+func RenderParticles(database *sql.DB, index int64, theta float64) ([]Particle, error) {
+	return NewStore(database).RenderParticles(index, theta)
+}
+
+// renderParticlesNode returns the particles needed to render the given node, see RenderParticles
+func (s *Store) renderParticlesNode(ctx context.Context, n nodeRow, rootWidth, theta float64) ([]Particle, error) {
+	// a star is always rendered as itself
+	if n.StarID != 0 {
+		star, err := s.getStarContext(ctx, n.StarID)
+		if err != nil {
+			return nil, err
+		}
+		return []Particle{{C: star.C, M: star.M}}, nil
+	}
+
+	// an empty leaf doesn't have to be rendered at all
+	if !n.hasSubnodes() {
+		return nil, nil
+	}
+
+	if n.BoxWidth/rootWidth < theta && n.TotalMass > 0 {
+		return []Particle{{C: n.CenterOfMass, M: n.TotalMass, Pseudo: true}}, nil
+	}
+
+	var particles []Particle
+	for _, subnodeID := range n.Subnodes {
+		subnode, err := s.getNodeContext(ctx, subnodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		subnodeParticles, err := s.renderParticlesNode(ctx, subnode, rootWidth, theta)
+		if err != nil {
+			return nil, err
+		}
+		particles = append(particles, subnodeParticles...)
+	}
+
+	return particles, nil
+}
+
+// StarsInView returns the particles needed to render the part of the tree with the given index inside of the
+// view spanned by min and max, never returning more than maxStars particles. If at most maxStars stars are inside
+// of the view, they are returned individually. Otherwise the tree is opened breadth first as long as the particles
+// fit into maxStars, so the view is covered by pseudo-particles (the centers of mass of nodes) of decreasing size.
+// The total masses and centers of mass of the tree must be up to date
+func (s *Store) StarsInView(index int64, min, max structs.Vec2, maxStars int) ([]Particle, error) {
+	if maxStars < 1 {
+		return nil, fmt.Errorf("StarsInView: maxStars must be positive, got %d", maxStars)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT star_id, x, y, vx, vy, m FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND x BETWEEN $2 AND $3 AND y BETWEEN $4 AND $5
+		ORDER BY star_id LIMIT $6`
+	records, err := s.queryStarRecordsContext(ctx, query, index, min.X, max.X, min.Y, max.Y, maxStars+1)
+	if err != nil {
+		return nil, fmt.Errorf("StarsInView query: %v", err)
+	}
+
+	if len(records) <= maxStars {
+		particles := make([]Particle, 0, len(records))
+		for _, r := range records {
+			particles = append(particles, Particle{C: r.Star.C, M: r.Star.M})
+		}
+		return particles, nil
+	}
+
+	root, err := s.getNodeContext(ctx, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	// open the nodes of the frontier as long as their visible children fit into maxStars
+	frontier := []nodeRow{root}
+	for opened := true; opened; {
+		opened = false
+
+		var next []nodeRow
+		for i, n := range frontier {
+			if n.StarID != 0 || !n.hasSubnodes() {
+				next = append(next, n)
+				continue
+			}
+
+			children, err := s.visibleSubnodes(ctx, n, min, max)
+			if err != nil {
+				return nil, err
+			}
+
+			// the particles already in next, the children and the remaining nodes of the frontier
+			if len(next)+len(children)+len(frontier)-i-1 > maxStars {
+				next = append(next, n)
+				continue
+			}
+
+			next = append(next, children...)
+			opened = true
+		}
+		frontier = next
+	}
+
+	var particles []Particle
+	for _, n := range frontier {
+		if n.StarID != 0 {
+			star, err := s.getStarContext(ctx, n.StarID)
+			if err != nil {
+				return nil, err
+			}
+			if inView(star.C, min, max) {
+				particles = append(particles, Particle{C: star.C, M: star.M})
+			}
+			continue
+		}
+
+		particles = append(particles, Particle{C: n.CenterOfMass, M: n.TotalMass, Pseudo: true})
+	}
+
+	return particles, nil
+}
+
+// StarsInView is Store.StarsInView using the given database
+func StarsInView(database *sql.DB, index int64, min, max structs.Vec2, maxStars int) ([]Particle, error) {
+	return NewStore(database).StarsInView(index, min, max, maxStars)
+}
+
+// visibleSubnodes returns the subnodes of the given node that overlap with the view spanned by min and max and
+// contain stars
+func (s *Store) visibleSubnodes(ctx context.Context, n nodeRow, min, max structs.Vec2) ([]nodeRow, error) {
+	var subnodes []nodeRow
+	for _, subnodeID := range n.Subnodes {
+		if subnodeID == 0 {
+			continue
+		}
+
+		subnode, err := s.getNodeContext(ctx, subnodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		// empty leaves don't have to be rendered at all
+		if subnode.StarID == 0 && !subnode.hasSubnodes() {
+			continue
+		}
+
+		halfWidth := subnode.BoxWidth / 2
+		if subnode.BoxCenter.X+halfWidth < min.X || subnode.BoxCenter.X-halfWidth > max.X ||
+			subnode.BoxCenter.Y+halfWidth < min.Y || subnode.BoxCenter.Y-halfWidth > max.Y {
+			continue
+		}
+
+		subnodes = append(subnodes, subnode)
+	}
+
+	return subnodes, nil
+}
+
+// inView returns true if the given position is inside of the view spanned by min and max
+func inView(c structs.Vec2, min, max structs.Vec2) bool {
+	return c.X >= min.X && c.X <= max.X && c.Y >= min.Y && c.Y <= max.Y
+}
+
+// updateStarForce updates the force acting on the star and returns the star.
+// The force is stored in the fx and fy columns, vx and vy always contain the velocity of the star
+func (s *Store) updateStarForce(starID int64, force structs.Vec2) structs.Star2D {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// updated the stars Force
+	query := "UPDATE stars SET fx=$1, fy=$2 WHERE star_id=$3"
+	rows, err := s.q.QueryContext(ctx, query, force.X, force.Y, starID)
+	defer rows.Close()
+	if err != nil {
+		log.Fatalf("[ E ] updateStarForce query: %v\n\t\t\t query: %s\n", err, query)
+	}
+
+	return s.GetStar(starID)
+}
+
+// CalcAllForces calculates all the forces acting on the given star.
+// The theta value it receives is used by the Barnes-Hut algorithm to determine what
+// stars to include into the calculations. The stars of the nodes that aren't opened act like a single star with the
+// total mass of the node located at its center of mass, so both have to be up to date (see UpdateTotalMass and
+// UpdateCenterOfMass). ErrTreeNotFound is returned if there is no galaxy with the given index
+func (s *Store) CalcAllForces(star structs.Star2D, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	return s.CalcAllForcesContext(context.Background(), star, galaxyIndex, theta)
+}
+
+// CalcAllForces is Store.CalcAllForces using the given database
+func CalcAllForces(database *sql.DB, star structs.Star2D, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	return NewStore(database).CalcAllForces(star, galaxyIndex, theta)
+}
+
+// CalcAllForcesContext calculates all the forces acting on the given star like CalcAllForces. The calculation stops
+// as soon as the given context is done (e.g. if the client requesting the forces disconnects) and returns the error
+// of the context (context.Canceled or context.DeadlineExceeded) then. Options.QueryTimeout limits the calculation
+// in addition to the given context
+func (s *Store) CalcAllForcesContext(ctx context.Context, star structs.Star2D, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	// calculate all the forces and add them to the list of all forces
+	// this is done recursively
+	// first of all, get the root id
+	log.Println("[db_actions] Getting the root ID")
+	rootID, err := s.rootNodeIDContext(ctx, galaxyIndex)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+	log.Println("[db_actions] Done getting the root ID")
+
+	log.Printf("[db_actions] Calculating the forces acting on the star %v", star)
+	force, err := s.calcAllForcesNodeContext(ctx, star, 0, s.opts.Softening, rootID, theta)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+	log.Printf("[db_actions] Done calculating the forces acting on the star %v", star)
+	log.Printf("[db_actions] Force: %v", force)
+
+	return force, nil
+}
+
+// CalcAllForcesContext is Store.CalcAllForcesContext using the given database
+func CalcAllForcesContext(ctx context.Context, database *sql.DB, star structs.Star2D, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	return NewStore(database).CalcAllForcesContext(ctx, star, galaxyIndex, theta)
+}
+
+// InterGalaxyForce returns the total force the galaxy with the index indexB exerts on the galaxy with the index
+// indexA. Galaxy A is treated as a point mass located at its center of mass, the forces of the stars of galaxy B are
+// calculated using the Barnes-Hut approximation with the given theta. This can drive a two-body orbit of the centers
+// of merging galaxies. Like in CalcAllForces, the total masses and centers of mass of galaxy B have to be up to date
+// (see UpdateTotalMass and UpdateCenterOfMass), nothing is written to the database.
+// ErrTreeNotFound is returned if one of the galaxies doesn't exist
+func (s *Store) InterGalaxyForce(indexA, indexB int64, theta float64) (structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.rootNodeIDContext(ctx, indexA); err != nil {
+		return structs.Vec2{}, err
+	}
+	rootB, err := s.rootNodeIDContext(ctx, indexB)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	mass, center, _, err := s.massExtent(ctx, indexA)
+	if err != nil {
+		return structs.Vec2{}, fmt.Errorf("InterGalaxyForce query: %v", err)
+	}
+
+	return s.calcAllForcesNodeContext(ctx, structs.Star2D{C: center, M: mass}, 0, s.opts.Softening, rootB, theta)
+}
+
+// InterGalaxyForce is Store.InterGalaxyForce using the given database
+func InterGalaxyForce(database *sql.DB, indexA, indexB int64, theta float64) (structs.Vec2, error) {
+	return NewStore(database).InterGalaxyForce(indexA, indexB, theta)
+}
+
+// CalcAllForcesDirect calculates all the forces acting on the given star by summing up the forces of all the stars
+// of the galaxy with the given index directly, without traversing the tree. This is the reference the Barnes-Hut
+// approximation of CalcAllForces can be compared to
+func (s *Store) CalcAllForcesDirect(star structs.Star2D, galaxyIndex int64) (structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	if _, err := s.rootNodeIDContext(ctx, galaxyIndex); err != nil {
+		return structs.Vec2{}, err
+	}
+
+	starIDs, stars, err := s.treeStarsContext(ctx, galaxyIndex)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	var force structs.Vec2
+	for i, localStar := range stars {
+		if isSameStar(starIDs[i], localStar, 0, star) || !s.isActing(localStar) {
+			continue
+		}
+
+		localEps, err := s.getStarSofteningContext(ctx, starIDs[i])
+		if err != nil {
+			return structs.Vec2{}, err
+		}
+		eps := combinedSoftening(localEps, s.opts.Softening)
+		f := calcForce(localStar, star, eps)
+		force.X += f.X
+		force.Y += f.Y
+	}
+
+	return force, nil
+}
+
+// CalcAllForcesDirect is Store.CalcAllForcesDirect using the given database
+func CalcAllForcesDirect(database *sql.DB, star structs.Star2D, galaxyIndex int64) (structs.Vec2, error) {
+	return NewStore(database).CalcAllForcesDirect(star, galaxyIndex)
+}
+
+// CalcAllForcesByID calculates all the forces acting on the star with the given ID like CalcAllForces.
+// In contrast to CalcAllForces, the star is identified by its id, so only the star itself is excluded from the
+// calculation and its own softening length is used
+func (s *Store) CalcAllForcesByID(starID int64, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, galaxyIndex)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	star, err := s.getStarContext(ctx, starID)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	return s.calcAllForcesByID(ctx, star, starID, rootID, theta)
+}
+
+// CalcAllForcesByID is Store.CalcAllForcesByID using the given database
+func CalcAllForcesByID(database *sql.DB, starID int64, galaxyIndex int64, theta float64) (structs.Vec2, error) {
+	return NewStore(database).CalcAllForcesByID(starID, galaxyIndex, theta)
+}
+
+// calcAllForcesByID calculates all the forces acting on the given star stored using the given ID, starting at the
+// root node with the given ID
+func (s *Store) calcAllForcesByID(ctx context.Context, star structs.Star2D, starID int64, rootID int64, theta float64) (structs.Vec2, error) {
+	eps, err := s.getStarSofteningContext(ctx, starID)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	return s.calcAllForcesNodeContext(ctx, star, starID, eps, rootID, theta)
+}
+
+// RecommendPoolSize returns the number of database connections needed to calculate forces using the given number of
+// workers in parallel on a tree with the given maximal depth. Every worker may hold a connection on every level of
+// the recursion, one additional connection is kept for the caller. A smaller pool can make the workers wait for
+// each other forever
+func RecommendPoolSize(workers, maxDepth int) int {
+	return workers*(maxDepth+1) + 1
+}
+
+// CalcAllForcesBatch calculates all the forces acting on the given stars like CalcAllForces using the given
+// number of workers in parallel. The forces are returned in the order of the stars. If the connection pool of the
+// database is limited to less connections than recommended by RecommendPoolSize, a warning is logged
+func (s *Store) CalcAllForcesBatch(stars []structs.Star2D, galaxyIndex int64, theta float64, workers int) ([]structs.Vec2, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("CalcAllForcesBatch: workers must be positive, got %d", workers)
+	}
+
+	rootID, err := s.getRootNodeID(galaxyIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var maxDepth int
+	query := "SELECT COALESCE(max(depth), 0) FROM nodes WHERE timestep=$1"
+	if err := s.q.QueryRowContext(ctx, query, galaxyIndex).Scan(&maxDepth); err != nil {
+		return nil, fmt.Errorf("CalcAllForcesBatch max depth query: %v", err)
+	}
+
+	recommended := RecommendPoolSize(workers, maxDepth)
+	if maxOpen := s.db.Stats().MaxOpenConnections; maxOpen > 0 && maxOpen < recommended {
+		log.Printf("[ W ] CalcAllForcesBatch: the pool is limited to %d connections, %d are recommended for %d workers", maxOpen, recommended, workers)
+	}
+
+	forces := make([]structs.Vec2, len(stars))
+	errs := make([]error, len(stars))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				forces[i], errs[i] = s.calcAllForcesNodeContext(ctx, stars[i], 0, s.opts.Softening, rootID, theta)
+			}
+		}()
+	}
+
+	for i := range stars {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return forces, nil
+}
+
+// CalcAllForcesBatch is Store.CalcAllForcesBatch using the given database
+func CalcAllForcesBatch(database *sql.DB, stars []structs.Star2D, galaxyIndex int64, theta float64, workers int) ([]structs.Vec2, error) {
+	return NewStore(database).CalcAllForcesBatch(stars, galaxyIndex, theta, workers)
+}
+
+// CalcForcesForTree calculates the forces acting on every star stored in the tree with the given index and
+// returns them keyed by the id of the star. theta is used like in CalcAllForces
+func (s *Store) CalcForcesForTree(index int64, theta float64) (map[int64]structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	if star.C.X > centerX {
-		if star.C.Y > centerY {
-			// North East condition
-			return 1
+	starIDs, stars, err := s.treeStarsContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	forces := make(map[int64]structs.Vec2, len(stars))
+	for i, star := range stars {
+		forces[starIDs[i]], err = s.calcAllForcesByID(ctx, star, starIDs[i], rootID, theta)
+		if err != nil {
+			return nil, err
 		}
-		// South East condition
-		return 3
 	}
 
-	if star.C.Y > centerY {
-		// North West condition
-		return 0
+	return forces, nil
+}
+
+// CalcForcesForTree is Store.CalcForcesForTree using the given database
+func CalcForcesForTree(database *sql.DB, index int64, theta float64) (map[int64]structs.Vec2, error) {
+	return NewStore(database).CalcForcesForTree(index, theta)
+}
+
+// StoreForces stores the given forces (keyed by the id of the star they are acting on, like the forces returned by
+// CalcForcesForTree) in the fx and fy columns of the stars table. All the forces are stored using a single query
+func (s *Store) StoreForces(forces map[int64]structs.Vec2) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.storeForces(ctx, forces)
+}
+
+// storeForces is StoreForces using the given context for the query
+func (s *Store) storeForces(ctx context.Context, forces map[int64]structs.Vec2) error {
+	ids := make([]int64, 0, len(forces))
+	fxs := make([]float64, 0, len(forces))
+	fys := make([]float64, 0, len(forces))
+	for id, force := range forces {
+		ids = append(ids, id)
+		fxs = append(fxs, force.X)
+		fys = append(fys, force.Y)
+	}
+
+	query := `UPDATE stars SET fx=f.fx, fy=f.fy
+		FROM unnest($1::bigint[], $2::numeric[], $3::numeric[]) AS f(star_id, fx, fy) WHERE stars.star_id=f.star_id`
+	_, err := s.q.ExecContext(ctx, query, pq.Array(ids), pq.Array(fxs), pq.Array(fys))
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("StoreForces query: %v", err))
+	}
+
+	return nil
+}
+
+// StoreForces is Store.StoreForces using the given database
+func StoreForces(database *sql.DB, forces map[int64]structs.Vec2) error {
+	return NewStore(database).StoreForces(forces)
+}
+
+// StarWithForce is a star together with its id and the force last stored for it using StoreForces
+type StarWithForce struct {
+	ID    int64
+	Star  structs.Star2D
+	Force structs.Vec2
+}
+
+// StarsWithForces returns the stars of the tree with the given index together with the forces stored for them,
+// ordered by their id. The force of stars without a stored force is zero
+func (s *Store) StarsWithForces(index int64) ([]StarWithForce, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT star_id, x, y, vx, vy, m, COALESCE(fx, 0), COALESCE(fy, 0) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id`, s.deletedFilter())
+	rows, err := s.q.QueryContext(ctx, query, index)
+	if err != nil {
+		return nil, fmt.Errorf("StarsWithForces query: %v", err)
+	}
+	defer rows.Close()
+
+	var stars []StarWithForce
+	for rows.Next() {
+		var star StarWithForce
+		if err := rows.Scan(&star.ID, &star.Star.C.X, &star.Star.C.Y, &star.Star.V.X, &star.Star.V.Y, &star.Star.M, &star.Force.X, &star.Force.Y); err != nil {
+			return nil, fmt.Errorf("StarsWithForces scan: %v", err)
+		}
+		stars = append(stars, star)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("StarsWithForces rows: %v", err)
+	}
+
+	return stars, nil
+}
+
+// StarsWithForces is Store.StarsWithForces using the given database
+func StarsWithForces(database *sql.DB, index int64) ([]StarWithForce, error) {
+	return NewStore(database).StarsWithForces(index)
+}
+
+// NodesOpenedFor returns the ids of the nodes in the tree with the given index that are opened (recursed into) when
+// calculating the forces acting on the given star using the given theta. All the other nodes reached by the
+// calculation are approximated. The decision is the same as the one made by CalcAllForces, so the total masses and
+// centers of mass of the tree must be up to date
+func (s *Store) NodesOpenedFor(index int64, star structs.Star2D, theta float64) ([]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var opened []int64
+	if err := s.nodesOpenedForNode(ctx, rootID, star, theta, &opened); err != nil {
+		return nil, err
+	}
+
+	return opened, nil
+}
+
+// NodesOpenedFor is Store.NodesOpenedFor using the given database
+func NodesOpenedFor(database *sql.DB, index int64, star structs.Star2D, theta float64) ([]int64, error) {
+	return NewStore(database).NodesOpenedFor(index, star, theta)
+}
+
+// nodesOpenedForNode appends the ids of the nodes opened in the subtree of the node with the given ID to opened,
+// see NodesOpenedFor. A leaf doesn't have anything to open, so it is never reported
+func (s *Store) nodesOpenedForNode(ctx context.Context, nodeID int64, star structs.Star2D, theta float64, opened *[]int64) error {
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// a leaf doesn't have anything to open, even though the force calculation handles its star exactly
+	if !n.hasSubnodes() || !s.shouldOpen(n, star, theta) {
+		return nil
+	}
+
+	*opened = append(*opened, nodeID)
+	for _, subnodeID := range n.Subnodes {
+		if subnodeID == 0 {
+			continue
+		}
+
+		if err := s.nodesOpenedForNode(ctx, subnodeID, star, theta, opened); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// calcAllForces nodes calculates the forces in between a sta	log.Printf("Calculating the forces acting on the star %v", star)r and a node and returns the overall force
+// TODO: implement the getSubtreeIDs(nodeID) []int64 {...} function
+func (s *Store) CalcAllForcesNode(star structs.Star2D, nodeID int64, theta float64) structs.Vec2 {
+	return s.calcAllForcesNode(star, 0, s.opts.Softening, nodeID, theta)
+}
+
+// CalcAllForcesNodeContext calculates the forces in between a star and a node like CalcAllForcesNode, but stops
+// traversing the tree as soon as the given context is done and returns the error of the context. Options.QueryTimeout
+// limits the calculation in addition to the given context
+func (s *Store) CalcAllForcesNodeContext(ctx context.Context, star structs.Star2D, nodeID int64, theta float64) (structs.Vec2, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	return s.calcAllForcesNodeContext(ctx, star, 0, s.opts.Softening, nodeID, theta)
+}
+
+// calcAllForcesNode calculates the forces acting on the given star like CalcAllForcesNode.
+// starID is the id of the given star, the star stored using that id is never acting on itself. If the star isn't
+// stored in the database (starID is 0), stars equal to the given star are skipped instead.
+// eps is the softening length of the given star
+func (s *Store) calcAllForcesNode(star structs.Star2D, starID int64, eps float64, nodeID int64, theta float64) structs.Vec2 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	force, err := s.calcAllForcesNodeContext(ctx, star, starID, eps, nodeID, theta)
+	if err != nil {
+		log.Fatalf("[ E ] calcAllForcesNode: %v\n", err)
+	}
+
+	return force
+}
+
+// calcAllForcesNodeContext is calcAllForcesNode stopping as soon as the given context is done
+func (s *Store) calcAllForcesNodeContext(ctx context.Context, star structs.Star2D, starID int64, eps float64, nodeID int64, theta float64) (structs.Vec2, error) {
+	if err := ctx.Err(); err != nil {
+		return structs.Vec2{}, err
+	}
+
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return structs.Vec2{}, err
+	}
+
+	log.Println("---------------------------------------")
+	log.Printf("NodeID: %d \t star: %v \t theta: %f \t nodeboxwidth: %f", nodeID, star, theta, n.BoxWidth)
+	var forceX float64
+	var forceY float64
+
+	approximate := !s.shouldOpen(n, star, theta)
+
+	// approximate the stars of the node by a pseudo star or recurse deeper into the tree
+	if approximate {
+		log.Println("[   ] localtheta < theta")
+
+		// the stars of the node act like a single star with their total mass located at their center of mass
+		if n.TotalMass > 0 {
+			pseudoStar := structs.Star2D{C: n.CenterOfMass, M: n.TotalMass}
+			force := calcForce(pseudoStar, star, combinedSoftening(s.opts.Softening, eps))
+			forceX += force.X
+			forceY += force.Y
+		}
+	} else {
+		log.Println("[   ] localtheta > theta")
+
+		// every star is stored in exactly one node, so every star acts exactly once if each node only adds the
+		// force of its own star and leaves the stars of its subtrees to the recursion
+		nodeStarID, err := s.getStarIDContext(ctx, nodeID)
+		if err != nil {
+			return structs.Vec2{}, err
+		}
+		if nodeStarID != 0 {
+			localStar, err := s.getStarContext(ctx, nodeStarID)
+			if err != nil {
+				return structs.Vec2{}, err
+			}
+			log.Printf("node %d star: %v", nodeID, localStar)
+			if !isSameStar(nodeStarID, localStar, starID, star) && s.isActing(localStar) {
+				log.Println("Not even the original star, calculating forces...")
+				localEps, err := s.getStarSofteningContext(ctx, nodeStarID)
+				if err != nil {
+					return structs.Vec2{}, err
+				}
+				var pairEps = combinedSoftening(localEps, eps)
+				var force = calcForce(localStar, star, pairEps)
+				forceX += force.X
+				forceY += force.Y
+			}
+		}
+
+		log.Printf("[   ] Iterating over subtrees")
+		subtreeIDs, err := s.getSubtreeIDsContext(ctx, nodeID)
+		if err != nil {
+			return structs.Vec2{}, err
+		}
+		for i, subtreeID := range subtreeIDs {
+			log.Printf("Subtree: %d\t ID: %d", i, subtreeID)
+
+			if subtreeID != 0 {
+				force, err := s.calcAllForcesNodeContext(ctx, star, starID, eps, subtreeID, theta)
+				if err != nil {
+					return structs.Vec2{}, err
+				}
+				log.Printf("force: %v", force)
+				forceX += force.X
+				forceY += force.Y
+			}
+		}
+
+	}
+
+	//// dont't recurse deeper into the tree
+	//if localTheta < theta {
+	//	log.Printf("localTheta < theta")
+	//	var force structs.Vec2
+	//
+	//	// if the nodeID is not zero, use the center of mass as the other star
+	//	if nodeID != 0 {
+	//		pseudoStarCoodinates := getCenterOfMass(nodeID)
+	//		PseudoStar := structs.Star2D{
+	//			C: structs.Vec2{
+	//				X: pseudoStarCoodinates.X,
+	//				Y: pseudoStarCoodinates.Y,
+	//			},
+	//			V: structs.Vec2{
+	//				X: 0,
+	//				Y: 0,
+	//			},
+	//			M: 1000,
+	//		}
+	//		log.Printf("PseudoStar: %v", PseudoStar)
+	//		force = calcForce(star, PseudoStar)
+	//
+	//		// else, use the star in the node as the other star
+	//	} else {
+	//		if getStarID(nodeID) != 0 {
+	//			var pseudoStar = GetStar(getStarID(nodeID))
+	//			force = calcForce(star, pseudoStar)
+	//		}
+	//	}
+	//
+	//	forceX = force.X
+	//	forceY = force.X
+	//
+	//// recurse deeper into the tree
+	//} else {
+	//	log.Printf("localTheta > theta")
+	//	// iterate over all subtrees and add the forces acting through them
+	//	var subtreeIDs [4]int64
+	//	subtreeIDs = getSubtreeIDs(nodeID)
+	//	for i, subtreeID := range subtreeIDs {
+	//		fmt.Printf("Subtree: %d", i)
+	//
+	//		// don't recurse into
+	//		if subtreeID != 0 {
+	//			var force = CalcAllForcesNode(star, subtreeID, theta)
+	//			log.Printf("force: %v", force)
+	//			forceX += force.X
+	//			forceY += force.Y
+	//		}
+	//	}
+	//}
+	log.Println("---------------------------------------")
+	return structs.Vec2{X: forceX, Y: forceY}, nil
+}
+
+// isSameStar returns true if the stars s1 and s2 with the given ids are the same star. The ids are compared if both
+// are known (not 0), so distinct stars sharing the same coordinates, velocity and mass aren't mistaken for each other
+func isSameStar(id1 int64, s1 structs.Star2D, id2 int64, s2 structs.Star2D) bool {
+	if id1 != 0 && id2 != 0 {
+		return id1 == id2
+	}
+
+	return s1 == s2
+}
+
+// isActing returns true if the given star exerts forces on other stars, see Options.MinActingMass
+func (s *Store) isActing(star structs.Star2D) bool {
+	return star.M > 0 && star.M >= s.opts.MinActingMass
+}
+
+// shouldOpen returns true if the given node has to be opened (recursed into) when calculating the forces acting on
+// the given star, false if the stars of the node are approximated by a pseudo star. CalcAllForces and NodesOpenedFor
+// both decide using this function, so the total masses and centers of mass of the node must be up to date
+func (s *Store) shouldOpen(n nodeRow, star structs.Star2D, theta float64) bool {
+	// in the exact mode, every node is opened
+	if s.opts.ForceCalculation != ForceApprox {
+		return true
+	}
+
+	// nodes containing the star are opened, otherwise the star would act on itself through the pseudo star.
+	// Leaves are opened as well, their single star is handled exactly
+	if !n.hasSubnodes() || n.contains(star.C) {
+		return true
+	}
+
+	// same as calcTheta, the width of the node divided by the distance to its center of mass
+	localTheta := n.BoxWidth / math.Hypot(star.C.X-n.CenterOfMass.X, star.C.Y-n.CenterOfMass.Y)
+	return localTheta >= theta
+}
+
+// calcTheta calculates the theat for a given star and a node
+func (s *Store) calcTheta(ctx context.Context, star structs.Star2D, nodeID int64) (float64, error) {
+	d, err := s.getBoxWidthContext(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	r, err := s.distance(ctx, star, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	theta := d / r
+	return theta, nil
+}
+
+// NodeOpeningAngle returns the opening angle of the node with the given ID seen from the given viewpoint: the width
+// of the node divided by the distance of the viewpoint to the center of mass of the node. This is the quantity
+// compared to theta when calculating forces (see calcTheta), so clients can use it to choose the level of detail
+// themselves. The angle is +Inf if the viewpoint is the center of mass of the node
+func (s *Store) NodeOpeningAngle(nodeID int64, viewpoint structs.Vec2) (float64, error) {
+	n, err := s.getNode(nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	r := math.Hypot(viewpoint.X-n.CenterOfMass.X, viewpoint.Y-n.CenterOfMass.Y)
+	return n.BoxWidth / r, nil
+}
+
+// NodeOpeningAngle is Store.NodeOpeningAngle using the given database
+func NodeOpeningAngle(database *sql.DB, nodeID int64, viewpoint structs.Vec2) (float64, error) {
+	return NewStore(database).NodeOpeningAngle(nodeID, viewpoint)
+}
+
+// calculate the distance in between the star and the node with the given ID
+func (s *Store) distance(ctx context.Context, star structs.Star2D, nodeID int64) (float64, error) {
+	var starX float64 = star.C.X
+	var starY float64 = star.C.Y
+	node, err := s.getNodeCenterOfMassContext(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	var nodeX float64 = node.X
+	var nodeY float64 = node.Y
+
+	var tmpX = math.Pow(starX-nodeX, 2)
+	var tmpY = math.Pow(starY-nodeY, 2)
+
+	var distance float64 = math.Sqrt(tmpX + tmpY)
+	return distance, nil
+}
+
+// getNodeCenterOfMass returns the center of mass of the node with the given ID
+func (s *Store) getNodeCenterOfMass(nodeID int64) structs.Vec2 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	centerOfMass, err := s.getNodeCenterOfMassContext(ctx, nodeID)
+	if err != nil {
+		log.Fatalf("[ E ] %v\n", err)
+	}
+
+	return centerOfMass
+}
+
+// getNodeCenterOfMassContext is getNodeCenterOfMass using the given context for the query
+func (s *Store) getNodeCenterOfMassContext(ctx context.Context, nodeID int64) (structs.Vec2, error) {
+	var Coordinates [2]float64
+
+	// get the star from the stars table
+	query := "SELECT center_of_mass[1], center_of_mass[2] FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&Coordinates[0], &Coordinates[1])
+	if err != nil {
+		return structs.Vec2{}, contextError(ctx, fmt.Errorf("getNodeCenterOfMass query: %v", err))
+	}
+
+	return structs.Vec2{X: Coordinates[0], Y: Coordinates[1]}, nil
+}
+
+// getSubtreeIDs returns the id of the subtrees of the nodeID
+func (s *Store) getSubtreeIDs(nodeID int64) [4]int64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	subtreeIDs, err := s.getSubtreeIDsContext(ctx, nodeID)
+	if err != nil {
+		log.Fatalf("[ E ] %v\n", err)
+	}
+
+	return subtreeIDs
+}
+
+// getSubtreeIDsContext returns the id of the subtrees of the nodeID using the given context for the query
+func (s *Store) getSubtreeIDsContext(ctx context.Context, nodeID int64) ([4]int64, error) {
+	var subtreeIDs [4]int64
+
+	// get the star from the stars table
+	query := "SELECT COALESCE(subnode[1], 0), COALESCE(subnode[2], 0), COALESCE(subnode[3], 0), COALESCE(subnode[4], 0) FROM nodes WHERE node_id=$1"
+	err := s.q.QueryRowContext(ctx, query, nodeID).Scan(&subtreeIDs[0], &subtreeIDs[1], &subtreeIDs[2], &subtreeIDs[3])
+	if err != nil {
+		return subtreeIDs, contextError(ctx, fmt.Errorf("getSubtreeIDs query: %v", err))
+	}
+
+	return subtreeIDs, nil
+}
+
+// calcForce calculates the force the star s1 is acting on s2.
+// eps is the combined softening length of both stars (see combinedSoftening), it keeps the force finite for close
+// encounters. Coincident stars don't define a direction, the softened force between them vanishes.
+// The force acting is returned in Newtons.
+func calcForce(s1 structs.Star2D, s2 structs.Star2D, eps float64) structs.Vec2 {
+	log.Println("+++++++++++++++++++++++++")
+	log.Printf("s1: %v", s1)
+	log.Printf("s2: %v", s2)
+	G := 6.6726 * math.Pow(10, -11)
+
+	// calculate the force acting
+	var combinedMass float64 = s1.M * s2.M
+	var distance float64 = math.Sqrt(math.Pow(math.Abs(s1.C.X-s2.C.X), 2) + math.Pow(math.Abs(s1.C.Y-s2.C.Y), 2))
+	log.Printf("combined mass: %f", combinedMass)
+	log.Printf("distance: %f", distance)
+	if distance == 0 {
+		return structs.Vec2{}
 	}
-	// South West condition
-	return 2
+
+	var scalar float64 = G * ((combinedMass) / (math.Pow(distance, 2) + math.Pow(eps, 2)))
+	log.Printf("scalar: %f", scalar)
+
+	// define a unit vector pointing from s2 to s1, gravity pulls s2 towards s1
+	var vector structs.Vec2 = structs.Vec2{X: s1.C.X - s2.C.X, Y: s1.C.Y - s2.C.Y}
+	var UnitVector structs.Vec2 = structs.Vec2{X: vector.X / distance, Y: vector.Y / distance}
+
+	// multiply the vector with the force to get a vector representing the force acting
+	var force structs.Vec2 = UnitVector.Multiply(scalar)
+	log.Println("+++++++++++++++++++++++++")
+
+	// return the force exerted on s2 by s1
+	return force
 }
 
-// getQuadrantNodeID returns the id of the requested child-node
-// Example: if a parent has four children and quadrant 0 is requested, the function returns the north east child id
-func getQuadrantNodeID(parentNodeID int64, quadrant int64) int64 {
-	var a, b, c, d []uint8
+// combinedSoftening returns the softening length used for a pair of stars with the given softening lengths
+func combinedSoftening(eps1, eps2 float64) float64 {
+	return math.Sqrt(eps1*eps1 + eps2*eps2)
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT subnode[1], subnode[2], subnode[3], subnode[4] FROM nodes WHERE node_id=%d", parentNodeID)
-	err := db.QueryRow(query).Scan(&a, &b, &c, &d)
+// getStarSoftening returns the softening length of the star with the given ID or the default Options.Softening if the
+// star doesn't have its own softening length
+func (s *Store) getStarSoftening(starID int64) float64 {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	eps, err := s.getStarSofteningContext(ctx, starID)
 	if err != nil {
-		log.Fatalf("[ E ] getQuadrantNodeID star query: %v \n\t\t\tquery: %s\n", err, query)
+		log.Fatalf("[ E ] %v\n", err)
 	}
 
-	returnA, _ := strconv.ParseInt(string(a), 10, 64)
-	returnB, _ := strconv.ParseInt(string(b), 10, 64)
-	returnC, _ := strconv.ParseInt(string(c), 10, 64)
-	returnD, _ := strconv.ParseInt(string(d), 10, 64)
+	return eps
+}
 
-	switch quadrant {
-	case 0:
-		return returnA
-	case 1:
-		return returnB
-	case 2:
-		return returnC
-	case 3:
-		return returnD
+// getStarSofteningContext is getStarSoftening using the given context for the query
+func (s *Store) getStarSofteningContext(ctx context.Context, starID int64) (float64, error) {
+	var eps sql.NullFloat64
+
+	query := "SELECT eps FROM stars WHERE star_id=$1"
+	err := s.q.QueryRowContext(ctx, query, starID).Scan(&eps)
+	if err != nil {
+		return 0, contextError(ctx, fmt.Errorf("getStarSoftening query: %v", err))
 	}
 
-	return -1
-}
+	if !eps.Valid {
+		return s.opts.Softening, nil
+	}
 
-// GetStar returns the star with the given ID from the stars table
-func GetStar(db *sql.DB, starID int64) structs.Star2D {
-	var x, y, vx, vy, m float64
+	return eps.Float64, nil
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT x, y, vx, vy, m FROM stars WHERE star_id=%d", starID)
-	err := db.QueryRow(query).Scan(&x, &y, &vx, &vy, &m)
+// adaptiveSofteningFraction is the fraction of the distance to the nearest star used as the softening length by
+// AdaptiveSoftening
+const adaptiveSofteningFraction = 0.5
+
+// AdaptiveSoftening returns a softening length for a star at the position p in the tree with the given index that
+// scales with the local separation of the stars: a fraction of the distance from p to the nearest star of the tree.
+// Stars exactly at p (like the star at p itself) are ignored. The default Options.Softening is returned if there is no
+// other star. The result can be used as the softening length of a star (see SetStarSoftening), so the forces in
+// dense regions are softened less than in sparse ones
+func (s *Store) AdaptiveSoftening(index int64, p structs.Vec2) (float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var nearest sql.NullFloat64
+
+	query := fmt.Sprintf(`SELECT min(sqrt((x-$2::numeric)^2 + (y-$3::numeric)^2)) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s
+		AND (x<>$2::numeric OR y<>$3::numeric)`, s.deletedFilter())
+	err := s.q.QueryRowContext(ctx, query, index, p.X, p.Y).Scan(&nearest)
 	if err != nil {
-		log.Fatalf("[ E ] GetStar query: %v \n\t\t\tquery: %s\n", err, query)
+		return 0, fmt.Errorf("AdaptiveSoftening query: %v", err)
 	}
 
-	star := structs.Star2D{
-		C: structs.Vec2{
-			X: x,
-			Y: y,
-		},
-		V: structs.Vec2{
-			X: vx,
-			Y: vy,
-		},
-		M: m,
+	if !nearest.Valid {
+		return s.opts.Softening, nil
 	}
 
-	return star
+	return adaptiveSofteningFraction * nearest.Float64, nil
 }
 
-// getStarIDTimestep returns the timestep the given starID is currently inside of
-func GetStarIDTimestep(db *sql.DB, starID int64) int64 {
-	var timestep int64
+// AdaptiveSoftening is Store.AdaptiveSoftening using the given database
+func AdaptiveSoftening(database *sql.DB, index int64, p structs.Vec2) (float64, error) {
+	return NewStore(database).AdaptiveSoftening(index, p)
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT timestep FROM nodes WHERE star_id=%d", starID)
-	err := db.QueryRow(query).Scan(&timestep)
+// SetStarSoftening sets the softening length of the star with the given ID
+func (s *Store) SetStarSoftening(starID int64, eps float64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	_, err := s.q.ExecContext(ctx, "UPDATE stars SET eps=$1 WHERE star_id=$2", eps, starID)
 	if err != nil {
-		log.Fatalf("[ E ] GetStar query: %v \n\t\t\tquery: %s\n", err, query)
+		return fmt.Errorf("SetStarSoftening query: %v", err)
 	}
 
-	return timestep
+	return nil
 }
 
-// getStarMass returns the mass if the star with the given ID
-func getStarMass(starID int64) float64 {
-	var mass float64
+// SetStarSoftening is Store.SetStarSoftening using the given database
+func SetStarSoftening(database *sql.DB, starID int64, eps float64) error {
+	return NewStore(database).SetStarSoftening(starID, eps)
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT m FROM stars WHERE star_id=%d", starID)
-	err := db.QueryRow(query).Scan(&mass)
+// EscapeVelocity returns the velocity needed to escape from the galaxy with the given index at the position p,
+// sqrt(2*|phi|) with phi being the gravitational potential at p. The potential is calculated using the Barnes-Hut
+// approximation with the given theta, so the total masses and centers of mass of the tree must be up to date.
+// Stars located exactly at p don't contribute to the potential (unless they are softened)
+func (s *Store) EscapeVelocity(index int64, p structs.Vec2, theta float64) (float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
 	if err != nil {
-		log.Fatalf("[ E ] getStarMass query: %v \n\t\t\tquery: %s\n", err, query)
+		return 0, err
 	}
 
-	return mass
+	phi, err := s.potentialNode(ctx, rootID, p, 0, theta)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Sqrt(2 * math.Abs(phi)), nil
 }
 
-// getNodeTotalMass returns the total mass of the node with the given ID and its children
-func getNodeTotalMass(nodeID int64) float64 {
-	var mass float64
+// EscapeVelocity is Store.EscapeVelocity using the given database
+func EscapeVelocity(database *sql.DB, index int64, p structs.Vec2, theta float64) (float64, error) {
+	return NewStore(database).EscapeVelocity(index, p, theta)
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT total_mass FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&mass)
+// UnboundStars returns the ids of the stars of the tree with the given index that are faster than the escape velocity
+// at their position (see EscapeVelocity), e.g. stars that are ejected from the galaxy. The potential acting on a
+// star doesn't include the star itself
+func (s *Store) UnboundStars(index int64, theta float64) ([]int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	rootID, err := s.rootNodeIDContext(ctx, index)
 	if err != nil {
-		log.Fatalf("[ E ] getStarMass query: %v \n\t\t\tquery: %s\n", err, query)
+		return nil, err
 	}
 
-	return mass
+	starIDs, stars, err := s.treeStarsContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var unbound []int64
+	for i, star := range stars {
+		phi, err := s.potentialNode(ctx, rootID, star.C, starIDs[i], theta)
+		if err != nil {
+			return nil, err
+		}
+
+		speed := math.Sqrt(star.V.X*star.V.X + star.V.Y*star.V.Y)
+		if speed > math.Sqrt(2*math.Abs(phi)) {
+			unbound = append(unbound, starIDs[i])
+		}
+	}
+
+	return unbound, nil
 }
 
-// removeStarFromNode removes the star from the node with the given ID
-func removeStarFromNode(nodeID int64) {
-	// build the query
-	query := fmt.Sprintf("UPDATE nodes SET star_id=0 WHERE node_id=%d", nodeID)
+// UnboundStars is Store.UnboundStars using the given database
+func UnboundStars(database *sql.DB, index int64, theta float64) ([]int64, error) {
+	return NewStore(database).UnboundStars(index, theta)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+// SpeedHistogram returns a histogram of the speeds of the stars of the tree with the given index. The speeds from 0 to
+// maxSpeed are split into the given number of equally wide bins, stars at least as fast as maxSpeed are counted in
+// the last bin
+func (s *Store) SpeedHistogram(index int64, bins int, maxSpeed float64) ([]int64, error) {
+	if bins < 1 || maxSpeed <= 0 {
+		return nil, fmt.Errorf("SpeedHistogram: bins and maxSpeed must be positive, got %d and %v", bins, maxSpeed)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `SELECT LEAST(width_bucket(sqrt(vx*vx + vy*vy), 0, $2::numeric, $3::int), $3::int) AS bin, count(*) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) GROUP BY bin`
+	rows, err := s.q.QueryContext(ctx, query, index, maxSpeed, bins)
 	if err != nil {
-		log.Fatalf("[ E ] removeStarFromNode query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, fmt.Errorf("SpeedHistogram query: %v", err)
+	}
+	defer rows.Close()
+
+	histogram := make([]int64, bins)
+	for rows.Next() {
+		var bin int
+		var count int64
+		if err := rows.Scan(&bin, &count); err != nil {
+			return nil, fmt.Errorf("SpeedHistogram scan: %v", err)
+		}
+
+		// width_bucket numbers the bins starting at 1
+		histogram[bin-1] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("SpeedHistogram rows: %v", err)
 	}
+
+	return histogram, nil
 }
 
-// getListOfStarsGo returns the list of stars in go struct format
-func GetListOfStarsGo(database *sql.DB) []structs.Star2D {
-	db = database
-	// build the query
-	query := fmt.Sprintf("SELECT * FROM stars")
+// SpeedHistogram is Store.SpeedHistogram using the given database
+func SpeedHistogram(database *sql.DB, index int64, bins int, maxSpeed float64) ([]int64, error) {
+	return NewStore(database).SpeedHistogram(index, bins, maxSpeed)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
-	if err != nil {
-		log.Fatalf("[ E ] removeStarFromNode query: %v\n\t\t\t query: %s\n", err, query)
+// VelocityDispersionProfile returns the velocity dispersion (the standard deviation of the speeds) of the stars of
+// the tree with the given index in rings around the given center. The radii from 0 to rMax are split into the given
+// number of equally wide rings, stars at least rMax away from the center aren't included. The dispersion of rings
+// without any stars is zero
+func (s *Store) VelocityDispersionProfile(index int64, center structs.Vec2, bins int, rMax float64) ([]float64, error) {
+	if bins < 1 || rMax <= 0 {
+		return nil, fmt.Errorf("VelocityDispersionProfile: bins and rMax must be positive, got %d and %v", bins, rMax)
 	}
 
-	var starList []structs.Star2D
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// iterate over the returned rows
-	for rows.Next() {
+	query := `SELECT width_bucket(sqrt((x-$2::numeric)^2 + (y-$3::numeric)^2), 0, $4::numeric, $5::int) AS bin,
+		stddev_pop(sqrt(vx*vx + vy*vy)) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) GROUP BY bin`
+	rows, err := s.q.QueryContext(ctx, query, index, center.X, center.Y, rMax, bins)
+	if err != nil {
+		return nil, fmt.Errorf("VelocityDispersionProfile query: %v", err)
+	}
+	defer rows.Close()
 
-		var starID int64
-		var x, y, vx, vy, m float64
-		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
-		if scanErr != nil {
-			log.Fatalf("[ E ] scan error: %v", scanErr)
+	profile := make([]float64, bins)
+	for rows.Next() {
+		var bin int
+		var dispersion float64
+		if err := rows.Scan(&bin, &dispersion); err != nil {
+			return nil, fmt.Errorf("VelocityDispersionProfile scan: %v", err)
 		}
 
-		star := structs.Star2D{
-			C: structs.Vec2{
-				X: x,
-				Y: y,
-			},
-			V: structs.Vec2{
-				X: vx,
-				Y: vy,
-			},
-			M: m,
+		// width_bucket numbers the rings starting at 1, stars outside of rMax are in the ring bins+1
+		if bin >= 1 && bin <= bins {
+			profile[bin-1] = dispersion
 		}
-
-		starList = append(starList, star)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("VelocityDispersionProfile rows: %v", err)
 	}
 
-	return starList
+	return profile, nil
 }
 
-// GetListOfStarIDs returns a list of all star ids in the stars table
-func GetListOfStarIDs(db *sql.DB) []int64 {
-	// build the query
-	query := fmt.Sprintf("SELECT star_id FROM stars")
+// VelocityDispersionProfile is Store.VelocityDispersionProfile using the given database
+func VelocityDispersionProfile(database *sql.DB, index int64, center structs.Vec2, bins int, rMax float64) ([]float64, error) {
+	return NewStore(database).VelocityDispersionProfile(index, center, bins, rMax)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+// ProjectOntoAxis returns the scalar projections of the positions of the stars of the tree with the given index onto
+// the given axis (the dot product with the normalized axis), ordered by the id of the stars. The projections can be
+// histogrammed to get one dimensional profiles such as edge-on views of the galaxy
+func (s *Store) ProjectOntoAxis(index int64, axis structs.Vec2) ([]float64, error) {
+	length := math.Hypot(axis.X, axis.Y)
+	if length == 0 {
+		return nil, fmt.Errorf("ProjectOntoAxis: the axis must not be the zero vector")
+	}
+	ux, uy := axis.X/length, axis.Y/length
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT x*$2::numeric + y*$3::numeric FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) AND %s ORDER BY star_id", s.deletedFilter())
+	rows, err := s.q.QueryContext(ctx, query, index, ux, uy)
 	if err != nil {
-		log.Fatalf("[ E ] GetListOfStarIDs query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, fmt.Errorf("ProjectOntoAxis query: %v", err)
 	}
+	defer rows.Close()
 
-	var starIDList []int64
+	var projections []float64
+	for rows.Next() {
+		var projection float64
+		if err := rows.Scan(&projection); err != nil {
+			return nil, fmt.Errorf("ProjectOntoAxis scan: %v", err)
+		}
+		projections = append(projections, projection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ProjectOntoAxis rows: %v", err)
+	}
 
-	// iterate over the returned rows
+	return projections, nil
+}
+
+// ProjectOntoAxis is Store.ProjectOntoAxis using the given database
+func ProjectOntoAxis(database *sql.DB, index int64, axis structs.Vec2) ([]float64, error) {
+	return NewStore(database).ProjectOntoAxis(index, axis)
+}
+
+// RotationCurve returns the circular velocity sqrt(G*M(<r)/r) of the tree with the given index around the given
+// center, where M(<r) is the mass enclosed by the radius r. The radii from 0 to rMax are split into the given number
+// of equally wide rings, the velocity of each ring is calculated at its outer radius
+func (s *Store) RotationCurve(index int64, center structs.Vec2, bins int, rMax float64) ([]float64, error) {
+	if bins < 1 || rMax <= 0 {
+		return nil, fmt.Errorf("RotationCurve: bins and rMax must be positive, got %d and %v", bins, rMax)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `SELECT width_bucket(sqrt((x-$2::numeric)^2 + (y-$3::numeric)^2), 0, $4::numeric, $5::int) AS bin,
+		sum(m) FROM stars
+		WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) GROUP BY bin`
+	rows, err := s.q.QueryContext(ctx, query, index, center.X, center.Y, rMax, bins)
+	if err != nil {
+		return nil, fmt.Errorf("RotationCurve query: %v", err)
+	}
+	defer rows.Close()
+
+	ringMass := make([]float64, bins)
 	for rows.Next() {
+		var bin int
+		var mass float64
+		if err := rows.Scan(&bin, &mass); err != nil {
+			return nil, fmt.Errorf("RotationCurve scan: %v", err)
+		}
 
-		var starID int64
-		scanErr := rows.Scan(&starID)
-		if scanErr != nil {
-			log.Fatalf("[ E ] scan error: %v", scanErr)
+		// width_bucket numbers the rings starting at 1, stars outside of rMax are in the ring bins+1
+		if bin >= 1 && bin <= bins {
+			ringMass[bin-1] = mass
 		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("RotationCurve rows: %v", err)
+	}
 
-		starIDList = append(starIDList, starID)
+	G := 6.6726 * math.Pow(10, -11)
+
+	curve := make([]float64, bins)
+	var enclosedMass float64
+	for i := range curve {
+		enclosedMass += ringMass[i]
+		r := rMax * float64(i+1) / float64(bins)
+		curve[i] = math.Sqrt(G * enclosedMass / r)
 	}
 
-	return starIDList
+	return curve, nil
 }
 
-// GetListOfStarIDs returns a list of all star ids in the stars table with the given timestep
-func GetListOfStarIDsTimestep(db *sql.DB, timestep int64) []int64 {
-	// build the query
-	query := fmt.Sprintf("SELECT star_id FROM nodes WHERE star_id<>0 AND timestep=%d", timestep)
+// RotationCurve is Store.RotationCurve using the given database
+func RotationCurve(database *sql.DB, index int64, center structs.Vec2, bins int, rMax float64) ([]float64, error) {
+	return NewStore(database).RotationCurve(index, center, bins, rMax)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
-	if err != nil {
-		log.Fatalf("[ E ] GetListOfStarIDsTimestep query: %v\n\t\t\t query: %s\n", err, query)
+// gridCellsQuery defines the cells CTE assigning the stars of the tree with the index $1 to the cells (i, j) of a
+// $2 x $2 grid spanning the bounding box of the stars, numbered starting at 1. Stars on the upper bounds of the box
+// belong to the last cells, a box without an extent is a single cell
+const gridCellsQuery = `WITH s AS (
+		SELECT x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)
+	), box AS (
+		SELECT min(x) AS x0, max(x) AS x1, min(y) AS y0, max(y) AS y1 FROM s
+	), cells AS (
+		SELECT
+			CASE WHEN box.x1>box.x0 THEN LEAST(width_bucket(s.x, box.x0, box.x1, $2::int), $2::int) ELSE 1 END AS i,
+			CASE WHEN box.y1>box.y0 THEN LEAST(width_bucket(s.y, box.y0, box.y1, $2::int), $2::int) ELSE 1 END AS j,
+			s.x, s.y, s.vx, s.vy, s.m
+		FROM s, box
+	)`
+
+// CellStats are the aggregates of the stars in a single cell of the grid returned by GridAggregate
+type CellStats struct {
+	Count        int64
+	Mass         float64
+	MeanVelocity structs.Vec2
+}
+
+// GridAggregate returns the number of stars, their total mass and their mean velocity in the cells of a gridN x gridN
+// grid spanning the bounding box of the stars of the tree with the given index. The grid is laid out like the one
+// returned by DensityGrid and computed in a single pass over the stars, cells without any stars are zero
+func (s *Store) GridAggregate(index int64, gridN int) ([][]CellStats, error) {
+	if gridN < 1 {
+		return nil, fmt.Errorf("GridAggregate: gridN must be positive, got %d", gridN)
 	}
 
-	var starIDList []int64
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// iterate over the returned rows
-	for rows.Next() {
+	query := gridCellsQuery + " SELECT i, j, count(*), sum(m), avg(vx), avg(vy) FROM cells GROUP BY i, j"
+	rows, err := s.q.QueryContext(ctx, query, index, gridN)
+	if err != nil {
+		return nil, fmt.Errorf("GridAggregate query: %v", err)
+	}
+	defer rows.Close()
 
-		var starID int64
-		scanErr := rows.Scan(&starID)
-		if scanErr != nil {
-			log.Fatalf("[ E ] scan error: %v", scanErr)
+	grid := make([][]CellStats, gridN)
+	for i := range grid {
+		grid[i] = make([]CellStats, gridN)
+	}
+	for rows.Next() {
+		var i, j int
+		var cell CellStats
+		if err := rows.Scan(&i, &j, &cell.Count, &cell.Mass, &cell.MeanVelocity.X, &cell.MeanVelocity.Y); err != nil {
+			return nil, fmt.Errorf("GridAggregate scan: %v", err)
 		}
 
-		starIDList = append(starIDList, starID)
+		// width_bucket numbers the cells starting at 1
+		grid[i-1][j-1] = cell
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GridAggregate rows: %v", err)
 	}
 
-	return starIDList
+	return grid, nil
 }
 
-// getListOfStarsCsv returns an array of strings containing the coordinates of all the stars in the stars table
-func GetListOfStarsCsv(db *sql.DB) []string {
-	// build the query
-	query := fmt.Sprintf("SELECT * FROM stars")
+// GridAggregate is Store.GridAggregate using the given database
+func GridAggregate(database *sql.DB, index int64, gridN int) ([][]CellStats, error) {
+	return NewStore(database).GridAggregate(index, gridN)
+}
 
-	// Execute the query
-	rows, err := db.Query(query)
+// DensityGrid returns the mass of the stars of the tree with the given index in the cells of a gridN x gridN grid
+// spanning the bounding box of the stars: grid[i][j] is the mass in the i-th column (along the x axis) and the j-th
+// row (along the y axis), starting at the lower left corner. The masses are summed up in a single pass over the stars
+func (s *Store) DensityGrid(index int64, gridN int) ([][]float64, error) {
+	if gridN < 1 {
+		return nil, fmt.Errorf("DensityGrid: gridN must be positive, got %d", gridN)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := gridCellsQuery + " SELECT i, j, sum(m) FROM cells GROUP BY i, j"
+	rows, err := s.q.QueryContext(ctx, query, index, gridN)
+	if err != nil {
+		return nil, fmt.Errorf("DensityGrid query: %v", err)
+	}
 	defer rows.Close()
+
+	grid := make([][]float64, gridN)
+	for i := range grid {
+		grid[i] = make([]float64, gridN)
+	}
+	for rows.Next() {
+		var i, j int
+		var mass float64
+		if err := rows.Scan(&i, &j, &mass); err != nil {
+			return nil, fmt.Errorf("DensityGrid scan: %v", err)
+		}
+
+		// width_bucket numbers the cells starting at 1
+		grid[i-1][j-1] = mass
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DensityGrid rows: %v", err)
+	}
+
+	return grid, nil
+}
+
+// DensityGrid is Store.DensityGrid using the given database
+func DensityGrid(database *sql.DB, index int64, gridN int) ([][]float64, error) {
+	return NewStore(database).DensityGrid(index, gridN)
+}
+
+// StarSpeed returns the absolute velocity of the star with the given ID
+func (s *Store) StarSpeed(starID int64) (float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var vx, vy float64
+
+	err := s.q.QueryRowContext(ctx, "SELECT vx, vy FROM stars WHERE star_id=$1", starID).Scan(&vx, &vy)
 	if err != nil {
-		log.Fatalf("[ E ] getListOfStarsCsv query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, fmt.Errorf("StarSpeed query: %v", err)
 	}
 
-	var starList []string
+	return math.Sqrt(vx*vx + vy*vy), nil
+}
 
-	// iterate over the returned rows
-	for rows.Next() {
+// StarSpeed is Store.StarSpeed using the given database
+func StarSpeed(database *sql.DB, starID int64) (float64, error) {
+	return NewStore(database).StarSpeed(starID)
+}
 
-		var starID int64
-		var x, y, vx, vy, m float64
-		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
-		if scanErr != nil {
-			log.Fatalf("[ E ] scan error: %v", scanErr)
+// potentialNode returns the gravitational potential at the position p caused by the stars in the subtree of the
+// node with the given ID. The star with the id excludeID doesn't contribute to the potential
+func (s *Store) potentialNode(ctx context.Context, nodeID int64, p structs.Vec2, excludeID int64, theta float64) (float64, error) {
+	G := 6.6726 * math.Pow(10, -11)
+
+	n, err := s.getNodeContext(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	if n.StarID != 0 {
+		if n.StarID == excludeID {
+			return 0, nil
 		}
 
-		row := fmt.Sprintf("%d, %f, %f, %f, %f, %f", starID, x, y, vx, vy, m)
-		starList = append(starList, row)
+		star, err := s.getStarContext(ctx, n.StarID)
+		if err != nil {
+			return 0, err
+		}
+		localEps, err := s.getStarSofteningContext(ctx, n.StarID)
+		if err != nil {
+			return 0, err
+		}
+		eps := combinedSoftening(localEps, s.opts.Softening)
+		r := math.Sqrt(math.Pow(star.C.X-p.X, 2) + math.Pow(star.C.Y-p.Y, 2) + eps*eps)
+		if r == 0 || !s.isActing(star) {
+			return 0, nil
+		}
+
+		return -G * star.M / r, nil
 	}
 
-	return starList
+	if !n.hasSubnodes() {
+		return 0, nil
+	}
+
+	// a node that is far away relative to its width is approximated by its center of mass
+	r := math.Sqrt(math.Pow(n.CenterOfMass.X-p.X, 2) + math.Pow(n.CenterOfMass.Y-p.Y, 2))
+	if r > 0 && n.BoxWidth/r < theta {
+		return -G * n.TotalMass / math.Sqrt(r*r+s.opts.Softening*s.opts.Softening), nil
+	}
+
+	var phi float64
+	for _, subnodeID := range n.Subnodes {
+		if subnodeID == 0 {
+			continue
+		}
+
+		subnodePhi, err := s.potentialNode(ctx, subnodeID, p, excludeID, theta)
+		if err != nil {
+			return 0, err
+		}
+		phi += subnodePhi
+	}
+
+	return phi, nil
 }
 
-// getListOfStarsTreeCsv returns an array of strings containing the coordinates of all the stars in the given tree
-func GetListOfStarsTree(database *sql.DB, treeindex int64) []structs.Star2D {
-	db = database
+// DynamicalTime returns the dynamical (free-fall) time 1/sqrt(G*rho) of the tree with the given index. The mean
+// density rho is the total mass of the stars divided by the volume of the sphere around their center of mass
+// containing all of them. Timesteps should be a small fraction of the dynamical time, otherwise the integration of
+// close encounters blows up. An error is returned if the stars don't have a mass or an extent
+func (s *Store) DynamicalTime(index int64) (float64, error) {
+	G := 6.6726 * math.Pow(10, -11)
 
-	// build the query
-	query := fmt.Sprintf("SELECT * FROM stars WHERE star_id IN(SELECT star_id FROM nodes WHERE timestep=%d)", treeindex)
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+	mass, _, radius, err := s.massExtent(ctx, index)
 	if err != nil {
-		log.Fatalf("[ E ] removeStarFromNode query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, fmt.Errorf("DynamicalTime query: %v", err)
+	}
+	if mass <= 0 || radius <= 0 {
+		return 0, fmt.Errorf("DynamicalTime: the stars of the tree %d have a mass of %v and a radius of %v", index, mass, radius)
 	}
 
-	var starList []structs.Star2D
+	density := mass / (4.0 / 3.0 * math.Pi * math.Pow(radius, 3))
 
-	// iterate over the returned rows
-	for rows.Next() {
+	return 1 / math.Sqrt(G*density), nil
+}
 
-		var starID int64
-		var x, y, vx, vy, m float64
-		scanErr := rows.Scan(&starID, &x, &y, &vx, &vy, &m)
-		if scanErr != nil {
-			log.Fatalf("[ E ] scan error: %v", scanErr)
-		}
+// DynamicalTime is Store.DynamicalTime using the given database
+func DynamicalTime(database *sql.DB, index int64) (float64, error) {
+	return NewStore(database).DynamicalTime(index)
+}
 
-		star := structs.Star2D{
-			C: structs.Vec2{
-				X: x,
-				Y: y,
-			},
-			V: structs.Vec2{
-				X: vx,
-				Y: vy,
-			},
-			M: m,
-		}
+// TidalRadius returns the tidal (Jacobi) radius R*(m/(3*hostMass))^(1/3) of the satellite star with the given id in
+// the tree with the given index, the distance from the satellite beyond which the host pulls stars away from it.
+// m is the mass of the satellite and R its distance to the host, which is assumed to be at the center of mass of the
+// other stars of the tree
+func (s *Store) TidalRadius(index int64, satelliteID int64, hostMass float64) (float64, error) {
+	if hostMass <= 0 {
+		return 0, fmt.Errorf("TidalRadius: the host mass must be positive, got %v", hostMass)
+	}
 
-		starList = append(starList, star)
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	var m float64
+	var distance sql.NullFloat64
+
+	query := `WITH s AS (
+			SELECT star_id, x, y, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)
+		), host AS (
+			SELECT sum(m*x)/NULLIF(sum(m), 0) AS x, sum(m*y)/NULLIF(sum(m), 0) AS y FROM s WHERE star_id<>$2
+		)
+		SELECT s.m, sqrt((s.x-host.x)^2 + (s.y-host.y)^2) FROM s, host WHERE s.star_id=$2`
+	err := s.q.QueryRowContext(ctx, query, index, satelliteID).Scan(&m, &distance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("TidalRadius: the star %d isn't part of the tree %d", satelliteID, index)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("TidalRadius query: %v", err)
+	}
+	if !distance.Valid {
+		return 0, fmt.Errorf("TidalRadius: the tree %d doesn't contain a host for the star %d", index, satelliteID)
 	}
 
-	return starList
+	return distance.Float64 * math.Cbrt(m/(3*hostMass)), nil
 }
 
-// insertList inserts all the stars in the given .csv into the stars and nodes table
-func InsertList(database *sql.DB, filename string) {
-	db = database
-	// open the file
-	content, readErr := ioutil.ReadFile(filename)
-	if readErr != nil {
-		panic(readErr)
+// TidalRadius is Store.TidalRadius using the given database
+func TidalRadius(database *sql.DB, index int64, satelliteID int64, hostMass float64) (float64, error) {
+	return NewStore(database).TidalRadius(index, satelliteID, hostMass)
+}
+
+// BoundingCircle returns a circle enclosing all the stars of the tree with the given index. This is a fast
+// approximation of the smallest enclosing circle: the circle is centered at the center of mass of the stars (their
+// mean position if they don't have a mass) and its radius is the distance to the star farthest away from it.
+// The circle of a tree without any stars has a radius of zero
+func (s *Store) BoundingCircle(index int64) (center structs.Vec2, radius float64, err error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	_, center, radius, err = s.massExtent(ctx, index)
+	if err != nil {
+		return center, 0, fmt.Errorf("BoundingCircle query: %v", err)
 	}
 
-	in := string(content)
-	reader := csv.NewReader(strings.NewReader(in))
+	return center, radius, nil
+}
 
-	// insert all the stars into the db
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			log.Println("EOF")
-			break
-		}
-		if err != nil {
-			log.Println("insertListErr")
-			panic(err)
-		}
+// BoundingCircle is Store.BoundingCircle using the given database
+func BoundingCircle(database *sql.DB, index int64) (center structs.Vec2, radius float64, err error) {
+	return NewStore(database).BoundingCircle(index)
+}
 
-		x, _ := strconv.ParseFloat(record[0], 64)
-		y, _ := strconv.ParseFloat(record[1], 64)
+// massExtent returns the total mass of the stars of the tree with the given index, their center of mass (their
+// mean position if they don't have a mass) and the largest distance of a star from that center
+func (s *Store) massExtent(ctx context.Context, index int64) (mass float64, center structs.Vec2, radius float64, err error) {
+	query := `WITH s AS (
+			SELECT x, y, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)
+		), c AS (
+			SELECT sum(m) AS mass,
+				COALESCE(sum(m*x)/NULLIF(sum(m), 0), avg(x)) AS x, COALESCE(sum(m*y)/NULLIF(sum(m), 0), avg(y)) AS y FROM s
+		)
+		SELECT COALESCE(c.mass, 0), COALESCE(c.x, 0), COALESCE(c.y, 0), COALESCE(max(sqrt((s.x-c.x)^2 + (s.y-c.y)^2)), 0)
+		FROM c LEFT JOIN s ON TRUE GROUP BY c.mass, c.x, c.y`
+	err = s.q.QueryRowContext(ctx, query, index).Scan(&mass, &center.X, &center.Y, &radius)
+
+	return mass, center, radius, err
+}
 
-		star := structs.Star2D{
-			C: structs.Vec2{
-				X: x / 100000,
-				Y: y / 100000,
-			},
-			V: structs.Vec2{
-				X: 0,
-				Y: 0,
-			},
-			M: 1000,
-		}
+// GalaxyMomentum returns the net linear momentum (the sum of m*v over all stars) of the tree with the given index
+func (s *Store) GalaxyMomentum(index int64) (structs.Vec2, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-		fmt.Printf("Inserting (%f, %f)\n", star.C.X, star.C.Y)
-		InsertStar(db, star, 1)
+	var momentum structs.Vec2
+
+	query := "SELECT COALESCE(sum(m*vx), 0), COALESCE(sum(m*vy), 0) FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)"
+	err := s.q.QueryRowContext(ctx, query, index).Scan(&momentum.X, &momentum.Y)
+	if err != nil {
+		return momentum, fmt.Errorf("GalaxyMomentum query: %v", err)
 	}
+
+	return momentum, nil
 }
 
-// getRootNodeID gets a tree index and returns the nodeID of its root node
-func getRootNodeID(index int64) int64 {
-	var nodeID int64
+// GalaxyMomentum is Store.GalaxyMomentum using the given database
+func GalaxyMomentum(database *sql.DB, index int64) (structs.Vec2, error) {
+	return NewStore(database).GalaxyMomentum(index)
+}
 
-	log.Printf("Preparing query with the root id %d", index)
-	query := fmt.Sprintf("SELECT node_id FROM nodes WHERE root_id=%d", index)
-	log.Printf("Sending query")
-	err := db.QueryRow(query).Scan(&nodeID)
+// RemoveBulkMotion subtracts the center of mass velocity from all the stars in the tree with the given index,
+// moving the galaxy into its rest frame
+func (s *Store) RemoveBulkMotion(index int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
 	if err != nil {
-		log.Fatalf("[ E ] getRootNodeID query: %v\n\t\t\t query: %s\n", err, query)
+		return fmt.Errorf("RemoveBulkMotion begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	// calculate the center of mass velocity: v_com = sum(m*v) / sum(m)
+	var totalMass, momentumX, momentumY float64
+	query := "SELECT COALESCE(sum(m), 0), COALESCE(sum(m*vx), 0), COALESCE(sum(m*vy), 0) FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)"
+	err = txStore.q.QueryRowContext(ctx, query, index).Scan(&totalMass, &momentumX, &momentumY)
+	if err != nil {
+		return fmt.Errorf("RemoveBulkMotion momentum query: %v", err)
+	}
+
+	// a massless galaxy has no well defined center of mass velocity
+	if totalMass == 0 {
+		return nil
+	}
+
+	// the cached center of mass velocities of the nodes are shifted along with the stars, empty nodes keep their
+	// zero velocity
+	queries := []string{
+		"UPDATE stars SET vx=vx-$1, vy=vy-$2 WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$3)",
+		`UPDATE nodes SET com_velocity=ARRAY[com_velocity[1]-$1, com_velocity[2]-$2] WHERE timestep=$3
+			AND com_velocity IS NOT NULL AND (COALESCE(total_mass, 0) > 0 OR COALESCE(star_id, 0) <> 0)`,
+	}
+	for _, query := range queries {
+		if _, err := txStore.q.ExecContext(ctx, query, momentumX/totalMass, momentumY/totalMass, index); err != nil {
+			return fmt.Errorf("RemoveBulkMotion update query: %v\n\t\t\t query: %s", err, query)
+		}
 	}
-	log.Printf("Done Sending query")
 
-	return nodeID
+	return commitTx(tx)
 }
 
-// updateTotalMass gets a tree index and returns the nodeID of the trees root node
-func UpdateTotalMass(database *sql.DB, index int64) {
-	db = database
-	rootNodeID := getRootNodeID(index)
-	log.Printf("RootID: %d", rootNodeID)
-	updateTotalMassNode(rootNodeID)
+// RemoveBulkMotion is Store.RemoveBulkMotion using the given database
+func RemoveBulkMotion(database *sql.DB, index int64) error {
+	return NewStore(database).RemoveBulkMotion(index)
 }
 
-// updateTotalMassNode updates the total mass of the given node
-func updateTotalMassNode(nodeID int64) float64 {
-	var totalmass float64
+// RecenterGalaxy moves all the stars in the tree with the given index, so that their center of mass is located at
+// the origin. The geometry of the nodes (box centers and centers of mass) is moved along with the stars, so the tree
+// stays valid without rebuilding it. Everything is done in a single transaction
+func (s *Store) RecenterGalaxy(index int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// get the subnode ids
-	var subnode [4]int64
+	txStore, tx, err := s.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("RecenterGalaxy begin: %v", err)
+	}
+	defer rollbackTx(tx)
 
-	query := fmt.Sprintf("SELECT subnode[1], subnode[2], subnode[3], subnode[4] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3])
+	// calculate the center of mass: com = sum(m*x) / sum(m)
+	var totalMass, weightedX, weightedY float64
+	query := "SELECT COALESCE(sum(m), 0), COALESCE(sum(m*x), 0), COALESCE(sum(m*y), 0) FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1)"
+	err = txStore.q.QueryRowContext(ctx, query, index).Scan(&totalMass, &weightedX, &weightedY)
 	if err != nil {
-		log.Fatalf("[ E ] updateTotalMassNode query: %v\n\t\t\t query: %s\n", err, query)
+		return fmt.Errorf("RecenterGalaxy center of mass query: %v", err)
 	}
-	// TODO: implement the getSubtreeIDs(nodeID) []int64 {...} function
-	// iterate over all subnodes updating their total masses
-	for _, subnodeID := range subnode {
-		fmt.Println("----------------------------")
-		fmt.Printf("SubdnodeID: %d\n", subnodeID)
-		if subnodeID != 0 {
-			totalmass += updateTotalMassNode(subnodeID)
-		} else {
-			// get the starID for getting the star mass
-			starID := getStarID(nodeID)
-			fmt.Printf("StarID: %d\n", starID)
-			if starID != 0 {
-				mass := getStarMass(starID)
-				log.Printf("starID=%d \t mass: %f", starID, mass)
-				totalmass += mass
-			}
 
-			// break, this stops a star from being counted multiple (4) times
-			break
+	// a massless galaxy has no well defined center of mass
+	if totalMass == 0 {
+		return nil
+	}
+	comX, comY := weightedX/totalMass, weightedY/totalMass
+
+	queries := []string{
+		"UPDATE stars SET x=x-$1, y=y-$2 WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$3)",
+		"UPDATE nodes SET box_center=ARRAY[box_center[1]-$1, box_center[2]-$2] WHERE timestep=$3",
+		"UPDATE nodes SET center_of_mass=ARRAY[center_of_mass[1]-$1, center_of_mass[2]-$2] WHERE timestep=$3 AND center_of_mass IS NOT NULL",
+	}
+	for _, query := range queries {
+		if _, err := txStore.q.ExecContext(ctx, query, comX, comY, index); err != nil {
+			return fmt.Errorf("RecenterGalaxy query: %v\n\t\t\t query: %s", err, query)
 		}
-		fmt.Println("----------------------------")
 	}
 
-	query = fmt.Sprintf("UPDATE nodes SET total_mass=%f WHERE node_id=%d", totalmass, nodeID)
-	rows, err := db.Query(query)
-	defer rows.Close()
+	return commitTx(tx)
+}
+
+// RecenterGalaxy is Store.RecenterGalaxy using the given database
+func RecenterGalaxy(database *sql.DB, index int64) error {
+	return NewStore(database).RecenterGalaxy(index)
+}
+
+// ScaleGalaxy multiplies the positions, velocities and masses of all the stars in the tree with the given index by
+// the given factors. The geometry (box centers, box widths and centers of mass), the center of mass velocities and
+// the total masses of the nodes are scaled accordingly, so the tree stays valid without rebuilding it. Everything is done in a single transaction
+func (s *Store) ScaleGalaxy(index int64, posScale, velScale, massScale float64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	// a non positive scale would mirror or collapse the tree, invalidating the quadrants of all nodes
+	if posScale <= 0 {
+		return fmt.Errorf("ScaleGalaxy: the position scale must be positive, got %f", posScale)
+	}
+
+	txStore, tx, err := s.beginTx(ctx)
 	if err != nil {
-		log.Fatalf("[ E ] insert total_mass query: %v\n\t\t\t query: %s\n", err, query)
+		return fmt.Errorf("ScaleGalaxy begin: %v", err)
+	}
+	defer rollbackTx(tx)
+
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{
+			query: treeNodesCTE + ` UPDATE stars SET x=x*$2, y=y*$2, vx=vx*$3, vy=vy*$3, m=m*$4
+				WHERE star_id IN (SELECT star_id FROM nodes WHERE node_id IN (SELECT node_id FROM tree))`,
+			args: []interface{}{index, posScale, velScale, massScale},
+		},
+		{
+			query: treeNodesCTE + ` UPDATE nodes SET box_center=ARRAY[box_center[1]*$2, box_center[2]*$2],
+				box_width=box_width*$2, total_mass=total_mass*$3 WHERE node_id IN (SELECT node_id FROM tree)`,
+			args: []interface{}{index, posScale, massScale},
+		},
+		{
+			query: treeNodesCTE + ` UPDATE nodes SET center_of_mass=ARRAY[center_of_mass[1]*$2, center_of_mass[2]*$2]
+				WHERE node_id IN (SELECT node_id FROM tree) AND center_of_mass IS NOT NULL`,
+			args: []interface{}{index, posScale},
+		},
+		{
+			query: treeNodesCTE + ` UPDATE nodes SET com_velocity=ARRAY[com_velocity[1]*$2, com_velocity[2]*$2]
+				WHERE node_id IN (SELECT node_id FROM tree) AND com_velocity IS NOT NULL`,
+			args: []interface{}{index, velScale},
+		},
 	}
 
-	fmt.Printf("nodeID: %d \t totalMass: %f\n", nodeID, totalmass)
+	for _, q := range queries {
+		if _, err := txStore.q.ExecContext(ctx, q.query, q.args...); err != nil {
+			return fmt.Errorf("ScaleGalaxy query: %v\n\t\t\t query: %s", err, q.query)
+		}
+	}
 
-	return totalmass
+	return commitTx(tx)
 }
 
-// updateCenterOfMass recursively updates the center of mass of all the nodes starting at the node with the given
-// root index
-func UpdateCenterOfMass(database *sql.DB, index int64) {
-	db = database
-	rootNodeID := getRootNodeID(index)
-	log.Printf("RootID: %d", rootNodeID)
-	updateCenterOfMassNode(rootNodeID)
+// ScaleGalaxy is Store.ScaleGalaxy using the given database
+func ScaleGalaxy(database *sql.DB, index int64, posScale, velScale, massScale float64) error {
+	return NewStore(database).ScaleGalaxy(index, posScale, velScale, massScale)
 }
 
-// updateCenterOfMassNode updates the center of mass of the node with the given nodeID recursively
-// center of mass := ((x_1 * m) + (x_2 * m) + ... + (x_n * m)) / m
-func updateCenterOfMassNode(nodeID int64) structs.Vec2 {
-	fmt.Println("++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
+// BuildFixtureTree deletes all the stars and nodes, inserts the given stars into a fresh tree, updates the total
+// masses and centers of mass of that tree and returns its index. This is a one call setup for tests and benchmarks.
+// The width of the tree is the smallest width of the form 1000*2^n containing all the given stars
+func (s *Store) BuildFixtureTree(stars []structs.Star2D) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	var centerOfMass structs.Vec2
+	width := fittingWidth(1000, stars)
 
-	// get the subnode ids
-	var subnode [4]int64
-	var starID int64
+	if err := s.deleteAllStarsContext(ctx); err != nil {
+		return 0, err
+	}
+	if err := s.deleteAllNodesContext(ctx); err != nil {
+		return 0, err
+	}
 
-	query := fmt.Sprintf("SELECT subnode[1], subnode[2], subnode[3], subnode[4], star_id FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3], &starID)
+	index, _, err := s.newTreeAt(ctx, structs.Vec2{}, width)
 	if err != nil {
-		log.Fatalf("[ E ] updateCenterOfMassNode query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, err
+	}
+	if _, err := s.insertStars(ctx, stars, index); err != nil {
+		return 0, err
 	}
 
-	// if the nodes does not contain a star but has children, update the center of mass
-	if subnode != ([4]int64{0, 0, 0, 0}) {
-		log.Println("[   ] recursing deeper")
-
-		// define variables storing the values of the subnodes
-		var totalMass float64
-		var centerOfMassX float64
-		var centerOfMassY float64
+	if err := s.updateTotalMassContext(ctx, index); err != nil {
+		return 0, err
+	}
+	if err := s.updateCenterOfMassContext(ctx, index); err != nil {
+		return 0, err
+	}
 
-		// iterate over all the subnodes and calculate the center of mass of each node
-		for _, subnodeID := range subnode {
-			subnodeCenterOfMass := updateCenterOfMassNode(subnodeID)
+	return index, nil
+}
 
-			if subnodeCenterOfMass.X != 0 && subnodeCenterOfMass.Y != 0 {
-				fmt.Printf("SubnodeCenterOfMass: (%f, %f)\n", subnodeCenterOfMass.X, subnodeCenterOfMass.Y)
-				subnodeMass := getNodeTotalMass(subnodeID)
-				totalMass += subnodeMass
+// BuildFixtureTree is Store.BuildFixtureTree using the given database
+func BuildFixtureTree(database *sql.DB, stars []structs.Star2D) (int64, error) {
+	return NewStore(database).BuildFixtureTree(stars)
+}
 
-				centerOfMassX += subnodeCenterOfMass.X * subnodeMass
-				centerOfMassY += subnodeCenterOfMass.Y * subnodeMass
-			}
+// fittingWidth returns the smallest width of the form width*2^n so that a tree centered at the origin with that
+// width contains all the given stars
+func fittingWidth(width float64, stars []structs.Star2D) float64 {
+	for _, star := range stars {
+		for math.Abs(star.C.X) >= width/2 || math.Abs(star.C.Y) >= width/2 {
+			width *= 2
 		}
+	}
 
-		// calculate the overall center of mass of the subtree
-		centerOfMass = structs.Vec2{
-			X: centerOfMassX / totalMass,
-			Y: centerOfMassY / totalMass,
-		}
+	return width
+}
 
-		// else, use the star as the center of mass (this can be done, because of the rule defining that there
-		// can only be one star in a cell)
-	} else {
-		log.Println("[   ] using the star in the node as the center of mass")
-		log.Printf("[   ] NodeID: %v", nodeID)
-		starID := getStarID(nodeID)
+// MergeGalaxies creates a new tree containing all the stars of the tree indexA and all the stars of the tree
+// indexB, the latter shifted by offsetB and boosted by velB. The new tree is wide enough to contain all the stars,
+// its index is returned. Both source trees are left unchanged
+func (s *Store) MergeGalaxies(indexA, indexB int64, offsetB structs.Vec2, velB structs.Vec2) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-		if starID == 0 {
-			log.Println("[   ] StarID == 0...")
-			centerOfMass = structs.Vec2{
-				X: 0,
-				Y: 0,
-			}
-		} else {
-			log.Printf("[   ] NodeID: %v", starID)
-			star := GetStar(nil, starID)
-			centerOfMassX := star.C.X
-			centerOfMassY := star.C.Y
-			centerOfMass = structs.Vec2{
-				X: centerOfMassX,
-				Y: centerOfMassY,
-			}
-		}
+	_, starsA, err := s.treeStarsContext(ctx, indexA)
+	if err != nil {
+		return 0, err
+	}
+	_, starsB, err := s.treeStarsContext(ctx, indexB)
+	if err != nil {
+		return 0, err
 	}
 
-	// build the query
-	query = fmt.Sprintf("UPDATE nodes SET center_of_mass='{%f, %f}' WHERE node_id=%d", centerOfMass.X, centerOfMass.Y, nodeID)
+	stars := starsA
+	for _, star := range starsB {
+		star.C.X += offsetB.X
+		star.C.Y += offsetB.Y
+		star.V.X += velB.X
+		star.V.Y += velB.Y
+		stars = append(stars, star)
+	}
 
-	// Execute the query
-	rows, err := db.Query(query)
-	defer rows.Close()
+	rootA, err := s.rootNodeIDContext(ctx, indexA)
+	if err != nil {
+		return 0, err
+	}
+	rootB, err := s.rootNodeIDContext(ctx, indexB)
+	if err != nil {
+		return 0, err
+	}
+	widthA, err := s.getBoxWidthContext(ctx, rootA)
+	if err != nil {
+		return 0, err
+	}
+	widthB, err := s.getBoxWidthContext(ctx, rootB)
+	if err != nil {
+		return 0, err
+	}
+	index, _, err := s.newTreeAt(ctx, structs.Vec2{}, fittingWidth(math.Max(widthA, widthB), stars))
 	if err != nil {
-		log.Fatalf("[ E ] update center of mass query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, err
 	}
 
-	fmt.Printf("[   ] CenterOfMass: (%f, %f)\n", centerOfMass.X, centerOfMass.Y)
+	if _, err := s.insertStars(ctx, stars, index); err != nil {
+		return 0, err
+	}
+	s.notifyChange("insert %d", index)
 
-	return centerOfMass
+	return index, nil
 }
 
-// genForestTree generates a forest representation of the tree with the given index
-func GenForestTree(database *sql.DB, index int64) string {
-	db = database
-	rootNodeID := getRootNodeID(index)
-	return genForestTreeNode(rootNodeID)
+// MergeGalaxies is Store.MergeGalaxies using the given database
+func MergeGalaxies(database *sql.DB, indexA, indexB int64, offsetB structs.Vec2, velB structs.Vec2) (int64, error) {
+	return NewStore(database).MergeGalaxies(indexA, indexB, offsetB, velB)
 }
 
-// genForestTreeNodes returns a sub-representation of a given node in forest format
-func genForestTreeNode(nodeID int64) string {
-	var returnString string
+// treeStars returns the ids and the stars stored in the tree with the given index ordered by their id
+func (s *Store) treeStars(index int64) ([]int64, []structs.Star2D, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	// get the subnode ids
-	var subnode [4]int64
+	return s.treeStarsContext(ctx, index)
+}
 
-	query := fmt.Sprintf("SELECT subnode[1], subnode[2], subnode[3], subnode[4] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&subnode[0], &subnode[1], &subnode[2], &subnode[3])
+// treeStarsContext is treeStars using the given context for the query
+func (s *Store) treeStarsContext(ctx context.Context, index int64) ([]int64, []structs.Star2D, error) {
+	query := "SELECT star_id, x, y, vx, vy, m FROM stars WHERE star_id IN (SELECT star_id FROM nodes WHERE timestep=$1) ORDER BY star_id"
+	rows, err := s.q.QueryContext(ctx, query, index)
 	if err != nil {
-		log.Fatalf("[ E ] updateTotalMassNode query: %v\n\t\t\t query: %s\n", err, query)
+		return nil, nil, contextError(ctx, fmt.Errorf("treeStars query: %v", err))
 	}
+	defer rows.Close()
 
-	returnString += "["
+	var starIDs []int64
+	var stars []structs.Star2D
 
-	// iterate over all subnodes updating their total masses
-	for _, subnodeID := range subnode {
-		if subnodeID != 0 {
-			centerOfMass := getCenterOfMass(nodeID)
-			mass := getNodeTotalMass(nodeID)
-			returnString += fmt.Sprintf("%.0f %.0f %.0f", centerOfMass.X, centerOfMass.Y, mass)
-			returnString += genForestTreeNode(subnodeID)
-		} else {
-			if getStarID(nodeID) != 0 {
-				coords := getStarCoordinates(nodeID)
-				starID := getStarID(nodeID)
-				mass := getStarMass(starID)
-				returnString += fmt.Sprintf("[%.0f %.0f %.0f]", coords.X, coords.Y, mass)
-			} else {
-				returnString += fmt.Sprintf("[0 0]")
-			}
-			// break, this stops a star from being counted multiple (4) times
-			break
+	// iterate over the returned rows
+	for rows.Next() {
+		var starID int64
+		var star structs.Star2D
+		scanErr := rows.Scan(&starID, &star.C.X, &star.C.Y, &star.V.X, &star.V.Y, &star.M)
+		if scanErr != nil {
+			return nil, nil, fmt.Errorf("treeStars scan: %v", scanErr)
 		}
-	}
 
-	returnString += "]"
+		starIDs = append(starIDs, starID)
+		stars = append(stars, star)
+	}
 
-	return returnString
+	return starIDs, stars, rows.Err()
 }
 
-// getCenterOfMass returns the center of mass of the given nodeID
-func getCenterOfMass(nodeID int64) structs.Vec2 {
+// calcTreeAccelerations calculates the acceleration acting on each of the given stars (stored using the given ids)
+// caused by the stars in the tree with the given index, whose total masses and centers of mass must be up to date.
+// The forces acting on the stars are returned as well, keyed by the ids of the stars (see StoreForces). Nothing is
+// written to the database
+func (s *Store) calcTreeAccelerations(ctx context.Context, index int64, starIDs []int64, stars []structs.Star2D, theta float64) ([]structs.Vec2, map[int64]structs.Vec2, error) {
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var CenterOfMass [2]float64
+	accelerations := make([]structs.Vec2, len(stars))
+	forces := make(map[int64]structs.Vec2, len(stars))
+	for i, star := range stars {
+		// the acceleration of a massless test particle is the force acting on a unit mass at its position,
+		// the force acting on the particle itself is zero
+		if star.M == 0 {
+			star.M = 1
+			accelerations[i], err = s.calcAllForcesByID(ctx, star, starIDs[i], rootID, theta)
+			if err != nil {
+				return nil, nil, err
+			}
+			forces[starIDs[i]] = structs.Vec2{}
+			continue
+		}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT center_of_mass[1], center_of_mass[2] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&CenterOfMass[0], &CenterOfMass[1])
-	if err != nil {
-		log.Fatalf("[ E ] getCenterOfMass query: %v \n\t\t\tquery: %s\n", err, query)
+		force, err := s.calcAllForcesByID(ctx, star, starIDs[i], rootID, theta)
+		if err != nil {
+			return nil, nil, err
+		}
+		accelerations[i] = force.Multiply(1 / star.M)
+		forces[starIDs[i]] = force
 	}
 
-	return structs.Vec2{X: CenterOfMass[0], Y: CenterOfMass[1]}
+	return accelerations, forces, nil
 }
 
-// getStarCoordinates gets the star coordinates of a star using a given nodeID.
-// It returns a vector describing the coordinates
-func getStarCoordinates(nodeID int64) structs.Vec2 {
-	var Coordinates [2]float64
+// updateTreeMasses updates the total masses and then the centers of mass of the tree with the given index
+func (s *Store) updateTreeMasses(ctx context.Context, index int64) error {
+	if err := s.updateTotalMassContext(ctx, index); err != nil {
+		return err
+	}
+
+	return s.updateCenterOfMassContext(ctx, index)
+}
+
+// StarsWithAcceleration returns the magnitude of the acceleration (|F|/m) of every star in the tree with the given
+// index keyed by the id of the star, calculated using the given theta. Large accelerations mark close encounters in
+// which the integrator needs a smaller dt. Like CalcAllForces, it only reads the tree, so its total masses and
+// centers of mass must be up to date (see UpdateTotalMass and UpdateCenterOfMass)
+func (s *Store) StarsWithAcceleration(index int64, theta float64) (map[int64]float64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	starID := getStarID(nodeID)
+	starIDs, stars, err := s.treeStarsContext(ctx, index)
+	if err != nil {
+		return nil, err
+	}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT x, y FROM stars WHERE star_id=%d", starID)
-	err := db.QueryRow(query).Scan(&Coordinates[0], &Coordinates[1])
+	accelerations, _, err := s.calcTreeAccelerations(ctx, index, starIDs, stars, theta)
 	if err != nil {
-		log.Fatalf("[ E ] getStarCoordinates query: %v \n\t\t\tquery: %s\n", err, query)
+		return nil, err
 	}
 
-	fmt.Printf("%v\n", Coordinates)
+	magnitudes := make(map[int64]float64, len(starIDs))
+	for i, starID := range starIDs {
+		magnitudes[starID] = math.Hypot(accelerations[i].X, accelerations[i].Y)
+	}
 
-	return structs.Vec2{X: Coordinates[0], Y: Coordinates[1]}
+	return magnitudes, nil
+}
+
+// StarsWithAcceleration is Store.StarsWithAcceleration using the given database
+func StarsWithAcceleration(database *sql.DB, index int64, theta float64) (map[int64]float64, error) {
+	return NewStore(database).StarsWithAcceleration(index, theta)
 }
 
-// updateStarForce updates the force acting on the star
-func updateStarForce(db *sql.DB, starID int64, force structs.Vec2) structs.Star2D {
+// StepSimulation advances the tree with the given index by one semi-implicit Euler step of the length dt:
+// the velocities are updated using the forces acting on the stars, the positions using the updated velocities.
+// The updated stars are inserted into a new tree (with the same width) whose index is returned. Every inserted star
+// records the star it is the new state of as its origin, see OrbitTrace. The forces acting on the stars of the tree
+// are stored alongside them, see StarsWithForces
+func (s *Store) StepSimulation(index int64, theta, dt float64) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-	star := GetStar(nil, starID)
-	newStar := structs.Star2D{
-		structs.Vec2{star.C.X, star.C.Y},
-		structs.Vec2{force.X, force.Y},
-		star.M,
+	starIDs, stars, err := s.treeStarsContext(ctx, index)
+	if err != nil {
+		return 0, err
 	}
 
-	// updated the stars Force
-	query := fmt.Sprintf("UPDATE stars SET vx=%f, vy=%f WHERE star_id=%d", force.X, force.Y, starID)
-	rows, err := db.Query(query)
-	defer rows.Close()
+	if err := s.updateTreeMasses(ctx, index); err != nil {
+		return 0, err
+	}
+	accelerations, forces, err := s.calcTreeAccelerations(ctx, index, starIDs, stars, theta)
 	if err != nil {
-		log.Fatalf("[ E ] updateStarForce query: %v\n\t\t\t query: %s\n", err, query)
+		return 0, err
+	}
+	if err := s.storeForces(ctx, forces); err != nil {
+		return 0, err
+	}
+	for i := range stars {
+		stars[i].V.X += accelerations[i].X * dt
+		stars[i].V.Y += accelerations[i].Y * dt
+		stars[i].C.X += stars[i].V.X * dt
+		stars[i].C.Y += stars[i].V.Y * dt
 	}
 
-	return newStar
-}
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+	width, err := s.getBoxWidthContext(ctx, rootID)
+	if err != nil {
+		return 0, err
+	}
+	newIndex, _, err := s.newTreeAt(ctx, structs.Vec2{}, width)
+	if err != nil {
+		return 0, err
+	}
+	newStarIDs, err := s.insertStars(ctx, stars, newIndex)
+	if err != nil {
+		return 0, err
+	}
+	for i, newStarID := range newStarIDs {
+		if err := s.setStarOrigin(ctx, newStarID, starIDs[i]); err != nil {
+			return 0, err
+		}
+	}
 
-// CalcAllForces calculates all the forces acting on the given star.
-// The theta value it receives is used by the Barnes-Hut algorithm to determine what
-// stars to include into the calculations
-func CalcAllForces(database *sql.DB, star structs.Star2D, galaxyIndex int64, theta float64) structs.Vec2 {
-	db = database
+	s.notifyChange("step %d", newIndex)
 
-	// calculate all the forces and add them to the list of all forces
-	// this is done recursively
-	// first of all, get the root id
-	log.Println("[db_actions] Getting the root ID")
-	rootID := getRootNodeID(galaxyIndex)
-	log.Println("[db_actions] Done getting the root ID")
+	return newIndex, nil
+}
 
-	log.Printf("[db_actions] Calculating the forces acting on the star %v", star)
-	force := CalcAllForcesNode(star, rootID, theta)
-	log.Printf("[db_actions] Done calculating the forces acting on the star %v", star)
-	log.Printf("[db_actions] Force: %v", force)
+// setStarOrigin records the star with the id fromID as the origin of the star with the given id: the star is the
+// state of the same object in a later timestep. The origin is inherited, so all the states of an object share the
+// id of its first state as their origin
+func (s *Store) setStarOrigin(ctx context.Context, starID, fromID int64) error {
+	query := "UPDATE stars SET origin_id=(SELECT COALESCE(origin_id, star_id) FROM stars WHERE star_id=$2) WHERE star_id=$1"
+	if _, err := s.q.ExecContext(ctx, query, starID, fromID); err != nil {
+		return contextError(ctx, fmt.Errorf("setStarOrigin query: %v", err))
+	}
 
-	return force
+	return nil
 }
 
-// calcAllForces nodes calculates the forces in between a sta	log.Printf("Calculating the forces acting on the star %v", star)r and a node and returns the overall force
-// TODO: implement the calcForce(star, centerOfMass) {...} function
-// TODO: implement the getSubtreeIDs(nodeID) []int64 {...} function
-func CalcAllForcesNode(star structs.Star2D, nodeID int64, theta float64) structs.Vec2 {
-	log.Println("---------------------------------------")
-	log.Printf("NodeID: %d \t star: %v \t theta: %f \t nodeboxwidth: %f", nodeID, star, theta, getBoxWidth(nodeID))
-	var forceX float64
-	var forceY float64
-	var localTheta float64
+// StepSimulation is Store.StepSimulation using the given database
+func StepSimulation(database *sql.DB, index int64, theta, dt float64) (int64, error) {
+	return NewStore(database).StepSimulation(index, theta, dt)
+}
 
-	nodeWidth := getBoxWidth(nodeID)
+// SimulateSteps advances the tree with the given index by the given number of steps using StepSimulation and
+// returns the indices of the produced trees in order, each tree being the step following the previous one.
+// The simulation stops at the first error, the indices of the trees produced until then are returned with it
+func (s *Store) SimulateSteps(index int64, theta, dt float64, steps int) ([]int64, error) {
+	var indices []int64
+	for i := 0; i < steps; i++ {
+		newIndex, err := s.StepSimulation(index, theta, dt)
+		if err != nil {
+			return indices, fmt.Errorf("SimulateSteps step %d: %v", i+1, err)
+		}
 
-	if nodeID != 0 {
-		log.Println("[theta] Calculating localtheta(star, node)")
-		log.Printf("[theta] node with: %f", nodeWidth)
-		localTheta = calcTheta(star, nodeID)
-		log.Printf("[theta] Done calculating localtheta: %v", localTheta)
+		indices = append(indices, newIndex)
+		index = newIndex
 	}
 
-	// recurse deeper into the tree
-	if localTheta < theta {
-		log.Println("[   ] localtheta < theta")
+	return indices, nil
+}
 
-	} else {
-		log.Println("[   ] localtheta > theta")
+// SimulateSteps is Store.SimulateSteps using the given database
+func SimulateSteps(database *sql.DB, index int64, theta, dt float64, steps int) ([]int64, error) {
+	return NewStore(database).SimulateSteps(index, theta, dt, steps)
+}
 
-		log.Printf("[   ] Iterating over subtrees")
-		var subtreeIDs [4]int64
-		subtreeIDs = getSubtreeIDs(nodeID)
-		for i, subtreeID := range subtreeIDs {
-			log.Printf("Subtree: %d\t ID: %d", i, subtreeID)
+// StepLeapfrogKDK advances the tree with the given index by one kick-drift-kick leapfrog step of the length dt:
+// the velocities get a half step kick, the positions drift a full step using the half stepped velocities, the
+// forces are recalculated at the new positions and the velocities get a second half step kick.
+// This conserves the energy a lot better than StepSimulation. The updated stars are inserted into a new tree
+// (with the same width) whose index is returned, recording their origin like StepSimulation
+func (s *Store) StepLeapfrogKDK(index int64, theta, dt float64) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
-			if subtreeID != 0 {
-				subtreeStarId := getStarID(subtreeID)
-				if subtreeStarId != 0 {
-					var localStar = GetStar(nil, subtreeStarId)
-					log.Printf("subtree %d star: %v", i, localStar)
-					if localStar != star {
-						log.Println("Not even the original star, calculating forces...")
-						var force = calcForce(localStar, star)
-						forceX += force.X
-						forceY += force.Y
-					}
-				}
-				var force = CalcAllForcesNode(star, subtreeID, theta)
-				log.Printf("force: %v", force)
-				forceX += force.X
-				forceY += force.Y
-			}
-		}
+	starIDs, stars, err := s.treeStarsContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
 
+	// kick (half step) and drift (full step)
+	if err := s.updateTreeMasses(ctx, index); err != nil {
+		return 0, err
+	}
+	accelerations, forces, err := s.calcTreeAccelerations(ctx, index, starIDs, stars, theta)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.storeForces(ctx, forces); err != nil {
+		return 0, err
+	}
+	for i := range stars {
+		stars[i].V.X += accelerations[i].X * dt / 2
+		stars[i].V.Y += accelerations[i].Y * dt / 2
+		stars[i].C.X += stars[i].V.X * dt
+		stars[i].C.Y += stars[i].V.Y * dt
 	}
 
-	//// dont't recurse deeper into the tree
-	//if localTheta < theta {
-	//	log.Printf("localTheta < theta")
-	//	var force structs.Vec2
-	//
-	//	// if the nodeID is not zero, use the center of mass as the other star
-	//	if nodeID != 0 {
-	//		pseudoStarCoodinates := getCenterOfMass(nodeID)
-	//		PseudoStar := structs.Star2D{
-	//			C: structs.Vec2{
-	//				X: pseudoStarCoodinates.X,
-	//				Y: pseudoStarCoodinates.Y,
-	//			},
-	//			V: structs.Vec2{
-	//				X: 0,
-	//				Y: 0,
-	//			},
-	//			M: 1000,
-	//		}
-	//		log.Printf("PseudoStar: %v", PseudoStar)
-	//		force = calcForce(star, PseudoStar)
-	//
-	//		// else, use the star in the node as the other star
-	//	} else {
-	//		if getStarID(nodeID) != 0 {
-	//			var pseudoStar = GetStar(getStarID(nodeID))
-	//			force = calcForce(star, pseudoStar)
-	//		}
-	//	}
-	//
-	//	forceX = force.X
-	//	forceY = force.X
-	//
-	//// recurse deeper into the tree
-	//} else {
-	//	log.Printf("localTheta > theta")
-	//	// iterate over all subtrees and add the forces acting through them
-	//	var subtreeIDs [4]int64
-	//	subtreeIDs = getSubtreeIDs(nodeID)
-	//	for i, subtreeID := range subtreeIDs {
-	//		fmt.Printf("Subtree: %d", i)
-	//
-	//		// don't recurse into
-	//		if subtreeID != 0 {
-	//			var force = CalcAllForcesNode(star, subtreeID, theta)
-	//			log.Printf("force: %v", force)
-	//			forceX += force.X
-	//			forceY += force.Y
-	//		}
-	//	}
-	//}
-	log.Println("---------------------------------------")
-	return structs.Vec2{forceX, forceY}
-}
+	rootID, err := s.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+	width, err := s.getBoxWidthContext(ctx, rootID)
+	if err != nil {
+		return 0, err
+	}
+	newIndex, _, err := s.newTreeAt(ctx, structs.Vec2{}, width)
+	if err != nil {
+		return 0, err
+	}
+	newStarIDs, err := s.insertStars(ctx, stars, newIndex)
+	if err != nil {
+		return 0, err
+	}
+	for i, newStarID := range newStarIDs {
+		if err := s.setStarOrigin(ctx, newStarID, starIDs[i]); err != nil {
+			return 0, err
+		}
+		starIDs[i] = newStarID
+	}
 
-// calcTheta calculates the theat for a given star and a node
-func calcTheta(star structs.Star2D, nodeID int64) float64 {
-	d := getBoxWidth(nodeID)
-	r := distance(star, nodeID)
-	theta := d / r
-	return theta
-}
+	// kick (half step) using the forces at the drifted positions
+	if err := s.updateTreeMasses(ctx, newIndex); err != nil {
+		return 0, err
+	}
+	accelerations, forces, err = s.calcTreeAccelerations(ctx, newIndex, starIDs, stars, theta)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.storeForces(ctx, forces); err != nil {
+		return 0, err
+	}
+	for i := range stars {
+		velocity := structs.Vec2{
+			X: stars[i].V.X + accelerations[i].X*dt/2,
+			Y: stars[i].V.Y + accelerations[i].Y*dt/2,
+		}
 
-// calculate the distance in between the star and the node with the given ID
-func distance(star structs.Star2D, nodeID int64) float64 {
-	var starX float64 = star.C.X
-	var starY float64 = star.C.Y
-	var node structs.Vec2 = getNodeCenterOfMass(nodeID)
-	var nodeX float64 = node.X
-	var nodeY float64 = node.Y
+		if err := s.setStarVelocity(ctx, starIDs[i], velocity); err != nil {
+			return 0, err
+		}
+	}
 
-	var tmpX = math.Pow(starX-nodeX, 2)
-	var tmpY = math.Pow(starY-nodeY, 2)
+	s.notifyChange("step %d", newIndex)
 
-	var distance float64 = math.Sqrt(tmpX + tmpY)
-	return distance
+	return newIndex, nil
 }
 
-// getNodeCenterOfMass returns the center of mass of the node with the given ID
-func getNodeCenterOfMass(nodeID int64) structs.Vec2 {
-	var Coordinates [2]float64
+// StepLeapfrogKDK is Store.StepLeapfrogKDK using the given database
+func StepLeapfrogKDK(database *sql.DB, index int64, theta, dt float64) (int64, error) {
+	return NewStore(database).StepLeapfrogKDK(index, theta, dt)
+}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT center_of_mass[1], center_of_mass[2] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&Coordinates[0], &Coordinates[1])
+// ReinsertStarsNextTimestep builds the tree of the timestep following the tree with the given index (which has to be
+// the latest tree) using the same center and width. Like in StepSimulation, every star of the tree is inserted into
+// the new tree as a new star recording the star it is the new state of as its origin, so the trajectory of a star
+// can be followed using OrbitTrace. The stars contained in moved (keyed by their id in the tree with the given index)
+// are inserted in their new state, the other stars as they are. The stars of the earlier trees aren't changed.
+// Everything is done in a single transaction. The index of the new tree is returned
+func (s *Store) ReinsertStarsNextTimestep(index int64, moved map[int64]structs.Star2D) (int64, error) {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	txStore, tx, err := s.beginTx(ctx)
 	if err != nil {
-		log.Fatalf("[ E ] getNodeCenterOfMass query: %v \n\t\t\tquery: %s\n", err, query)
+		return 0, fmt.Errorf("ReinsertStarsNextTimestep begin: %v", err)
 	}
+	defer rollbackTx(tx)
 
-	return structs.Vec2{X: Coordinates[0], Y: Coordinates[1]}
-}
+	// no tree may be created between checking that the tree is the latest one and creating the next one
+	if _, err := txStore.q.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", newTreeLockID); err != nil {
+		return 0, fmt.Errorf("ReinsertStarsNextTimestep lock query: %v", err)
+	}
 
-// getSubtreeIDs returns the id of the subtrees of the nodeID
-func getSubtreeIDs(nodeID int64) [4]int64 {
+	var latest int64
+	if err := txStore.q.QueryRowContext(ctx, "SELECT COALESCE(max(root_id), 0) FROM nodes").Scan(&latest); err != nil {
+		return 0, fmt.Errorf("ReinsertStarsNextTimestep max root id query: %v", err)
+	}
+	if latest != index {
+		return 0, fmt.Errorf("ReinsertStarsNextTimestep: the tree %d isn't the latest tree (%d)", index, latest)
+	}
 
-	var subtreeIDs [4]int64
+	rootID, err := txStore.rootNodeIDContext(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+	root, err := txStore.getNodeContext(ctx, rootID)
+	if err != nil {
+		return 0, err
+	}
 
-	// get the star from the stars table
-	query := fmt.Sprintf("SELECT subnode[1], subnode[2], subnode[3], subnode[4] FROM nodes WHERE node_id=%d", nodeID)
-	err := db.QueryRow(query).Scan(&subtreeIDs[0], &subtreeIDs[1], &subtreeIDs[2], &subtreeIDs[3])
+	starIDs, stars, err := txStore.treeStarsContext(ctx, index)
 	if err != nil {
-		log.Fatalf("[ E ] getSubtreeIDs query: %v \n\t\t\tquery: %s\n", err, query)
+		return 0, err
 	}
 
-	return subtreeIDs
-}
+	positions := make(map[int64]int, len(starIDs))
+	for i, starID := range starIDs {
+		positions[starID] = i
+	}
+	for starID, star := range moved {
+		i, ok := positions[starID]
+		if !ok {
+			return 0, fmt.Errorf("ReinsertStarsNextTimestep: the star %d isn't part of the tree %d", starID, index)
+		}
+		stars[i] = star
+	}
 
-// calcForce calculates the force the star s1 is acting on s2.
-// The force acting is returned in Newtons.
-func calcForce(s1 structs.Star2D, s2 structs.Star2D) structs.Vec2 {
-	log.Println("+++++++++++++++++++++++++")
-	log.Printf("s1: %v", s1)
-	log.Printf("s2: %v", s2)
-	G := 6.6726 * math.Pow(10, -11)
+	newIndex, newRootID, err := txStore.newTreeAt(ctx, root.BoxCenter, root.BoxWidth)
+	if err != nil {
+		return 0, err
+	}
 
-	// calculate the force acting
-	var combinedMass float64 = s1.M * s2.M
-	var distance float64 = math.Sqrt(math.Pow(math.Abs(s1.C.X-s2.C.X), 2) + math.Pow(math.Abs(s1.C.Y-s2.C.Y), 2))
-	log.Printf("combined mass: %f", combinedMass)
-	log.Printf("distance: %f", distance)
+	for i, star := range stars {
+		newStarID, err := txStore.insertIntoStars(ctx, star)
+		if err != nil {
+			return 0, err
+		}
+		if err := txStore.setStarOrigin(ctx, newStarID, starIDs[i]); err != nil {
+			return 0, err
+		}
+		if err := txStore.insertIntoTree(ctx, newStarID, newRootID); err != nil {
+			return 0, err
+		}
+	}
 
-	var scalar float64 = G * ((combinedMass) / math.Pow(distance, 2))
-	log.Printf("scalar: %f", scalar)
+	if err := commitTx(tx); err != nil {
+		return 0, fmt.Errorf("ReinsertStarsNextTimestep commit: %v", err)
+	}
+	s.notifyChange("step %d", newIndex)
 
-	// define a unit vector pointing from s1 to s2
-	var vector structs.Vec2 = structs.Vec2{s2.C.X - s1.C.X, s2.C.Y - s1.C.Y}
-	var UnitVector structs.Vec2 = structs.Vec2{vector.X / distance, vector.Y / distance}
+	return newIndex, nil
+}
 
-	// multiply the vector with the force to get a vector representing the force acting
-	var force structs.Vec2 = UnitVector.Multiply(scalar)
-	log.Println("+++++++++++++++++++++++++")
+// ReinsertStarsNextTimestep is Store.ReinsertStarsNextTimestep using the given database
+func ReinsertStarsNextTimestep(database *sql.DB, index int64, moved map[int64]structs.Star2D) (int64, error) {
+	return NewStore(database).ReinsertStarsNextTimestep(index, moved)
+}
 
-	// return the force exerted on s1 by s2
-	return force
+// setStarVelocity sets the velocity of the star with the given ID
+func (s *Store) setStarVelocity(ctx context.Context, starID int64, velocity structs.Vec2) error {
+	_, err := s.q.ExecContext(ctx, "UPDATE stars SET vx=$1, vy=$2 WHERE star_id=$3", velocity.X, velocity.Y, starID)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("setStarVelocity query: %v", err))
+	}
+
+	return nil
 }
 
-func InitStarsTable(db *sql.DB) {
+func (s *Store) InitStarsTable() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
 	query := `CREATE TABLE public.stars
 (
     star_id bigint NOT NULL DEFAULT nextval('stars_star_id_seq'::regclass),
@@ -1286,16 +6844,31 @@ func InitStarsTable(db *sql.DB) {
     y numeric,
     vx numeric,
     vy numeric,
-    m numeric
+    m numeric,
+    eps numeric,
+    deleted boolean NOT NULL DEFAULT FALSE,
+    fx numeric,
+    fy numeric,
+    ext_id text UNIQUE,
+    meta jsonb,
+    origin_id bigint
 )
 `
-	_, err := db.Exec(query)
+	_, err := s.q.ExecContext(ctx, query)
 	if err != nil {
 		log.Fatalf("[ E ] InitNodesTable query: %v \n\t\t\tquery: %s\n", err, query)
 	}
 }
 
-func InitNodesTable(db *sql.DB) {
+// InitStarsTable is Store.InitStarsTable using the given database
+func InitStarsTable(database *sql.DB) {
+	NewStore(database).InitStarsTable()
+}
+
+func (s *Store) InitNodesTable() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
 	query := `CREATE TABLE public.nodes
 	(
 		node_id bigint NOT NULL DEFAULT nextval('nodes_node_id_seq'::regclass),
@@ -1307,11 +6880,174 @@ func InitNodesTable(db *sql.DB) {
 		isleaf boolean,
 		box_center numeric[] NOT NULL,
 		center_of_mass numeric[] NOT NULL,
+		com_velocity numeric[],
 		subnodes bigint[] NOT NULL
 	)
 `
-	_, err := db.Exec(query)
+	_, err := s.q.ExecContext(ctx, query)
 	if err != nil {
 		log.Fatalf("[ E ] InitNodesTable query: %v \n\t\t\tquery: %s\n", err, query)
 	}
 }
+
+// InitNodesTable is Store.InitNodesTable using the given database
+func InitNodesTable(database *sql.DB) {
+	NewStore(database).InitNodesTable()
+}
+
+// InitPartitionedNodesTable creates the nodes table (if it doesn't exist yet) partitioned by the timestep, so that
+// the nodes of a single timestep are stored in their own table. This keeps very long simulations manageable and
+// lets queries scoped to a timestep only scan its partition. It is used instead of InitNodesTable on a new
+// database, the partition of every timestep has to be created using CreateTimestepPartition (see
+// Options.PartitionNodes) before nodes can be inserted into it
+func (s *Store) InitPartitionedNodesTable() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `CREATE SEQUENCE IF NOT EXISTS nodes_node_id_seq;
+	CREATE TABLE IF NOT EXISTS nodes
+	(
+		node_id bigint NOT NULL DEFAULT nextval('nodes_node_id_seq'::regclass),
+		box_width numeric NOT NULL,
+		total_mass numeric,
+		depth integer,
+		star_id bigint,
+		root_id bigint,
+		isleaf boolean,
+		box_center numeric[] NOT NULL,
+		center_of_mass numeric[],
+		com_velocity numeric[],
+		subnode bigint[],
+		timestep bigint NOT NULL
+	) PARTITION BY LIST (timestep)
+`
+	_, err := s.q.ExecContext(ctx, query)
+	if err != nil {
+		log.Fatalf("[ E ] InitPartitionedNodesTable query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+}
+
+// InitPartitionedNodesTable is Store.InitPartitionedNodesTable using the given database
+func InitPartitionedNodesTable(database *sql.DB) {
+	NewStore(database).InitPartitionedNodesTable()
+}
+
+// CreateTimestepPartition creates the partition of the nodes table storing the nodes of the given timestep (if it
+// doesn't exist yet). The nodes table has to be created using InitPartitionedNodesTable
+func (s *Store) CreateTimestepPartition(timestep int64) error {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	return s.createTimestepPartitionContext(ctx, timestep)
+}
+
+// createTimestepPartitionContext is CreateTimestepPartition using the given context for the query
+func (s *Store) createTimestepPartitionContext(ctx context.Context, timestep int64) error {
+	// identifiers and partition bounds can't be passed as parameters
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS nodes_timestep_%d PARTITION OF nodes FOR VALUES IN (%d)", timestep, timestep)
+	if _, err := s.q.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("CreateTimestepPartition query: %v", err)
+	}
+
+	return nil
+}
+
+// CreateTimestepPartition is Store.CreateTimestepPartition using the given database
+func CreateTimestepPartition(database *sql.DB, timestep int64) error {
+	return NewStore(database).CreateTimestepPartition(timestep)
+}
+
+// InitTreeMetaTable creates the tree_meta table storing the metadata of the trees (if it doesn't exist yet)
+func (s *Store) InitTreeMetaTable() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	_, err := s.q.ExecContext(ctx, treeMetaTableQuery)
+	if err != nil {
+		log.Fatalf("[ E ] InitTreeMetaTable query: %v \n\t\t\tquery: %s\n", err, treeMetaTableQuery)
+	}
+}
+
+// InitTreeMetaTable is Store.InitTreeMetaTable using the given database
+func InitTreeMetaTable(database *sql.DB) {
+	NewStore(database).InitTreeMetaTable()
+}
+
+// InitCurrentTreeTable creates the current_tree table (if it doesn't exist yet). It contains a single row storing the
+// index of the published tree, see PublishTree
+func (s *Store) InitCurrentTreeTable() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	query := `CREATE TABLE IF NOT EXISTS public.current_tree
+	(
+		id boolean PRIMARY KEY DEFAULT TRUE CHECK (id),
+		index bigint NOT NULL
+	)
+`
+	_, err := s.q.ExecContext(ctx, query)
+	if err != nil {
+		log.Fatalf("[ E ] InitCurrentTreeTable query: %v \n\t\t\tquery: %s\n", err, query)
+	}
+}
+
+// InitCurrentTreeTable is Store.InitCurrentTreeTable using the given database
+func InitCurrentTreeTable(database *sql.DB) {
+	NewStore(database).InitCurrentTreeTable()
+}
+
+// MigrateTables adds the columns and tables introduced after the initial schema to an existing database. Running it
+// is mandatory: the queries on the stars table rely on the eps and deleted columns. Only the tree_meta table is
+// created on demand when a tree is created. It is idempotent, so it can be run on every start.
+// Only the schema is migrated, the existing stars are kept as they are: their vx and vy columns are velocities and
+// their forces (fx and fy) are empty until they are calculated, which reads as a zero force (see StarsWithForces).
+// The initial schema never stored forces in vx and vy (updateStarForce wasn't called by any operation), and even
+// if a force had been stored there, the stars table doesn't record it, so there is nothing that could be moved to
+// fx and fy.
+// The box_width column of the nodes used to be subdivided as if it stored half the width of a box (the children of a
+// node were centered half of its box_width away from its center). It is the full width now, so the widths of the trees
+// subdivided that way are doubled, which keeps the area covered by every node. Trees consisting of their root node
+// only can't be told apart and are kept as they are
+func (s *Store) MigrateTables() {
+	ctx, cancel := s.queryContext()
+	defer cancel()
+
+	s.InitTreeMetaTable()
+	s.InitCurrentTreeTable()
+
+	queries := []string{
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS eps numeric",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT FALSE",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS fx numeric",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS fy numeric",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS ext_id text",
+		"CREATE UNIQUE INDEX IF NOT EXISTS stars_ext_id_key ON stars (ext_id)",
+		"ALTER TABLE nodes ADD COLUMN IF NOT EXISTS com_velocity numeric[]",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS meta jsonb",
+		"ALTER TABLE stars ADD COLUMN IF NOT EXISTS origin_id bigint",
+		halfWidthTreesMigration,
+	}
+
+	for _, query := range queries {
+		_, err := s.q.ExecContext(ctx, query)
+		if err != nil {
+			log.Fatalf("[ E ] MigrateTables query: %v \n\t\t\tquery: %s\n", err, query)
+		}
+	}
+}
+
+// halfWidthTreesMigration doubles the box widths of the trees whose children are centered half of the width of their
+// parent away from it (instead of a quarter of it), see MigrateTables. The widths stored in the trees being rounded,
+// the offset of a child is compared to both and the closer one wins. Migrated trees don't match anymore
+const halfWidthTreesMigration = `WITH half_width_trees AS (
+		SELECT DISTINCT parent.timestep FROM nodes parent JOIN nodes child ON child.node_id=parent.subnode[1]
+		WHERE NOT parent.isleaf AND parent.box_width > 0
+		AND abs(abs(child.box_center[1]-parent.box_center[1]) - parent.box_width/2)
+			< abs(abs(child.box_center[1]-parent.box_center[1]) - parent.box_width/4)
+	)
+	UPDATE nodes SET box_width=box_width*2 WHERE timestep IN (SELECT timestep FROM half_width_trees)`
+
+// MigrateTables is Store.MigrateTables using the given database
+func MigrateTables(database *sql.DB) {
+	NewStore(database).MigrateTables()
+}
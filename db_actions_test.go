@@ -17,17 +17,147 @@
 package db_actions
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"git.darknebu.la/GalaxySimulator/structs"
-	_ "github.com/lib/pq"
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+// db is the database the tests are run on, every test connects to it first
+var db *sql.DB
+
+// TestMain migrates the database the tests are run on, so that the tests don't depend on the order they are run in
+func TestMain(m *testing.M) {
+	MigrateTables(ConnectToDB(DBNAME))
+
+	os.Exit(m.Run())
+}
+
+func TestMigrateTables(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	// a stars table using the initial schema storing a star
+	query := `ALTER TABLE stars DROP COLUMN IF EXISTS eps, DROP COLUMN IF EXISTS deleted, DROP COLUMN IF EXISTS fx,
+		DROP COLUMN IF EXISTS fy, DROP COLUMN IF EXISTS ext_id, DROP COLUMN IF EXISTS meta,
+		DROP COLUMN IF EXISTS origin_id`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatalf("dropping the columns: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO stars (x, y, vx, vy, m) VALUES (100, 100, 3, 4, 1000)"); err != nil {
+		t.Fatalf("inserting the star: %v", err)
+	}
+
+	// migrating twice is the same as migrating once
+	MigrateTables(db)
+	MigrateTables(db)
+
+	if placed, err := PlaceUnplacedStars(db, 1); err != nil || placed != 1 {
+		t.Fatalf("PlaceUnplacedStars() = %d, %v, want 1 placed star", placed, err)
+	}
+
+	// the velocity of the star is kept and it doesn't have a force yet
+	stars, err := StarsWithForces(db, 1)
+	if err != nil {
+		t.Fatalf("StarsWithForces() error = %v", err)
+	}
+	if len(stars) != 1 {
+		t.Fatalf("StarsWithForces() returned %d stars, want 1", len(stars))
+	}
+	want := StarWithForce{ID: stars[0].ID, Star: structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 3, Y: 4}, M: 1000}}
+	if stars[0] != want {
+		t.Errorf("StarsWithForces() = %v, want [%v]", stars, want)
+	}
+}
+
+func TestMigrateTablesHalfWidthTrees(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// a tree using the current geometry
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+	width := store.getBoxWidth(rootID)
+
+	// a tree subdivided like by earlier versions: the root covers -1000 <= x, y <= 1000 storing half of its width
+	oldIndex := index + 1
+	var oldRootID int64
+	query := `INSERT INTO nodes (box_width, root_id, box_center, depth, isleaf, timestep, star_id, subnode)
+		VALUES (1000, $1, '{0, 0}', 0, FALSE, $1, 0, '{0, 0, 0, 0}') RETURNING node_id`
+	if err := db.QueryRow(query, oldIndex).Scan(&oldRootID); err != nil {
+		t.Fatalf("inserting the old root: %v", err)
+	}
+	var children []int64
+	for _, center := range []structs.Vec2{{X: 500, Y: 500}, {X: 500, Y: -500}, {X: -500, Y: 500}, {X: -500, Y: -500}} {
+		query := `INSERT INTO nodes (box_width, box_center, depth, isleaf, timestep, star_id, subnode)
+			VALUES (500, ARRAY[$1, $2]::numeric[], 1, TRUE, $3, 0, '{0, 0, 0, 0}') RETURNING node_id`
+		var childID int64
+		if err := db.QueryRow(query, center.X, center.Y, oldIndex).Scan(&childID); err != nil {
+			t.Fatalf("inserting the old child: %v", err)
+		}
+		children = append(children, childID)
+	}
+	if _, err := db.Exec("UPDATE nodes SET subnode=$1 WHERE node_id=$2", pq.Array(children), oldRootID); err != nil {
+		t.Fatalf("subdividing the old root: %v", err)
+	}
+
+	// migrating twice is the same as migrating once
+	MigrateTables(db)
+	MigrateTables(db)
+
+	if got := store.getBoxWidth(oldRootID); got != 2000 {
+		t.Errorf("width of the migrated root = %v, want 2000", got)
+	}
+	if err := ValidateSubdivision(db, oldRootID); err != nil {
+		t.Errorf("ValidateSubdivision() of the migrated root error = %v", err)
+	}
+	if got := store.getBoxWidth(rootID); got != width {
+		t.Errorf("width of the current root = %v, want the unchanged %v", got, width)
+	}
+	if err := ValidateSubdivision(db, rootID); err != nil {
+		t.Errorf("ValidateSubdivision() of the current root error = %v", err)
+	}
+}
+
 func TestCalcAllForces(t *testing.T) {
 	// define a database
-	db = ConnectToDB()
+	db = ConnectToDB(DBNAME)
 	db.SetMaxOpenConns(75)
 
 	type args struct {
@@ -132,16 +262,87 @@ func TestCalcAllForces(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := CalcAllForces(tt.args.database, tt.args.star, tt.args.galaxyIndex, tt.args.theta); !reflect.DeepEqual(got, tt.want) {
+			got, err := CalcAllForces(tt.args.database, tt.args.star, tt.args.galaxyIndex, tt.args.theta)
+			if err != nil {
+				t.Fatalf("CalcAllForces() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("CalcAllForces() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+// mustRootNodeID returns the id of the root node of the tree with the given index, failing the test on errors
+func mustRootNodeID(t *testing.T, index int64) int64 {
+	t.Helper()
+
+	rootID, err := NewStore(db).getRootNodeID(index)
+	if err != nil {
+		t.Fatalf("getRootNodeID(%d) error = %v", index, err)
+	}
+
+	return rootID
+}
+
+// mustListOfStarsTree returns the stars of the tree with the given index, failing the test on errors
+func mustNewNode(t *testing.T, x, y, width float64, depth, timestep int64) int64 {
+	t.Helper()
+
+	nodeID, err := NewStore(db).newNode(context.Background(), x, y, width, depth, timestep)
+	if err != nil {
+		t.Fatalf("newNode() error = %v", err)
+	}
+
+	return nodeID
+}
+
+func mustInsertIntoStars(t *testing.T, star structs.Star2D) int64 {
+	t.Helper()
+
+	starID, err := NewStore(db).insertIntoStars(context.Background(), star)
+	if err != nil {
+		t.Fatalf("insertIntoStars() error = %v", err)
+	}
+
+	return starID
+}
+
+func mustListOfStarsTree(t *testing.T, index int64) []structs.Star2D {
+	t.Helper()
+
+	stars, err := GetListOfStarsTree(db, index)
+	if err != nil {
+		t.Fatalf("GetListOfStarsTree(%d) error = %v", index, err)
+	}
+
+	return stars
+}
+
+func TestErrTreeNotFound(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	if _, err := store.getRootNodeID(2); err != ErrTreeNotFound {
+		t.Errorf("getRootNodeID() error = %v, want %v", err, ErrTreeNotFound)
+	}
+	if _, err := CalcAllForces(db, structs.Star2D{M: 1000}, 2, 0.5); err != ErrTreeNotFound {
+		t.Errorf("CalcAllForces() error = %v, want %v", err, ErrTreeNotFound)
+	}
+	if _, err := GetListOfStarsTree(db, 2); err != ErrTreeNotFound {
+		t.Errorf("GetListOfStarsTree() error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
 func TestInsertStar(t *testing.T) {
 	// define the connection to a database
-	db = ConnectToDB()
+	db = ConnectToDB(DBNAME)
 	db.SetMaxOpenConns(75)
 
 	// delete all preexisting stars and nodes
@@ -278,9 +479,19 @@ func TestInsertStar(t *testing.T) {
 
 func TestGetListOfStarsTree(t *testing.T) {
 	// define a database
-	db = ConnectToDB()
+	db = ConnectToDB(DBNAME)
 	db.SetMaxOpenConns(75)
 
+	// set up a fresh tree containing the expected stars
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 300, Y: 300}, V: structs.Vec2{X: 0.1, Y: 0.3}, M: 1},
+		{C: structs.Vec2{X: 200, Y: 200}, V: structs.Vec2{X: 0.1, Y: 0.3}, M: 2},
+		{C: structs.Vec2{X: 400, Y: 400}, V: structs.Vec2{X: 0.1, Y: 0.3}, M: 4},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
 	type args struct {
 		database  *sql.DB
 		treeindex int64
@@ -294,7 +505,7 @@ func TestGetListOfStarsTree(t *testing.T) {
 			name: "Get all stars for the treeindex 1",
 			args: args{
 				database:  db,
-				treeindex: 1,
+				treeindex: index,
 			},
 			want: []structs.Star2D{
 				{
@@ -317,9 +528,4457 @@ func TestGetListOfStarsTree(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := GetListOfStarsTree(tt.args.database, tt.args.treeindex); !reflect.DeepEqual(got, tt.want) {
+			got, err := GetListOfStarsTree(tt.args.database, tt.args.treeindex)
+			if err != nil {
+				t.Fatalf("GetListOfStarsTree() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GetListOfStarsTree() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestExportImportTreeJSON(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// build a small tree with updated masses and centers of mass
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: 150}, M: 1000}, 1)
+	UpdateTotalMass(db, 1)
+	UpdateCenterOfMass(db, 1)
+
+	exported, err := ExportTreeJSON(db, 1, true)
+	if err != nil {
+		t.Fatalf("ExportTreeJSON() error = %v", err)
+	}
+
+	// wipe the database and import the tree again
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	index, err := ImportTreeJSON(db, exported)
+	if err != nil {
+		t.Fatalf("ImportTreeJSON() error = %v", err)
+	}
+
+	reexported, err := ExportTreeJSON(db, index, true)
+	if err != nil {
+		t.Fatalf("ExportTreeJSON() error = %v", err)
+	}
+
+	// the ids (and everything else) must survive the round trip
+	var want, got TreeNodeJSON
+	if err := json.Unmarshal(exported, &want); err != nil {
+		t.Fatalf("unmarshal export: %v", err)
+	}
+	if err := json.Unmarshal(reexported, &got); err != nil {
+		t.Fatalf("unmarshal reexport: %v", err)
+	}
+	if want.NodeID == 0 {
+		t.Errorf("ExportTreeJSON() did not include the node ids")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoveBulkMotion(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 3, Y: 1}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, V: structs.Vec2{X: 1, Y: -2}, M: 3000}, 1)
+
+	if err := RemoveBulkMotion(db, 1); err != nil {
+		t.Fatalf("RemoveBulkMotion() error = %v", err)
+	}
+
+	momentum, err := GalaxyMomentum(db, 1)
+	if err != nil {
+		t.Fatalf("GalaxyMomentum() error = %v", err)
+	}
+	if math.Abs(momentum.X) > 1e-6 || math.Abs(momentum.Y) > 1e-6 {
+		t.Errorf("GalaxyMomentum() = %v, want ~(0, 0)", momentum)
+	}
+}
+
+// separation returns the distance between the two stars of the tree with the given index
+func separation(t *testing.T, index int64) float64 {
+	t.Helper()
+
+	stars := mustListOfStarsTree(t, index)
+	if len(stars) != 2 {
+		t.Fatalf("tree %d contains %d stars, want 2", index, len(stars))
+	}
+
+	return math.Hypot(stars[0].C.X-stars[1].C.X, stars[0].C.Y-stars[1].C.Y)
+}
+
+func TestTwoBodySeparation(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// two equal stars 100 apart, at rest or on a circular orbit around their common center of mass
+	G := 6.6726 * math.Pow(10, -11)
+	mass := 1e12
+	speed := math.Sqrt(G * mass / (2 * 100))
+	atRest := []structs.Star2D{
+		{C: structs.Vec2{X: 50, Y: 0}, M: mass},
+		{C: structs.Vec2{X: -50, Y: 0}, M: mass},
+	}
+	orbit := []structs.Star2D{
+		{C: structs.Vec2{X: 50, Y: 0}, V: structs.Vec2{X: 0, Y: speed}, M: mass},
+		{C: structs.Vec2{X: -50, Y: 0}, V: structs.Vec2{X: 0, Y: -speed}, M: mass},
+	}
+
+	// run returns the separation of the stars after the given number of steps of the given integrator
+	run := func(step func(*sql.DB, int64, float64, float64) (int64, error), stars []structs.Star2D, steps int, dt float64) float64 {
+		index := store.newTreeIndex(1000)
+		for _, star := range stars {
+			InsertStar(db, star, index)
+		}
+
+		for i := 0; i < steps; i++ {
+			var err error
+			index, err = step(db, index, 0.5, dt)
+			if err != nil {
+				t.Fatalf("step %d: %v", i, err)
+			}
+		}
+
+		return separation(t, index)
+	}
+
+	integrators := []struct {
+		name string
+		step func(*sql.DB, int64, float64, float64) (int64, error)
+	}{
+		{name: "StepSimulation", step: StepSimulation},
+		{name: "StepLeapfrogKDK", step: StepLeapfrogKDK},
+	}
+	for _, integrator := range integrators {
+		t.Run(integrator.name, func(t *testing.T) {
+			// stars at rest fall towards each other
+			if got := run(integrator.step, atRest, 5, 10); got >= 100 {
+				t.Errorf("separation of stars starting at rest = %v, want less than 100", got)
+			}
+
+			// stars on a circular orbit keep their separation (a third of the orbit is integrated)
+			if got := run(integrator.step, orbit, 40, 5); math.Abs(got-100) > 10 {
+				t.Errorf("separation of stars on a circular orbit = %v, want ~100", got)
+			}
+		})
+	}
+}
+
+func TestNthStarInTree(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	for _, x := range []float64{-300, -100, 100, 300, 450} {
+		InsertStar(db, structs.Star2D{C: structs.Vec2{X: x, Y: x / 2}, M: 1000}, 1)
+	}
+
+	starIDs, stars, err := store.treeStars(1)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	for n := range stars {
+		star, starID, err := NthStarInTree(db, 1, int64(n))
+		if err != nil {
+			t.Fatalf("NthStarInTree(%d) error = %v", n, err)
+		}
+		if starID != starIDs[n] || !reflect.DeepEqual(star, stars[n]) {
+			t.Errorf("NthStarInTree(%d) = (%v, %d), want (%v, %d)", n, star, starID, stars[n], starIDs[n])
+		}
+	}
+
+	if _, _, err := NthStarInTree(db, 1, int64(len(stars))); err == nil {
+		t.Errorf("NthStarInTree(%d) expected an error for a tree with %d stars", len(stars), len(stars))
+	}
+}
+
+func TestSubdivideTwice(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	rootNodeID := mustRootNodeID(t, 1)
+
+	countNodes := func() int64 {
+		var count int64
+		if err := db.QueryRow("SELECT count(*) FROM nodes").Scan(&count); err != nil {
+			t.Fatalf("count nodes: %v", err)
+		}
+		return count
+	}
+
+	if err := store.subdivide(context.Background(), rootNodeID); err != nil {
+		t.Fatalf("subdivide() error = %v", err)
+	}
+	children := store.getSubtreeIDs(rootNodeID)
+	nodeCount := countNodes()
+
+	// the children partition the box of the root
+	if err := ValidateSubdivision(db, rootNodeID); err != nil {
+		t.Errorf("ValidateSubdivision() after subdivide error = %v", err)
+	}
+
+	// the second call must neither create new nodes nor replace the existing children
+	if err := store.subdivide(context.Background(), rootNodeID); err != nil {
+		t.Fatalf("second subdivide() error = %v", err)
+	}
+	if got := countNodes(); got != nodeCount {
+		t.Errorf("second subdivide created %d new nodes", got-nodeCount)
+	}
+	if got := store.getSubtreeIDs(rootNodeID); got != children {
+		t.Errorf("second subdivide replaced the children %v with %v", children, got)
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+
+	// a node that isn't referenced by any subnode array and a star that isn't stored in any node
+	orphanNodeID := mustNewNode(t, 250, 250, 500, 1, 1)
+	orphanStarID := mustInsertIntoStars(t, structs.Star2D{C: structs.Vec2{X: 300, Y: 300}, M: 1000})
+
+	orphanNodes, orphanStars, err := FindOrphans(db)
+	if err != nil {
+		t.Fatalf("FindOrphans() error = %v", err)
+	}
+	if !reflect.DeepEqual(orphanNodes, []int64{orphanNodeID}) {
+		t.Errorf("FindOrphans() orphanNodes = %v, want [%d]", orphanNodes, orphanNodeID)
+	}
+	if !reflect.DeepEqual(orphanStars, []int64{orphanStarID}) {
+		t.Errorf("FindOrphans() orphanStars = %v, want [%d]", orphanStars, orphanStarID)
+	}
+}
+
+func TestGarbageCollect(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+	reachableStars := mustListOfStarsTree(t, 1)
+
+	// an unreachable node holding a star and an unreferenced star
+	orphanNodeID := mustNewNode(t, 250, 250, 500, 1, 1)
+	orphanStarID := mustInsertIntoStars(t, structs.Star2D{C: structs.Vec2{X: 300, Y: 300}, M: 1000})
+	if err := store.directInsert(context.Background(), orphanStarID, orphanNodeID); err != nil {
+		t.Fatalf("directInsert() error = %v", err)
+	}
+	mustInsertIntoStars(t, structs.Star2D{C: structs.Vec2{X: 400, Y: 400}, M: 1000})
+
+	// a dry run only counts
+	removedNodes, removedStars, err := GarbageCollect(db, true)
+	if err != nil {
+		t.Fatalf("GarbageCollect(dryRun) error = %v", err)
+	}
+	if removedNodes != 1 || removedStars != 2 {
+		t.Errorf("GarbageCollect(dryRun) = (%d, %d), want (1, 2)", removedNodes, removedStars)
+	}
+	if orphanNodes, _, _ := FindOrphans(db); len(orphanNodes) != 1 {
+		t.Errorf("GarbageCollect(dryRun) removed the orphaned node")
+	}
+
+	removedNodes, removedStars, err = GarbageCollect(db, false)
+	if err != nil {
+		t.Fatalf("GarbageCollect() error = %v", err)
+	}
+	if removedNodes != 1 || removedStars != 2 {
+		t.Errorf("GarbageCollect() = (%d, %d), want (1, 2)", removedNodes, removedStars)
+	}
+
+	orphanNodes, orphanStars, err := FindOrphans(db)
+	if err != nil {
+		t.Fatalf("FindOrphans() error = %v", err)
+	}
+	if len(orphanNodes) != 0 || len(orphanStars) != 0 {
+		t.Errorf("FindOrphans() after GarbageCollect() = (%v, %v), want no orphans", orphanNodes, orphanStars)
+	}
+	if got := mustListOfStarsTree(t, 1); !reflect.DeepEqual(got, reachableStars) {
+		t.Errorf("GetListOfStarsTree() after GarbageCollect() = %v, want %v", got, reachableStars)
+	}
+}
+
+func TestStarsInTreeAtTimestep(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	first := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}
+	second := structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 2000}
+	InsertStar(db, first, 1)
+	InsertStar(db, second, 1)
+
+	// a second galaxy whose stars must never show up in the timesteps of the first one
+	other, _, err := NewTreeAt(db, structs.Vec2{}, 1000)
+	if err != nil {
+		t.Fatalf("NewTreeAt() error = %v", err)
+	}
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 200, Y: -300}, M: 3000}, other)
+
+	next, err := StepSimulation(db, 1, 0.5, 10)
+	if err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+
+	got, err := StarsInTreeAtTimestep(db, 1, 1)
+	if err != nil {
+		t.Fatalf("StarsInTreeAtTimestep() error = %v", err)
+	}
+	if want := []structs.Star2D{first, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("StarsInTreeAtTimestep(1, 1) = %v, want %v", got, want)
+	}
+
+	// the stars of the next timestep are the moved stars of the galaxy, in the same order
+	got, err = StarsInTreeAtTimestep(db, 1, next)
+	if err != nil {
+		t.Fatalf("StarsInTreeAtTimestep() error = %v", err)
+	}
+	if len(got) != 2 || got[0].M != first.M || got[1].M != second.M {
+		t.Fatalf("StarsInTreeAtTimestep(1, %d) = %v, want the stepped %v and %v", next, got, first, second)
+	}
+	if got[0] == first || got[1] == second {
+		t.Errorf("StarsInTreeAtTimestep(1, %d) = %v, want the stars moved by the step", next, got)
+	}
+
+	tests := []struct {
+		name            string
+		index, timestep int64
+	}{
+		{name: "other galaxy", index: 1, timestep: other},
+		{name: "other galaxy at the next timestep", index: other, timestep: next},
+		{name: "missing timestep", index: 1, timestep: next + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StarsInTreeAtTimestep(db, tt.index, tt.timestep)
+			if err != nil {
+				t.Fatalf("StarsInTreeAtTimestep() error = %v", err)
+			}
+			if got != nil {
+				t.Errorf("StarsInTreeAtTimestep(%d, %d) = %v, want no stars", tt.index, tt.timestep, got)
+			}
+		})
+	}
+}
+
+func TestMinActingMass(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	tests := []struct {
+		name          string
+		minActingMass float64
+		lightMass     float64
+	}{
+		{name: "massless test particle", minActingMass: 0, lightMass: 0},
+		{name: "light star below the threshold", minActingMass: 10, lightMass: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStoreWithOptions(db, Options{MinActingMass: tt.minActingMass})
+
+			DeleteAllStars(db)
+			DeleteAllNodes(db)
+			NewTree(db, 1000)
+			InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1e12}, 1)
+			InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: tt.lightMass}, 1)
+			UpdateTotalMass(db, 1)
+			UpdateCenterOfMass(db, 1)
+
+			starIDs, stars, err := store.treeStars(1)
+			if err != nil {
+				t.Fatalf("treeStars() error = %v", err)
+			}
+
+			// only the light star may be accelerated
+			accelerations, _, err := store.calcTreeAccelerations(context.Background(), 1, starIDs, stars, 0.5)
+			if err != nil {
+				t.Fatalf("calcTreeAccelerations() error = %v", err)
+			}
+			for i, star := range stars {
+				accelerated := accelerations[i] != (structs.Vec2{})
+				if isLight := star.M == tt.lightMass; accelerated != isLight {
+					t.Errorf("star %v accelerated = %v, want %v", star, accelerated, isLight)
+				}
+			}
+		})
+	}
+}
+
+func TestStarSoftening(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	s1 := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}
+	s2 := structs.Star2D{C: structs.Vec2{X: 110, Y: 100}, M: 1000}
+	id1 := InsertStar(db, s1, 1)
+	id2 := InsertStar(db, s2, 1)
+
+	// forceWith returns the magnitude of the force in between both stars using the given softening lengths
+	forceWith := func(eps float64) float64 {
+		for _, starID := range []int64{id1, id2} {
+			if err := SetStarSoftening(db, starID, eps); err != nil {
+				t.Fatalf("SetStarSoftening() error = %v", err)
+			}
+		}
+		force := calcForce(s1, s2, combinedSoftening(store.getStarSoftening(id1), store.getStarSoftening(id2)))
+		return math.Hypot(force.X, force.Y)
+	}
+
+	small := forceWith(0.1)
+	large := forceWith(50)
+	if large >= small {
+		t.Errorf("force with large eps = %v, want less than the force with small eps %v", large, small)
+	}
+}
+
+func TestStreamStarsJSON(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	for _, x := range []float64{-300, -100, 100, 300} {
+		InsertStar(db, structs.Star2D{C: structs.Vec2{X: x, Y: -x}, V: structs.Vec2{X: 1, Y: 2}, M: 1000}, 1)
+	}
+
+	var buf bytes.Buffer
+	if err := StreamStarsJSON(db, 1, &buf); err != nil {
+		t.Fatalf("StreamStarsJSON() error = %v", err)
+	}
+
+	var got []structs.Star2D
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding the streamed stars: %v\n%s", err, buf.String())
+	}
+
+	_, want, err := store.treeStars(1)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamStarsJSON() = %v, want %v", got, want)
+	}
+}
+
+// boundingBox returns the lower left and upper right corner of the box enclosing all the given stars
+func boundingBox(stars []structs.Star2D) (structs.Vec2, structs.Vec2) {
+	min := structs.Vec2{X: math.Inf(1), Y: math.Inf(1)}
+	max := structs.Vec2{X: math.Inf(-1), Y: math.Inf(-1)}
+	for _, star := range stars {
+		min.X, min.Y = math.Min(min.X, star.C.X), math.Min(min.Y, star.C.Y)
+		max.X, max.Y = math.Max(max.X, star.C.X), math.Max(max.Y, star.C.Y)
+	}
+	return min, max
+}
+
+func TestScaleGalaxy(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 50}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -150, Y: -200}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 200, Y: -100}, M: 1000}, 1)
+
+	min, max := boundingBox(mustListOfStarsTree(t, 1))
+
+	if err := ScaleGalaxy(db, 1, 2, 1, 1); err != nil {
+		t.Fatalf("ScaleGalaxy() error = %v", err)
+	}
+
+	scaledMin, scaledMax := boundingBox(mustListOfStarsTree(t, 1))
+	if scaledMin != min.Multiply(2) || scaledMax != max.Multiply(2) {
+		t.Errorf("bounding box after ScaleGalaxy() = (%v, %v), want (%v, %v)", scaledMin, scaledMax, min.Multiply(2), max.Multiply(2))
+	}
+	if got := store.getBoxWidth(mustRootNodeID(t, 1)); got != 2000 {
+		t.Errorf("root box width after ScaleGalaxy() = %v, want 2000", got)
+	}
+}
+
+func TestTreeMeta(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// a database predating the tree_meta table gets it when the first tree is created
+	if _, err := db.Exec("DROP TABLE IF EXISTS tree_meta"); err != nil {
+		t.Fatalf("dropping the tree_meta table: %v", err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	index := store.newTreeIndex(1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, index)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, index)
+
+	if err := SetTreeName(db, index, "Andromeda"); err != nil {
+		t.Fatalf("SetTreeName() error = %v", err)
+	}
+
+	meta, err := GetTreeMeta(db, index)
+	if err != nil {
+		t.Fatalf("GetTreeMeta() error = %v", err)
+	}
+	if meta.Index != index || meta.Name != "Andromeda" || meta.StarCount != 2 {
+		t.Errorf("GetTreeMeta() = %+v, want index %d, name Andromeda and 2 stars", meta, index)
+	}
+	if meta.CreatedAt.Before(start) {
+		t.Errorf("GetTreeMeta() created at %v, want after %v", meta.CreatedAt, start)
+	}
+}
+
+func TestRenderParticles(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a tight cluster of stars and two distant stars
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 301, Y: 301}, M: 1000},
+		{C: structs.Vec2{X: 303, Y: 302}, M: 1000},
+		{C: structs.Vec2{X: 302, Y: 304}, M: 1000},
+		{C: structs.Vec2{X: 304, Y: 303}, M: 1000},
+		{C: structs.Vec2{X: 305, Y: 305}, M: 1000},
+		{C: structs.Vec2{X: -300, Y: 200}, M: 1000},
+		{C: structs.Vec2{X: -200, Y: -300}, M: 1000},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	particles, err := RenderParticles(db, index, 0.05)
+	if err != nil {
+		t.Fatalf("RenderParticles() error = %v", err)
+	}
+	if len(particles) >= len(stars) {
+		t.Errorf("RenderParticles() returned %d particles, want less than %d", len(particles), len(stars))
+	}
+
+	// the particles must still carry the whole mass of the galaxy
+	var totalMass float64
+	for _, particle := range particles {
+		totalMass += particle.M
+	}
+	if totalMass != 7000 {
+		t.Errorf("total mass of the particles = %v, want 7000", totalMass)
+	}
+}
+
+func TestMergeGalaxies(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	indexA := store.newTreeIndex(1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, indexA)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, indexA)
+
+	indexB := store.newTreeIndex(1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 50, Y: -50}, V: structs.Vec2{X: 1, Y: 1}, M: 2000}, indexB)
+
+	offset := structs.Vec2{X: 800, Y: 0}
+	boost := structs.Vec2{X: -5, Y: 0}
+	index, err := MergeGalaxies(db, indexA, indexB, offset, boost)
+	if err != nil {
+		t.Fatalf("MergeGalaxies() error = %v", err)
+	}
+
+	_, stars, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if len(stars) != 3 {
+		t.Fatalf("MergeGalaxies() created a tree with %d stars, want 3", len(stars))
+	}
+
+	// the star of galaxy B is inserted last
+	want := structs.Star2D{C: structs.Vec2{X: 850, Y: -50}, V: structs.Vec2{X: -4, Y: 1}, M: 2000}
+	if stars[2] != want {
+		t.Errorf("merged star of galaxy B = %v, want %v", stars[2], want)
+	}
+}
+
+func TestSetStarMass(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	_, starID, err := NthStarInTree(db, index, 0)
+	if err != nil {
+		t.Fatalf("NthStarInTree() error = %v", err)
+	}
+
+	if err := SetStarMass(db, starID, 3000); err != nil {
+		t.Fatalf("SetStarMass() error = %v", err)
+	}
+
+	mass, err := StarMass(db, starID)
+	if err != nil {
+		t.Fatalf("StarMass() error = %v", err)
+	}
+	if mass != 3000 {
+		t.Errorf("StarMass() = %v, want 3000", mass)
+	}
+
+	rootNodeID := mustRootNodeID(t, index)
+	if got := store.getNodeTotalMass(rootNodeID); got != 4000 {
+		t.Errorf("getNodeTotalMass() = %v, want 4000", got)
+	}
+
+	// a full recompute must agree
+	UpdateTotalMass(db, index)
+	if got := store.getNodeTotalMass(rootNodeID); got != 4000 {
+		t.Errorf("getNodeTotalMass() after UpdateTotalMass() = %v, want 4000", got)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	star := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1e10}
+	index, err := BuildFixtureTree(db, []structs.Star2D{star, {C: structs.Vec2{X: -100, Y: 100}, M: 1e10}})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	store := NewStoreWithOptions(db, Options{QueryTimeout: 100 * time.Millisecond})
+	if _, err := store.CalcAllForces(star, index, 0); err != nil {
+		t.Fatalf("CalcAllForces() error = %v", err)
+	}
+
+	// a transaction locking the stars table makes the force calculation hang while reading the stars of the leaves,
+	// the whole operation is aborted once the timeout is reached
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("LOCK TABLE stars IN ACCESS EXCLUSIVE MODE"); err != nil {
+		t.Fatalf("locking the stars table: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := store.CalcAllForces(star, index, 0); err != context.DeadlineExceeded {
+		t.Errorf("CalcAllForces() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CalcAllForces() returned after %v, want it to be aborted after the timeout", elapsed)
+	}
+
+	// the same holds for the operations calculating the forces of several stars
+	if _, err := store.CalcForcesForTree(index, 0); err != context.DeadlineExceeded {
+		t.Errorf("CalcForcesForTree() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// and for the operations walking down the tree and reading the stars of the leaves
+	if _, err := store.ExportTreeJSON(index, false); err != context.DeadlineExceeded {
+		t.Errorf("ExportTreeJSON() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.RenderParticles(index, 0); err != context.DeadlineExceeded {
+		t.Errorf("RenderParticles() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.EscapeVelocity(index, structs.Vec2{}, 0); err != context.DeadlineExceeded {
+		t.Errorf("EscapeVelocity() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestComputeSubtreeMass(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 2000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 4000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootNodeID := mustRootNodeID(t, index)
+
+	// freshly updated, the cached mass matches
+	mass, err := ComputeSubtreeMass(db, rootNodeID)
+	if err != nil {
+		t.Fatalf("ComputeSubtreeMass() error = %v", err)
+	}
+	if cached := store.getNodeTotalMass(rootNodeID); mass != 7000 || cached != mass {
+		t.Errorf("ComputeSubtreeMass() = %v, cached = %v, want both 7000", mass, cached)
+	}
+
+	// changing a mass behind the back of the tree makes the cache stale
+	if _, err := db.Exec("UPDATE stars SET m=m+500"); err != nil {
+		t.Fatalf("update masses: %v", err)
+	}
+	mass, err = ComputeSubtreeMass(db, rootNodeID)
+	if err != nil {
+		t.Fatalf("ComputeSubtreeMass() error = %v", err)
+	}
+	if cached := store.getNodeTotalMass(rootNodeID); mass != 8500 || cached == mass {
+		t.Errorf("ComputeSubtreeMass() = %v, cached = %v, want 8500 and a stale cache", mass, cached)
+	}
+}
+
+func TestInsertStarTraced(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+
+	// the second star forces the root (and possibly more nodes) to be subdivided
+	starID, path, err := InsertStarTraced(db, structs.Star2D{C: structs.Vec2{X: 120, Y: 130}, M: 1000}, 1)
+	if err != nil {
+		t.Fatalf("InsertStarTraced() error = %v", err)
+	}
+	if len(path) < 2 {
+		t.Fatalf("InsertStarTraced() path = %v, want at least the root and a leaf", path)
+	}
+	if path[0] != mustRootNodeID(t, 1) {
+		t.Errorf("InsertStarTraced() path starts at %d, want the root %d", path[0], mustRootNodeID(t, 1))
+	}
+	if leaf := path[len(path)-1]; store.getStarID(leaf) != starID {
+		t.Errorf("InsertStarTraced() path ends at %d holding the star %d, want the star %d", leaf, store.getStarID(leaf), starID)
+	}
+}
+
+func TestSoftDeleteStar(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	kept := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	deletedStar := structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}
+	deleted := InsertStar(db, deletedStar, 1)
+
+	if err := SoftDeleteStar(db, deleted); err != nil {
+		t.Fatalf("SoftDeleteStar() error = %v", err)
+	}
+
+	if got := GetListOfStarIDs(db); !reflect.DeepEqual(got, []int64{kept}) {
+		t.Errorf("GetListOfStarIDs() = %v, want [%d]", got, kept)
+	}
+	if got := GetStar(db, deleted); got != deletedStar {
+		t.Errorf("GetStar() = %v, want %v", got, deletedStar)
+	}
+
+	withDeleted := NewStoreWithOptions(db, Options{IncludeDeleted: true})
+	if got := withDeleted.GetListOfStarIDs(); len(got) != 2 {
+		t.Errorf("GetListOfStarIDs() including deleted stars = %v, want 2 stars", got)
+	}
+}
+
+func TestDeleteStar(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	first := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	second := InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+	rootID := mustRootNodeID(t, 1)
+
+	// the sibling of the deleted star keeps the root subdivided
+	if err := DeleteStar(db, first); err != nil {
+		t.Fatalf("DeleteStar() error = %v", err)
+	}
+	if got := GetListOfStarIDs(db); !reflect.DeepEqual(got, []int64{second}) {
+		t.Errorf("GetListOfStarIDs() = %v, want [%d]", got, second)
+	}
+	if children, err := HasChildren(db, rootID); err != nil || !children {
+		t.Errorf("HasChildren() = %v, %v, want the root to keep its children", children, err)
+	}
+	if _, err := CalcAllForces(db, structs.Star2D{C: structs.Vec2{X: 200, Y: 200}, M: 1000}, 1, 0); err != nil {
+		t.Errorf("CalcAllForces() error = %v", err)
+	}
+
+	// deleting the last star collapses the empty children
+	if err := DeleteStar(db, second); err != nil {
+		t.Fatalf("DeleteStar() error = %v", err)
+	}
+	if children, err := HasChildren(db, rootID); err != nil || children {
+		t.Errorf("HasChildren() = %v, %v, want the root to be a leaf again", children, err)
+	}
+	var nodes int64
+	if err := db.QueryRow("SELECT count(*) FROM nodes").Scan(&nodes); err != nil {
+		t.Fatalf("counting the nodes: %v", err)
+	}
+	if nodes != 1 {
+		t.Errorf("%d nodes are left, want only the root", nodes)
+	}
+
+	if err := DeleteStar(db, second); err != ErrStarNotFound {
+		t.Errorf("DeleteStar() of a deleted star error = %v, want %v", err, ErrStarNotFound)
+	}
+}
+
+func TestCalcForcesForTree(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1e10},
+		{C: structs.Vec2{X: -150, Y: 100}, M: 2e10},
+		{C: structs.Vec2{X: -100, Y: -200}, M: 4e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	forces, err := CalcForcesForTree(db, index, 0.5)
+	if err != nil {
+		t.Fatalf("CalcForcesForTree() error = %v", err)
+	}
+
+	starIDs, stars, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if len(forces) != len(stars) {
+		t.Fatalf("CalcForcesForTree() returned %d forces, want %d", len(forces), len(stars))
+	}
+	for i, star := range stars {
+		want, err := CalcAllForces(db, star, index, 0.5)
+		if err != nil {
+			t.Fatalf("CalcAllForces() error = %v", err)
+		}
+		if forces[starIDs[i]] != want {
+			t.Errorf("force on star %d = %v, want %v", starIDs[i], forces[starIDs[i]], want)
+		}
+	}
+}
+
+func TestIsSameStar(t *testing.T) {
+	star := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 1, Y: 1}, M: 1e10}
+	other := structs.Star2D{C: structs.Vec2{X: -100, Y: 100}, M: 1e10}
+
+	tests := []struct {
+		name     string
+		id1, id2 int64
+		s1, s2   structs.Star2D
+		want     bool
+	}{
+		{name: "same id", id1: 1, id2: 1, s1: star, s2: star, want: true},
+		{name: "identical stars with different ids", id1: 1, id2: 2, s1: star, s2: star, want: false},
+		{name: "unknown id, equal values", id1: 1, id2: 0, s1: star, s2: star, want: true},
+		{name: "unknown id, different values", id1: 1, id2: 0, s1: star, s2: other, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSameStar(tt.id1, tt.s1, tt.id2, tt.s2); got != tt.want {
+				t.Errorf("isSameStar() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalcAllForcesByID(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// two identical stars that only differ by their ids and a third star. Inserting coincident stars would subdivide
+	// forever, so the tree is imported: the twins are stored at the common corner of two quadrants
+	twin := structs.Star2D{C: structs.Vec2{X: 0, Y: 0}, V: structs.Vec2{X: 5, Y: 5}, M: 1e10}
+	other := structs.Star2D{C: structs.Vec2{X: 300, Y: 200}, M: 1e10}
+	leaf := func(center structs.Vec2, star *structs.Star2D) TreeNodeJSON {
+		node := TreeNodeJSON{BoxCenter: center, BoxWidth: 500, Depth: 1}
+		if star != nil {
+			node.Star = &TreeStarJSON{Star: *star}
+		}
+		return node
+	}
+	tree, err := json.Marshal(TreeNodeJSON{BoxWidth: 1000, Subnodes: []TreeNodeJSON{
+		leaf(structs.Vec2{X: 250, Y: 250}, &other),
+		leaf(structs.Vec2{X: 250, Y: -250}, nil),
+		leaf(structs.Vec2{X: -250, Y: 250}, &twin),
+		leaf(structs.Vec2{X: -250, Y: -250}, &twin),
+	}})
+	if err != nil {
+		t.Fatalf("marshalling the tree: %v", err)
+	}
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	index, err := ImportTreeJSON(db, tree)
+	if err != nil {
+		t.Fatalf("ImportTreeJSON() error = %v", err)
+	}
+	UpdateTotalMass(db, index)
+	UpdateCenterOfMass(db, index)
+
+	starIDs, stars, err := NewStore(db).treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	var twinIDs []int64
+	for i, star := range stars {
+		if star == twin {
+			twinIDs = append(twinIDs, starIDs[i])
+		}
+	}
+	if len(twinIDs) != 2 || twinIDs[0] == twinIDs[1] {
+		t.Fatalf("twin ids = %v, want two distinct ids", twinIDs)
+	}
+
+	// the twins share a position, so their force is softened
+	eps := 10.0
+	for _, id := range twinIDs {
+		if err := SetStarSoftening(db, id, eps); err != nil {
+			t.Fatalf("SetStarSoftening() error = %v", err)
+		}
+	}
+
+	// the softened force between the coincident twins vanishes, only the third star is pulling
+	want := calcForce(other, twin, eps)
+	for _, id := range twinIDs {
+		force, err := CalcAllForcesByID(db, id, index, 0)
+		if err != nil {
+			t.Fatalf("CalcAllForcesByID() error = %v", err)
+		}
+		if math.IsNaN(force.X) || math.IsNaN(force.Y) || force == (structs.Vec2{}) {
+			t.Fatalf("force on twin %d = %v, want a finite non-zero force", id, force)
+		}
+		if math.Abs(force.X-want.X) > 1e-9*math.Abs(want.X) || math.Abs(force.Y-want.Y) > 1e-9*math.Abs(want.Y) {
+			t.Errorf("force on twin %d = %v, want %v", id, force, want)
+		}
+	}
+
+	// each twin still feels the other: it is bound by the softened potential of its twin, which is deeper than the
+	// potential of the third star alone
+	G := 6.6726 * math.Pow(10, -11)
+	escapeAlone := math.Sqrt(2 * G * other.M / math.Sqrt(300*300+200*200))
+	speed := 2 * escapeAlone
+	for _, id := range twinIDs {
+		if _, err := db.Exec("UPDATE stars SET vx=$1, vy=0 WHERE star_id=$2", speed, id); err != nil {
+			t.Fatalf("updating the velocity: %v", err)
+		}
+	}
+	unbound, err := UnboundStars(db, index, 0)
+	if err != nil {
+		t.Fatalf("UnboundStars() error = %v", err)
+	}
+	for _, id := range unbound {
+		if id == twinIDs[0] || id == twinIDs[1] {
+			t.Errorf("UnboundStars() = %v, want the twins %v to be bound by each other", unbound, twinIDs)
+		}
+	}
+}
+
+func TestCalcAllForcesContext(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	a := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1e10}
+	b := structs.Star2D{C: structs.Vec2{X: -100, Y: 100}, M: 1e10}
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, a, 1)
+	InsertStar(db, b, 1)
+	UpdateTotalMass(db, 1)
+	UpdateCenterOfMass(db, 1)
+
+	// the forces match the ones calculated without a context as long as the context isn't done
+	force, err := CalcAllForcesContext(context.Background(), db, a, 1, 0)
+	if err != nil {
+		t.Fatalf("CalcAllForcesContext() error = %v", err)
+	}
+	want, err := CalcAllForces(db, a, 1, 0)
+	if err != nil {
+		t.Fatalf("CalcAllForces() error = %v", err)
+	}
+	if force != want {
+		t.Errorf("CalcAllForcesContext() = %v, want %v", force, want)
+	}
+
+	// a cancelled context stops the calculation, the error of the context is returned as is
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CalcAllForcesContext(ctx, db, a, 1, 0); err != context.Canceled {
+		t.Errorf("CalcAllForcesContext() error = %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if _, err := CalcAllForcesContext(ctx, db, a, 1, 0); err != context.DeadlineExceeded {
+		t.Errorf("CalcAllForcesContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNodesOpenedFor(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// a pair of stars in the north-east and a pair of stars in the south-west quadrant of the root
+	star := structs.Star2D{C: structs.Vec2{X: 400, Y: 400}, M: 1e10}
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		star,
+		{C: structs.Vec2{X: 300, Y: 300}, M: 1e10},
+		{C: structs.Vec2{X: -400, Y: -400}, M: 1e10},
+		{C: structs.Vec2{X: -300, Y: -300}, M: 1e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	root, err := store.getNode(mustRootNodeID(t, index))
+	if err != nil {
+		t.Fatalf("getNode() error = %v", err)
+	}
+
+	var nearby, distant int64
+	for _, subnodeID := range root.Subnodes {
+		subnode, err := store.getNode(subnodeID)
+		if err != nil {
+			t.Fatalf("getNode() error = %v", err)
+		}
+
+		switch {
+		case subnode.BoxCenter.X > 0 && subnode.BoxCenter.Y > 0:
+			nearby = subnodeID
+		case subnode.BoxCenter.X < 0 && subnode.BoxCenter.Y < 0:
+			distant = subnodeID
+		}
+	}
+
+	exact := NewStoreWithOptions(db, Options{ForceCalculation: ForceExact})
+
+	tests := []struct {
+		name   string
+		store  *Store
+		theta  float64
+		nodeID int64
+		want   bool
+	}{
+		{name: "root", store: store, theta: 0.5, nodeID: root.ID, want: true},
+		{name: "nearby quadrant", store: store, theta: 0.5, nodeID: nearby, want: true},
+		{name: "distant quadrant", store: store, theta: 0.5, nodeID: distant, want: false},
+		// nodes containing the star are opened no matter how large theta is
+		{name: "root with a large theta", store: store, theta: 100, nodeID: root.ID, want: true},
+		{name: "nearby quadrant with a large theta", store: store, theta: 100, nodeID: nearby, want: true},
+		{name: "distant quadrant with a large theta", store: store, theta: 100, nodeID: distant, want: false},
+		// the exact mode opens every node
+		{name: "distant quadrant in the exact mode", store: exact, theta: 0.5, nodeID: distant, want: true},
+	}
+	for _, tt := range tests {
+		opened, err := tt.store.NodesOpenedFor(index, star, tt.theta)
+		if err != nil {
+			t.Fatalf("NodesOpenedFor() error = %v", err)
+		}
+
+		got := false
+		for _, nodeID := range opened {
+			got = got || nodeID == tt.nodeID
+		}
+		if got != tt.want {
+			t.Errorf("%s (node %d) opened = %v, want %v", tt.name, tt.nodeID, got, tt.want)
+		}
+	}
+}
+
+func TestStarMasses(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	want := make(map[int64]float64)
+	for i, m := range []float64{1e10, 2e10, 3e10} {
+		star := structs.Star2D{C: structs.Vec2{X: float64(100 * i), Y: -100}, M: m}
+		want[InsertStar(db, star, 1)] = m
+	}
+
+	ids := []int64{-1}
+	for id := range want {
+		ids = append(ids, id)
+	}
+
+	got, err := StarMasses(db, ids)
+	if err != nil {
+		t.Fatalf("StarMasses() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StarMasses() = %v, want %v", got, want)
+	}
+}
+
+func TestTimestepPartitions(t *testing.T) {
+	// the partitioned nodes table is created in its own schema, so the nodes table used by the other tests stays
+	// untouched
+	publicDB := ConnectToDB(DBNAME)
+	if _, err := publicDB.Exec("DROP SCHEMA IF EXISTS partition_test CASCADE; CREATE SCHEMA partition_test"); err != nil {
+		t.Fatalf("creating the schema: %v", err)
+	}
+	defer publicDB.Exec("DROP SCHEMA IF EXISTS partition_test CASCADE")
+
+	db = dbConnect(fmt.Sprintf("user=%s dbname=%s sslmode=%s search_path=partition_test,public", DBUSER, DBNAME, DBSSLMODE))
+	InitPartitionedNodesTable(db)
+
+	store := NewStoreWithOptions(db, Options{PartitionNodes: true})
+
+	store.DeleteAllStars()
+	first := store.newTreeIndex(1000)
+	second := store.newTreeIndex(1000)
+	store.InsertStar(structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1e10}, second)
+	store.InsertStar(structs.Star2D{C: structs.Vec2{X: -100, Y: 100}, M: 1e10}, second)
+
+	nodeIDs, err := TimestepNodeIDs(db, first)
+	if err != nil {
+		t.Fatalf("TimestepNodeIDs() error = %v", err)
+	}
+	if want := []int64{mustRootNodeID(t, first)}; !reflect.DeepEqual(nodeIDs, want) {
+		t.Errorf("TimestepNodeIDs() = %v, want %v", nodeIDs, want)
+	}
+
+	rows, err := db.Query("EXPLAIN "+timestepNodeIDsQuery, first)
+	if err != nil {
+		t.Fatalf("EXPLAIN error = %v", err)
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatalf("scan error = %v", err)
+		}
+		plan.WriteString(line + "\n")
+	}
+
+	if !strings.Contains(plan.String(), fmt.Sprintf("nodes_timestep_%d", first)) ||
+		strings.Contains(plan.String(), fmt.Sprintf("nodes_timestep_%d", second)) {
+		t.Errorf("the query doesn't only scan the partition of timestep %d:\n%s", first, plan.String())
+	}
+}
+
+func TestStarCountsByTimestep(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// a tree preceding the simulation and three timesteps losing a star each
+	for _, count := range []int{1, 3, 2, 1} {
+		index := store.newTreeIndex(1000)
+		for i := 0; i < count; i++ {
+			InsertStar(db, structs.Star2D{C: structs.Vec2{X: float64(100 * i), Y: 100}, M: 1e10}, index)
+		}
+	}
+
+	got, err := StarCountsByTimestep(db, 2)
+	if err != nil {
+		t.Fatalf("StarCountsByTimestep() error = %v", err)
+	}
+	if want := map[int64]int64{2: 3, 3: 2, 4: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("StarCountsByTimestep() = %v, want %v", got, want)
+	}
+}
+
+func TestRepairSubnodeArrays(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	tests := []struct {
+		name    string
+		subnode interface{}
+		want    [4]int64
+	}{
+		{name: "NULL", subnode: nil, want: [4]int64{0, 0, 0, 0}},
+		{name: "too short", subnode: "{5}", want: [4]int64{5, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DeleteAllStars(db)
+			DeleteAllNodes(db)
+			NewTree(db, 1000)
+			rootID := mustRootNodeID(t, 1)
+
+			if _, err := db.Exec("UPDATE nodes SET subnode=$1 WHERE node_id=$2", tt.subnode, rootID); err != nil {
+				t.Fatalf("setting the subnode array: %v", err)
+			}
+
+			// malformed arrays are scanned using their defaults
+			if got := store.getSubtreeIDs(rootID); got != tt.want {
+				t.Errorf("getSubtreeIDs() = %v, want %v", got, tt.want)
+			}
+
+			repaired, err := RepairSubnodeArrays(db)
+			if err != nil {
+				t.Fatalf("RepairSubnodeArrays() error = %v", err)
+			}
+			if repaired != 1 {
+				t.Errorf("RepairSubnodeArrays() = %d, want 1", repaired)
+			}
+
+			var length int64
+			if err := db.QueryRow("SELECT array_length(subnode, 1) FROM nodes WHERE node_id=$1", rootID).Scan(&length); err != nil {
+				t.Fatalf("querying the subnode array: %v", err)
+			}
+			if length != 4 || store.getSubtreeIDs(rootID) != tt.want {
+				t.Errorf("repaired subnode array = %v (length %d), want %v", store.getSubtreeIDs(rootID), length, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteStarsCSVGzip(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1e10},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 2e10},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 3e10},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteStarsCSVGzip(db, index, &buf); err != nil {
+		t.Fatalf("WriteStarsCSVGzip() error = %v", err)
+	}
+
+	reader, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV: %v", err)
+	}
+
+	if len(records) != len(stars) {
+		t.Errorf("got %d rows, want %d", len(records), len(stars))
+	}
+}
+
+func TestNewTreeAt(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// the tree covers 0 <= x, y <= 1000, the star at (900, 900) lies outside of a tree centered at the origin
+	index, rootID, err := NewTreeAt(db, structs.Vec2{X: 500, Y: 500}, 1000)
+	if err != nil {
+		t.Fatalf("NewTreeAt() error = %v", err)
+	}
+	if got := mustRootNodeID(t, index); got != rootID {
+		t.Errorf("NewTreeAt() root node id = %d, want %d", rootID, got)
+	}
+
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1e10}, index)
+	star := structs.Star2D{C: structs.Vec2{X: 900, Y: 900}, M: 1e10}
+	_, path, err := InsertStarTraced(db, star, index)
+	if err != nil {
+		t.Fatalf("InsertStarTraced() error = %v", err)
+	}
+
+	// the node the star ended up in has to contain the star
+	n, err := store.getNode(path[len(path)-1])
+	if err != nil {
+		t.Fatalf("getNode() error = %v", err)
+	}
+	if math.Abs(star.C.X-n.BoxCenter.X) > n.BoxWidth/2 || math.Abs(star.C.Y-n.BoxCenter.Y) > n.BoxWidth/2 {
+		t.Errorf("star %v inserted into node %d centered at %v with width %f", star, n.ID, n.BoxCenter, n.BoxWidth)
+	}
+}
+
+func TestNewTreeAtInTx(t *testing.T) {
+	// a pool of a single connection fails (times out) if the tree is created on a second connection
+	single := ConnectToDB(DBNAME)
+	defer single.Close()
+	single.SetMaxOpenConns(1)
+
+	DeleteAllStars(single)
+	DeleteAllNodes(single)
+
+	tx, err := single.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+
+	txStore := NewStoreWithOptions(single, Options{QueryTimeout: 5 * time.Second}).withTx(tx)
+	index, rootID, err := txStore.NewTreeAt(structs.Vec2{}, 1000)
+	if err != nil {
+		t.Fatalf("NewTreeAt() in a transaction error = %v", err)
+	}
+	if got, err := txStore.RootNodeID(index); err != nil || got != rootID {
+		t.Errorf("RootNodeID() in the transaction = %d, %v, want %d", got, err, rootID)
+	}
+
+	// the tree is part of the transaction and vanishes with it
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if _, err := RootNodeID(single, index); err != ErrTreeNotFound {
+		t.Errorf("RootNodeID() after the rollback error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestInsertStarNewTree(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{{C: structs.Vec2{X: 100, Y: 100}, M: 1000}})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// there is no tree with the index index+5, so the star ends up in a new tree with the next free index
+	starID := InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: 100}, M: 1000}, index+5)
+	starIDs, _, err := store.treeStars(index + 1)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if !reflect.DeepEqual(starIDs, []int64{starID}) {
+		t.Errorf("stars of the new tree %d = %v, want %v", index+1, starIDs, []int64{starID})
+	}
+}
+
+func TestHeaviestStars(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 2},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 3},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	records, err := HeaviestStars(db, index, 2)
+	if err != nil {
+		t.Fatalf("HeaviestStars() error = %v", err)
+	}
+
+	var masses []float64
+	for _, r := range records {
+		masses = append(masses, r.Star.M)
+		if got := GetStar(db, r.ID); got != r.Star {
+			t.Errorf("star %d = %v, want %v", r.ID, r.Star, got)
+		}
+	}
+	if want := []float64{3, 2}; !reflect.DeepEqual(masses, want) {
+		t.Errorf("HeaviestStars() masses = %v, want %v", masses, want)
+	}
+
+	// deleted stars are skipped, even if a node still references them
+	if _, err := db.Exec("UPDATE stars SET deleted=TRUE WHERE m=3"); err != nil {
+		t.Fatalf("deleting the star: %v", err)
+	}
+	records, err = HeaviestStars(db, index, 2)
+	if err != nil {
+		t.Fatalf("HeaviestStars() error = %v", err)
+	}
+	masses = nil
+	for _, r := range records {
+		masses = append(masses, r.Star.M)
+	}
+	if want := []float64{2, 1}; !reflect.DeepEqual(masses, want) {
+		t.Errorf("HeaviestStars() masses after deleting the heaviest star = %v, want %v", masses, want)
+	}
+}
+
+func TestStarsInView(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	var stars []structs.Star2D
+	for x := -400.0; x <= 400; x += 200 {
+		for y := -300.0; y <= 300; y += 200 {
+			stars = append(stars, structs.Star2D{C: structs.Vec2{X: x + 10, Y: y + 20}, M: 1e10})
+		}
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		min, max   structs.Vec2
+		maxStars   int
+		wantPseudo bool
+	}{
+		{name: "all stars fit", min: structs.Vec2{X: -500, Y: -500}, max: structs.Vec2{X: 500, Y: 500}, maxStars: 100},
+		{name: "zoomed in", min: structs.Vec2{X: 0, Y: 0}, max: structs.Vec2{X: 500, Y: 500}, maxStars: 6},
+		{name: "zoomed out", min: structs.Vec2{X: -500, Y: -500}, max: structs.Vec2{X: 500, Y: 500}, maxStars: 5, wantPseudo: true},
+		{name: "single particle", min: structs.Vec2{X: -500, Y: -500}, max: structs.Vec2{X: 500, Y: 500}, maxStars: 1, wantPseudo: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			particles, err := StarsInView(db, index, tt.min, tt.max, tt.maxStars)
+			if err != nil {
+				t.Fatalf("StarsInView() error = %v", err)
+			}
+			if len(particles) == 0 || len(particles) > tt.maxStars {
+				t.Errorf("StarsInView() returned %d particles, want 1 to %d", len(particles), tt.maxStars)
+			}
+
+			var pseudo bool
+			for _, p := range particles {
+				pseudo = pseudo || p.Pseudo
+			}
+			if pseudo != tt.wantPseudo {
+				t.Errorf("StarsInView() returned pseudo-particles = %v, want %v", pseudo, tt.wantPseudo)
+			}
+		})
+	}
+}
+
+func TestFloatFormatting(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// the formatting of floats in Go doesn't depend on the locale, only the precision has to be kept
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{f: 1.5, want: "1.5"},
+		{f: -1234.0625, want: "-1234.0625"},
+		{f: 1e-7, want: "0.0000001"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.f); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	starID := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 1.5, Y: -2.25}, V: structs.Vec2{X: 0.125}, M: 1000.5}, 1)
+
+	want := fmt.Sprintf("%d, 1.5, -2.25, 0.125, 0, 1000.5", starID)
+	if got := GetListOfStarsCsv(db); !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("GetListOfStarsCsv() = %q, want %q", got, []string{want})
+	}
+}
+
+func TestQueryParameterPrecision(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1e7)
+
+	// values that can't be represented using six decimal places
+	star := structs.Star2D{C: structs.Vec2{X: 1.23456789e6, Y: -9.87654321e-7}, V: structs.Vec2{X: 1e-9, Y: 0.1234567891}, M: 1.000000001}
+	starID := InsertStar(db, star, 1)
+	if got := GetStar(db, starID); got != star {
+		t.Errorf("GetStar() = %v, want %v", got, star)
+	}
+
+	force := structs.Vec2{X: 3.3333333333e-12, Y: -1.0000000001}
+	store.updateStarForce(starID, force)
+	stars, err := StarsWithForces(db, 1)
+	if err != nil {
+		t.Fatalf("StarsWithForces() error = %v", err)
+	}
+	if len(stars) != 1 || stars[0].Force != force {
+		t.Errorf("StarsWithForces() = %v, want the force %v", stars, force)
+	}
+}
+
+func TestHasChildren(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+	rootID := mustRootNodeID(t, 1)
+
+	// mark the subdivided root as a leaf
+	if _, err := db.Exec("UPDATE nodes SET isleaf=TRUE WHERE node_id=$1", rootID); err != nil {
+		t.Fatalf("updating isleaf: %v", err)
+	}
+
+	children, err := HasChildren(db, rootID)
+	if err != nil {
+		t.Fatalf("HasChildren() error = %v", err)
+	}
+	if !children {
+		t.Errorf("HasChildren() = false, want true")
+	}
+
+	inconsistent, err := InconsistentLeafNodes(db)
+	if err != nil {
+		t.Fatalf("InconsistentLeafNodes() error = %v", err)
+	}
+	if want := []int64{rootID}; !reflect.DeepEqual(inconsistent, want) {
+		t.Errorf("InconsistentLeafNodes() = %v, want %v", inconsistent, want)
+	}
+
+	// the stale isleaf column doesn't affect inserting into the tree
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: -100}, M: 1000}, 1)
+	if got := len(mustListOfStarsTree(t, 1)); got != 3 {
+		t.Errorf("the tree contains %d stars, want 3", got)
+	}
+	if got := store.getSubtreeIDs(rootID); got == ([4]int64{}) {
+		t.Errorf("the root lost its children: %v", got)
+	}
+}
+
+func TestReinsertStarsNextTimestep(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 1}, M: 1e10},
+		{C: structs.Vec2{X: -150, Y: 100}, M: 2e10},
+		{C: structs.Vec2{X: -100, Y: -200}, M: 4e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	starIDs, stars, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	moved := stars[0]
+	moved.C.X += moved.V.X
+	newIndex, err := ReinsertStarsNextTimestep(db, index, map[int64]structs.Star2D{starIDs[0]: moved})
+	if err != nil {
+		t.Fatalf("ReinsertStarsNextTimestep() error = %v", err)
+	}
+	if newIndex != index+1 {
+		t.Errorf("ReinsertStarsNextTimestep() = %d, want %d", newIndex, index+1)
+	}
+
+	// the next timestep consists of new stars originating from the stars of the tree
+	newStarIDs, newStars, err := store.treeStars(newIndex)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if len(newStarIDs) != len(starIDs) {
+		t.Fatalf("the next timestep contains %d stars, want %d", len(newStarIDs), len(starIDs))
+	}
+	for i, newStarID := range newStarIDs {
+		var originID int64
+		if err := db.QueryRow("SELECT origin_id FROM stars WHERE star_id=$1", newStarID).Scan(&originID); err != nil {
+			t.Fatalf("origin query: %v", err)
+		}
+		if newStarID == starIDs[i] || originID != starIDs[i] {
+			t.Errorf("star %d of the next timestep originates from %d, want a new star originating from %d", newStarID, originID, starIDs[i])
+		}
+	}
+	want := append([]structs.Star2D{moved}, stars[1:]...)
+	if !reflect.DeepEqual(newStars, want) {
+		t.Errorf("stars of the next timestep = %v, want %v", newStars, want)
+	}
+
+	// the earlier timestep keeps the stars as they were
+	if _, oldStars, err := store.treeStars(index); err != nil || !reflect.DeepEqual(oldStars, stars) {
+		t.Errorf("stars of the earlier timestep = %v, %v, want the unchanged %v", oldStars, err, stars)
+	}
+
+	// only the latest tree can be advanced
+	if _, err := ReinsertStarsNextTimestep(db, index, nil); err == nil {
+		t.Errorf("ReinsertStarsNextTimestep() of an old tree succeeded, want an error")
+	}
+}
+
+func TestForceExact(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1e10},
+		{C: structs.Vec2{X: 150, Y: 120}, M: 2e10},
+		{C: structs.Vec2{X: -400, Y: 300}, M: 4e10},
+		{C: structs.Vec2{X: -350, Y: -420}, M: 3e10},
+		{C: structs.Vec2{X: 410, Y: -380}, M: 5e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	exact := NewStoreWithOptions(db, Options{ForceCalculation: ForceExact})
+
+	star := structs.Star2D{C: structs.Vec2{X: 120, Y: 80}, M: 1000}
+	got, err := exact.CalcAllForces(star, index, 1.5)
+	if err != nil {
+		t.Fatalf("CalcAllForces() error = %v", err)
+	}
+	want, err := CalcAllForcesDirect(db, star, index)
+	if err != nil {
+		t.Fatalf("CalcAllForcesDirect() error = %v", err)
+	}
+
+	if math.Abs(got.X-want.X) > 1e-9*math.Abs(want.X) || math.Abs(got.Y-want.Y) > 1e-9*math.Abs(want.Y) {
+		t.Errorf("CalcAllForces() in the exact mode = %v, want %v", got, want)
+	}
+}
+
+func TestCalcAllForcesApprox(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a cluster in the top right quadrant that is far away from the star
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 260, Y: 260}, M: 4e10},
+		{C: structs.Vec2{X: 340, Y: 260}, M: 3e10},
+		{C: structs.Vec2{X: 260, Y: 340}, M: 5e10},
+		{C: structs.Vec2{X: 340, Y: 340}, M: 2e10},
+		{C: structs.Vec2{X: -300, Y: 200}, M: 1e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	star := structs.Star2D{C: structs.Vec2{X: -400, Y: -400}, M: 1000}
+	opened, err := NodesOpenedFor(db, index, star, 1)
+	if err != nil {
+		t.Fatalf("NodesOpenedFor() error = %v", err)
+	}
+	if len(opened) != 1 {
+		t.Fatalf("NodesOpenedFor() = %v, want only the root to be opened", opened)
+	}
+
+	// the approximated cluster still pulls the star
+	got, err := CalcAllForces(db, star, index, 1)
+	if err != nil {
+		t.Fatalf("CalcAllForces() error = %v", err)
+	}
+	want, err := CalcAllForcesDirect(db, star, index)
+	if err != nil {
+		t.Fatalf("CalcAllForcesDirect() error = %v", err)
+	}
+	if math.Hypot(got.X-want.X, got.Y-want.Y) > 0.01*math.Hypot(want.X, want.Y) {
+		t.Errorf("CalcAllForces() = %v, want %v within 1%%", got, want)
+	}
+}
+
+func TestStarsInPolygon(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	selected := structs.Star2D{C: structs.Vec2{X: 100, Y: 50}, M: 1e10}
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		selected,
+		// inside of the bounding box of the triangle, but not inside of the triangle
+		{C: structs.Vec2{X: 180, Y: 180}, M: 2e10},
+		{C: structs.Vec2{X: -300, Y: -300}, M: 3e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		polygon []structs.Vec2
+	}{
+		{name: "triangle", polygon: []structs.Vec2{{X: 0, Y: 0}, {X: 200, Y: 0}, {X: 0, Y: 200}}},
+		{name: "closed triangle", polygon: []structs.Vec2{{X: 0, Y: 0}, {X: 200, Y: 0}, {X: 0, Y: 200}, {X: 0, Y: 0}}},
+		{name: "non-convex", polygon: []structs.Vec2{{X: 0, Y: 0}, {X: 200, Y: 0}, {X: 200, Y: 100}, {X: 50, Y: 100}, {X: 200, Y: 200}, {X: 0, Y: 200}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StarsInPolygon(db, index, tt.polygon)
+			if err != nil {
+				t.Fatalf("StarsInPolygon() error = %v", err)
+			}
+			if want := []structs.Star2D{selected}; !reflect.DeepEqual(got, want) {
+				t.Errorf("StarsInPolygon() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEscapeVelocity(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	G := 6.6726 * math.Pow(10, -11)
+	M := 1e20
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	starID := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 0, Y: 0}, V: structs.Vec2{X: 3, Y: 4}, M: M}, 1)
+
+	got, err := EscapeVelocity(db, 1, structs.Vec2{X: 300, Y: 400}, 0.5)
+	if err != nil {
+		t.Fatalf("EscapeVelocity() error = %v", err)
+	}
+	if want := math.Sqrt(2 * G * M / 500); math.Abs(got-want) > 1e-9*want {
+		t.Errorf("EscapeVelocity() = %v, want %v", got, want)
+	}
+
+	speed, err := StarSpeed(db, starID)
+	if err != nil {
+		t.Fatalf("StarSpeed() error = %v", err)
+	}
+	if speed != 5 {
+		t.Errorf("StarSpeed() = %v, want 5", speed)
+	}
+}
+
+func TestUnboundStars(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// the escape velocity close to the massive star is about 0.1
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 0, Y: 0}, M: 1e10},
+		{C: structs.Vec2{X: 100, Y: 0}, V: structs.Vec2{Y: 0.01}, M: 1},
+		{C: structs.Vec2{X: 0, Y: -100}, V: structs.Vec2{X: 10}, M: 1},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	starIDs, _, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	unbound, err := UnboundStars(db, index, 0.5)
+	if err != nil {
+		t.Fatalf("UnboundStars() error = %v", err)
+	}
+	if want := []int64{starIDs[2]}; !reflect.DeepEqual(unbound, want) {
+		t.Errorf("UnboundStars() = %v, want %v", unbound, want)
+	}
+}
+
+func TestCalcAllForcesBatch(t *testing.T) {
+	if RecommendPoolSize(8, 5) <= RecommendPoolSize(4, 5) {
+		t.Errorf("RecommendPoolSize() doesn't grow with the number of workers")
+	}
+
+	// define a database
+	db = ConnectToDB(DBNAME)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1e10},
+		{C: structs.Vec2{X: 150, Y: 120}, M: 2e10},
+		{C: structs.Vec2{X: -400, Y: 300}, M: 4e10},
+		{C: structs.Vec2{X: -350, Y: -420}, M: 3e10},
+		{C: structs.Vec2{X: 410, Y: -380}, M: 5e10},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	var maxDepth int
+	if err := db.QueryRow("SELECT max(depth) FROM nodes WHERE timestep=$1", index).Scan(&maxDepth); err != nil {
+		t.Fatalf("querying the depth: %v", err)
+	}
+
+	workers := 4
+	db.SetMaxOpenConns(RecommendPoolSize(workers, maxDepth))
+	store := NewStoreWithOptions(db, Options{QueryTimeout: 30 * time.Second})
+
+	forces, err := store.CalcAllForcesBatch(stars, index, 0.5, workers)
+	if err != nil {
+		t.Fatalf("CalcAllForcesBatch() error = %v", err)
+	}
+
+	for i, star := range stars {
+		want, err := CalcAllForces(db, star, index, 0.5)
+		if err != nil {
+			t.Fatalf("CalcAllForces() error = %v", err)
+		}
+		if forces[i] != want {
+			t.Errorf("force on star %d = %v, want %v", i, forces[i], want)
+		}
+	}
+}
+
+func TestStarAtCoordinates(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	star := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}
+	starID := InsertStar(db, star, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100.5, Y: 100}, M: 1000}, 1)
+
+	gotID, got, found, err := StarAtCoordinates(db, structs.Vec2{X: 100.01, Y: 99.99}, 0.1)
+	if err != nil {
+		t.Fatalf("StarAtCoordinates() error = %v", err)
+	}
+	if !found || gotID != starID || got != star {
+		t.Errorf("StarAtCoordinates() = (%d, %v, %v), want (%d, %v, true)", gotID, got, found, starID, star)
+	}
+
+	if _, _, found, err := StarAtCoordinates(db, structs.Vec2{X: 200, Y: 200}, 0.1); err != nil || found {
+		t.Errorf("StarAtCoordinates() far away from all stars = (found %v, error %v), want not found", found, err)
+	}
+}
+
+func TestTreeFootprint(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+
+	nodeBytes, starBytes, err := TreeFootprint(db, 1)
+	if err != nil {
+		t.Fatalf("TreeFootprint() error = %v", err)
+	}
+	if nodeBytes == 0 || starBytes == 0 {
+		t.Errorf("TreeFootprint() = (%d, %d), want a non-zero footprint", nodeBytes, starBytes)
+	}
+
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: 100}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+
+	grownNodeBytes, grownStarBytes, err := TreeFootprint(db, 1)
+	if err != nil {
+		t.Fatalf("TreeFootprint() error = %v", err)
+	}
+	if grownNodeBytes <= nodeBytes || grownStarBytes <= starBytes {
+		t.Errorf("TreeFootprint() after inserting stars = (%d, %d), want more than (%d, %d)", grownNodeBytes, grownStarBytes, nodeBytes, starBytes)
+	}
+}
+
+func TestStarsWithIDGreaterThan(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	var starIDs []int64
+	for i := 0; i < 4; i++ {
+		starIDs = append(starIDs, InsertStar(db, structs.Star2D{C: structs.Vec2{X: float64(100 * i), Y: 100}, M: 1000}, 1))
+	}
+
+	tests := []struct {
+		name   string
+		lastID int64
+		limit  int64
+		want   []int64
+	}{
+		{name: "all stars after the cursor", lastID: starIDs[1], limit: 10, want: starIDs[2:]},
+		{name: "limited page", lastID: 0, limit: 2, want: starIDs[:2]},
+		{name: "no new stars", lastID: starIDs[3], limit: 10, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := StarsWithIDGreaterThan(db, tt.lastID, tt.limit)
+			if err != nil {
+				t.Fatalf("StarsWithIDGreaterThan() error = %v", err)
+			}
+
+			var got []int64
+			for _, r := range records {
+				got = append(got, r.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StarsWithIDGreaterThan() ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishTree(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	InitCurrentTreeTable(db)
+	if _, err := db.Exec("DELETE FROM current_tree"); err != nil {
+		t.Fatalf("clearing current_tree: %v", err)
+	}
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	if _, err := CurrentTree(db); err != ErrNoCurrentTree {
+		t.Errorf("CurrentTree() error = %v, want %v", err, ErrNoCurrentTree)
+	}
+
+	treeA := store.newTreeIndex(1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, treeA)
+	if err := PublishTree(db, treeA); err != nil {
+		t.Fatalf("PublishTree() error = %v", err)
+	}
+
+	// readers keep seeing tree A while tree B is being built
+	treeB := store.newTreeIndex(1000)
+	for i, star := range []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 1000},
+	} {
+		InsertStar(db, star, treeB)
+
+		current, err := CurrentTree(db)
+		if err != nil {
+			t.Fatalf("CurrentTree() error = %v", err)
+		}
+		if current != treeA {
+			t.Errorf("CurrentTree() after inserting %d stars into tree B = %d, want %d", i+1, current, treeA)
+		}
+	}
+
+	if err := PublishTree(db, treeB); err != nil {
+		t.Fatalf("PublishTree() error = %v", err)
+	}
+	if current, err := CurrentTree(db); err != nil || current != treeB {
+		t.Errorf("CurrentTree() = (%d, %v), want (%d, nil)", current, err, treeB)
+	}
+
+	if err := PublishTree(db, treeB+1); err != ErrTreeNotFound {
+		t.Errorf("PublishTree() of a missing tree error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestSpeedHistogram(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// speeds of 0, 3, 5 (3-4-5), 5, 12 and 25
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: 100}, V: structs.Vec2{X: 3}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, V: structs.Vec2{X: 3, Y: 4}, M: 1000},
+		{C: structs.Vec2{X: 100, Y: -100}, V: structs.Vec2{Y: -5}, M: 1000},
+		{C: structs.Vec2{X: 300, Y: 300}, V: structs.Vec2{X: -12}, M: 1000},
+		{C: structs.Vec2{X: -300, Y: 300}, V: structs.Vec2{X: 25}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	got, err := SpeedHistogram(db, index, 4, 20)
+	if err != nil {
+		t.Fatalf("SpeedHistogram() error = %v", err)
+	}
+	if want := []int64{2, 2, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SpeedHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestRecenterGalaxy(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 300, Y: 200}, M: 1e10},
+		{C: structs.Vec2{X: 100, Y: 250}, M: 2e10},
+		{C: structs.Vec2{X: 200, Y: 400}, M: 4e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	if err := RecenterGalaxy(db, index); err != nil {
+		t.Fatalf("RecenterGalaxy() error = %v", err)
+	}
+
+	var totalMass float64
+	var com structs.Vec2
+	for _, star := range mustListOfStarsTree(t, index) {
+		totalMass += star.M
+		com.X += star.C.X * star.M
+		com.Y += star.C.Y * star.M
+	}
+	com = com.Multiply(1 / totalMass)
+	if math.Abs(com.X) > 1e-6 || math.Abs(com.Y) > 1e-6 {
+		t.Errorf("center of mass after RecenterGalaxy() = %v, want ~(0, 0)", com)
+	}
+
+	// the geometry of the tree has been moved along with the stars
+	root, err := store.getNode(mustRootNodeID(t, index))
+	if err != nil {
+		t.Fatalf("getNode() error = %v", err)
+	}
+	if math.Abs(root.CenterOfMass.X) > 1e-6 || math.Abs(root.CenterOfMass.Y) > 1e-6 {
+		t.Errorf("center of mass of the root after RecenterGalaxy() = %v, want ~(0, 0)", root.CenterOfMass)
+	}
+}
+
+func TestStarsInTreeWhere(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 1}, M: 1},
+		{C: structs.Vec2{X: -100, Y: 100}, V: structs.Vec2{X: 5}, M: 2},
+		{C: structs.Vec2{X: -100, Y: -100}, V: structs.Vec2{X: 10}, M: 3},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// the same stars in another tree mustn't be returned
+	other := store.newTreeIndex(1000)
+	for _, star := range stars {
+		InsertStar(db, star, other)
+	}
+
+	tests := []struct {
+		name   string
+		filter StarFilter
+		want   []structs.Star2D
+	}{
+		{name: "no filter", filter: StarFilter{}, want: stars},
+		{name: "mass", filter: StarFilter{MinMass: 2}, want: stars[1:]},
+		{name: "mass and speed", filter: StarFilter{MinMass: 2, MaxSpeed: 6}, want: stars[1:2]},
+		{name: "region", filter: StarFilter{Min: structs.Vec2{X: 0, Y: 0}, Max: structs.Vec2{X: 200, Y: 200}}, want: stars[:1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StarsInTreeWhere(db, index, tt.filter)
+			if err != nil {
+				t.Fatalf("StarsInTreeWhere() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StarsInTreeWhere() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveStarToNodeCenter(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 1},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+
+	starID := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 300, Y: -300}, M: 1}, index)
+
+	// move the star exactly onto the centers of the root and of one of its subnodes
+	root, err := store.getNode(rootID)
+	if err != nil {
+		t.Fatalf("getNode(%d) error = %v", rootID, err)
+	}
+	subnode, err := store.getNode(root.Subnodes[0])
+	if err != nil {
+		t.Fatalf("getNode(%d) error = %v", root.Subnodes[0], err)
+	}
+
+	for _, center := range []structs.Vec2{root.BoxCenter, subnode.BoxCenter} {
+		if err := MoveStar(db, starID, index, center); err != nil {
+			t.Fatalf("MoveStar(%v) error = %v", center, err)
+		}
+
+		leafID := store.findLeaf(rootID, center)
+		if got := store.getStarID(leafID); got != starID {
+			t.Errorf("MoveStar(%v): leaf %d contains the star %d, want %d", center, leafID, got, starID)
+		}
+		if got := GetStar(db, starID).C; got != center {
+			t.Errorf("MoveStar(%v): star is at %v", center, got)
+		}
+		if got := mustListOfStarsTree(t, index); len(got) != 4 {
+			t.Errorf("MoveStar(%v): tree contains %d stars, want 4", center, len(got))
+		}
+	}
+
+	if err := MoveStar(db, starID, index+1000, structs.Vec2{}); err != ErrTreeNotFound {
+		t.Errorf("MoveStar() on a missing tree error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestRootNodeID(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	index, wantRootID, err := NewTreeAt(db, structs.Vec2{}, 1000)
+	if err != nil {
+		t.Fatalf("NewTreeAt() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		index   int64
+		want    int64
+		wantErr error
+	}{
+		{name: "existing", index: index, want: wantRootID},
+		{name: "missing", index: index + 1, wantErr: ErrTreeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RootNodeID(db, tt.index)
+			if err != tt.wantErr {
+				t.Fatalf("RootNodeID() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RootNodeID() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// starListDescriptor builds the descriptor of the StarList message documented by StarListProto, so that the encoding
+// can be checked against the protobuf runtime
+func starListDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+	}
+	double := descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	stars := field("stars", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	stars.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	stars.TypeName = proto.String(".galaxy.Star")
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("galaxy.proto"),
+		Package: proto.String("galaxy"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Star"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+					field("x", 2, double),
+					field("y", 3, double),
+					field("vx", 4, double),
+					field("vy", 5, double),
+					field("m", 6, double),
+				},
+			},
+			{
+				Name: proto.String("StarList"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("index", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+					stars,
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() error = %v", err)
+	}
+	return file.Messages().ByName("StarList")
+}
+
+func TestStarsProto(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100.5, Y: -100}, V: structs.Vec2{X: 1, Y: -2.25}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: 100}, V: structs.Vec2{X: 0, Y: 3}, M: 2000},
+		{C: structs.Vec2{X: 0, Y: 0}, V: structs.Vec2{X: -4, Y: 0}, M: 1e30},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	data, err := StarsProto(db, index)
+	if err != nil {
+		t.Fatalf("StarsProto() error = %v", err)
+	}
+
+	var list StarListProto
+	if err := list.Unmarshal(data); err != nil {
+		t.Fatalf("StarListProto.Unmarshal() error = %v", err)
+	}
+
+	if list.Index != index {
+		t.Errorf("StarsProto() index = %d, want %d", list.Index, index)
+	}
+
+	var got []structs.Star2D
+	for _, s := range list.Stars {
+		if s.ID == 0 {
+			t.Errorf("StarsProto() star %v without an id", s)
+		}
+		got = append(got, structs.Star2D{C: structs.Vec2{X: s.X, Y: s.Y}, V: structs.Vec2{X: s.VX, Y: s.VY}, M: s.M})
+	}
+	if !reflect.DeepEqual(got, stars) {
+		t.Errorf("StarsProto() = %v, want %v", got, stars)
+	}
+
+	// the protobuf runtime has to decode the same message and encode it to the same bytes
+	desc := starListDescriptor(t)
+	ref := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, ref); err != nil {
+		t.Fatalf("proto.Unmarshal() error = %v", err)
+	}
+	if got := ref.Get(desc.Fields().ByName("index")).Int(); got != index {
+		t.Errorf("proto.Unmarshal() index = %d, want %d", got, index)
+	}
+	refStars := ref.Get(desc.Fields().ByName("stars")).List()
+	if refStars.Len() != len(list.Stars) {
+		t.Fatalf("proto.Unmarshal() = %d stars, want %d", refStars.Len(), len(list.Stars))
+	}
+	starFields := desc.Fields().ByName("stars").Message().Fields()
+	for i, want := range list.Stars {
+		star := refStars.Get(i).Message()
+		got := StarProto{
+			ID: star.Get(starFields.ByName("id")).Int(),
+			X:  star.Get(starFields.ByName("x")).Float(),
+			Y:  star.Get(starFields.ByName("y")).Float(),
+			VX: star.Get(starFields.ByName("vx")).Float(),
+			VY: star.Get(starFields.ByName("vy")).Float(),
+			M:  star.Get(starFields.ByName("m")).Float(),
+		}
+		if got != want {
+			t.Errorf("proto.Unmarshal() star %d = %v, want %v", i, got, want)
+		}
+	}
+
+	refData, err := proto.MarshalOptions{Deterministic: true}.Marshal(ref)
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+	if !bytes.Equal(refData, data) {
+		t.Errorf("StarsProto() = %x, want %x as encoded by the protobuf runtime", data, refData)
+	}
+}
+
+func TestCalcAllForcesCountsEachStarOnce(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1e10},
+		{C: structs.Vec2{X: -200, Y: 50}, M: 2e10},
+		{C: structs.Vec2{X: 150, Y: -300}, M: 3e10},
+	}
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	var ids []int64
+	for _, star := range stars {
+		ids = append(ids, InsertStar(db, star, 1))
+	}
+	UpdateTotalMass(db, 1)
+	UpdateCenterOfMass(db, 1)
+
+	for i, star := range stars {
+		// the direct pairwise sum over all the other stars
+		var want structs.Vec2
+		for j, other := range stars {
+			if i == j {
+				continue
+			}
+			force := calcForce(other, star, combinedSoftening(store.getStarSoftening(ids[j]), store.getStarSoftening(ids[i])))
+			want.X += force.X
+			want.Y += force.Y
+		}
+
+		// a theta of 0 opens every node
+		got, err := CalcAllForcesByID(db, ids[i], 1, 0)
+		if err != nil {
+			t.Fatalf("CalcAllForcesByID() error = %v", err)
+		}
+		if math.Abs(got.X-want.X) > 1e-9*math.Abs(want.X) || math.Abs(got.Y-want.Y) > 1e-9*math.Abs(want.Y) {
+			t.Errorf("force on star %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestConcurrentTreeWidths(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	widths := []float64{1000, 4000}
+	indices := make([]int64, len(widths))
+	errs := make([]error, len(widths))
+
+	var wg sync.WaitGroup
+	for i, width := range widths {
+		wg.Add(1)
+		go func(i int, width float64) {
+			defer wg.Done()
+
+			indices[i], _, errs[i] = NewTreeAt(db, structs.Vec2{}, width)
+			if errs[i] != nil {
+				return
+			}
+
+			// two stars force the root to be subdivided
+			InsertStar(db, structs.Star2D{C: structs.Vec2{X: width / 4, Y: width / 4}, M: 1}, indices[i])
+			InsertStar(db, structs.Star2D{C: structs.Vec2{X: -width / 4, Y: -width / 4}, M: 1}, indices[i])
+		}(i, width)
+	}
+	wg.Wait()
+
+	if indices[0] == indices[1] {
+		t.Fatalf("NewTreeAt() created two trees with the index %d", indices[0])
+	}
+
+	for i, width := range widths {
+		if errs[i] != nil {
+			t.Fatalf("NewTreeAt() error = %v", errs[i])
+		}
+
+		root, err := store.getNode(mustRootNodeID(t, indices[i]))
+		if err != nil {
+			t.Fatalf("getNode() error = %v", err)
+		}
+		if root.BoxWidth != width {
+			t.Errorf("tree %d: root width = %v, want %v", indices[i], root.BoxWidth, width)
+		}
+		for _, subnodeID := range root.Subnodes {
+			if got := store.getBoxWidth(subnodeID); got != width/2 {
+				t.Errorf("tree %d: subnode %d width = %v, want %v", indices[i], subnodeID, got, width/2)
+			}
+		}
+	}
+}
+
+func TestStarsForTimestepRange(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// a tree preceding the simulation, three timesteps and a timestep after the requested range
+	frames := [][]structs.Star2D{
+		{{C: structs.Vec2{X: 1, Y: 1}, M: 1}},
+		{{C: structs.Vec2{X: 10, Y: 10}, M: 1}, {C: structs.Vec2{X: -10, Y: 10}, M: 1}},
+		{{C: structs.Vec2{X: 20, Y: 20}, M: 1}, {C: structs.Vec2{X: -20, Y: 20}, M: 1}},
+		{{C: structs.Vec2{X: 30, Y: 30}, M: 1}},
+		{{C: structs.Vec2{X: 40, Y: 40}, M: 1}},
+	}
+	for _, frame := range frames {
+		index := store.newTreeIndex(1000)
+		for _, star := range frame {
+			InsertStar(db, star, index)
+		}
+	}
+
+	got, err := StarsForTimestepRange(db, 2, 1, 4)
+	if err != nil {
+		t.Fatalf("StarsForTimestepRange() error = %v", err)
+	}
+	want := map[int64][]structs.Star2D{2: frames[1], 3: frames[2], 4: frames[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StarsForTimestepRange() = %v, want %v", got, want)
+	}
+}
+
+func TestSubdivisionDepthForPair(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    structs.Vec2
+		wantMin int64
+		wantMax int64
+		wantErr error
+	}{
+		{name: "different quadrants", a: structs.Vec2{X: 100, Y: 100}, b: structs.Vec2{X: -100, Y: -100}, wantMin: 1, wantMax: 1},
+		{name: "same quadrant", a: structs.Vec2{X: 100, Y: 100}, b: structs.Vec2{X: 600, Y: 600}, wantMin: 2, wantMax: 2},
+		{name: "very close", a: structs.Vec2{X: 1, Y: 1}, b: structs.Vec2{X: 1 + 1e-6, Y: 1}, wantMin: 25, wantMax: 40},
+		{name: "equal", a: structs.Vec2{X: 1, Y: 1}, b: structs.Vec2{X: 1, Y: 1}, wantErr: ErrInseparable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SubdivisionDepthForPair(nil, tt.a, tt.b, 1000)
+			if err != tt.wantErr {
+				t.Fatalf("SubdivisionDepthForPair() error = %v, want %v", err, tt.wantErr)
+			}
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("SubdivisionDepthForPair() = %d, want %d to %d", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestSubtreeOccupancy(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// three stars in the north east, one in the south west and none in the other quadrants. The stars all have a
+	// mass of 1, so the mass of a subtree is the number of stars inside of it
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1},
+		{C: structs.Vec2{X: 300, Y: 200}, M: 1},
+		{C: structs.Vec2{X: 200, Y: 400}, M: 1},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+
+	got, err := SubtreeOccupancy(db, rootID)
+	if err != nil {
+		t.Fatalf("SubtreeOccupancy() error = %v", err)
+	}
+
+	var total, max int64
+	for i, subnodeID := range store.getSubtreeIDs(rootID) {
+		mass, err := ComputeSubtreeMass(db, subnodeID)
+		if err != nil {
+			t.Fatalf("ComputeSubtreeMass() error = %v", err)
+		}
+		if got[i] != int64(mass) {
+			t.Errorf("SubtreeOccupancy()[%d] = %d, want %d", i, got[i], int64(mass))
+		}
+		total += got[i]
+		if got[i] > max {
+			max = got[i]
+		}
+	}
+	if total != 4 || max != 3 {
+		t.Errorf("SubtreeOccupancy() = %v, want 4 stars with 3 of them in one quadrant", got)
+	}
+
+	// a leaf doesn't have any subtrees
+	leafID := store.findLeaf(rootID, structs.Vec2{X: -100, Y: -100})
+	if got, err := SubtreeOccupancy(db, leafID); err != nil || got != [4]int64{} {
+		t.Errorf("SubtreeOccupancy(leaf) = %v, %v, want no stars", got, err)
+	}
+}
+
+func TestInsertStarStrict(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	star := structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}
+
+	// the strict variant doesn't create the missing tree
+	if _, err := InsertStarStrict(db, star, 1); err != ErrTreeNotFound {
+		t.Fatalf("InsertStarStrict() error = %v, want %v", err, ErrTreeNotFound)
+	}
+	if ids := GetListOfStarIDs(db); len(ids) != 0 {
+		t.Errorf("InsertStarStrict() inserted the stars %v into a missing tree", ids)
+	}
+
+	// the default creates it
+	starID := InsertStar(db, star, 1)
+	if got := mustListOfStarsTree(t, 1); !reflect.DeepEqual(got, []structs.Star2D{star}) {
+		t.Errorf("InsertStar() tree contains %v, want %v", got, []structs.Star2D{star})
+	}
+
+	// once the tree exists, the strict variant inserts into it
+	strictID, err := InsertStarStrict(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 1000}, 1)
+	if err != nil {
+		t.Fatalf("InsertStarStrict() error = %v", err)
+	}
+	if strictID == starID || len(mustListOfStarsTree(t, 1)) != 2 {
+		t.Errorf("InsertStarStrict() didn't insert the star into the existing tree")
+	}
+}
+
+func TestStarsDownsampled(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	var stars []structs.Star2D
+	for i := 0; i < 10; i++ {
+		stars = append(stars, structs.Star2D{C: structs.Vec2{X: float64(50*i - 250), Y: float64(30*i - 150)}, M: float64(i + 1)})
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// stars of another tree mustn't be returned
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 1, Y: 1}, M: 100}, store.newTreeIndex(1000))
+
+	for _, maxStars := range []int64{0, 3, 10, 20} {
+		got, err := StarsDownsampled(db, index, maxStars)
+		if err != nil {
+			t.Fatalf("StarsDownsampled() error = %v", err)
+		}
+
+		want := maxStars
+		if want > int64(len(stars)) {
+			want = int64(len(stars))
+		}
+		if int64(len(got)) != want {
+			t.Errorf("StarsDownsampled(%d) returned %d stars, want %d", maxStars, len(got), want)
+		}
+
+		for _, star := range got {
+			found := false
+			for _, s := range stars {
+				found = found || s == star
+			}
+			if !found {
+				t.Errorf("StarsDownsampled(%d) returned %v, which isn't part of the tree", maxStars, star)
+			}
+		}
+	}
+}
+
+func TestNodeOpeningAngle(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// a box with a width of 100 and its center of mass at (30, 40)
+	_, rootID, err := NewTreeAt(db, structs.Vec2{X: 50, Y: 50}, 100)
+	if err != nil {
+		t.Fatalf("NewTreeAt() error = %v", err)
+	}
+	if _, err := db.Exec("UPDATE nodes SET center_of_mass='{30, 40}' WHERE node_id=$1", rootID); err != nil {
+		t.Fatalf("setting the center of mass: %v", err)
+	}
+
+	tests := []struct {
+		viewpoint structs.Vec2
+		want      float64
+	}{
+		{viewpoint: structs.Vec2{X: 0, Y: 0}, want: 2},
+		{viewpoint: structs.Vec2{X: 30, Y: 240}, want: 0.5},
+		{viewpoint: structs.Vec2{X: 30, Y: 40}, want: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		got, err := NodeOpeningAngle(db, rootID, tt.viewpoint)
+		if err != nil {
+			t.Fatalf("NodeOpeningAngle() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("NodeOpeningAngle(%v) = %v, want %v", tt.viewpoint, got, tt.want)
+		}
+	}
+
+	// the opening angle is the theta used when calculating forces
+	got, err := store.calcTheta(context.Background(), structs.Star2D{}, rootID)
+	if err != nil {
+		t.Fatalf("calcTheta() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("calcTheta() = %v, want 2", got)
+	}
+}
+
+func TestInsertStarsFromReader(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	list := strings.Join([]string{
+		"10000, 20000",
+		"-30000, 40000",
+		"50000, -60000, 1, 2",
+		"-70000, -80000, 3, 4, 500",
+		"90000, 10000",
+	}, "\n")
+
+	// a batch size of 2 inserts a last, partial batch
+	opts := InsertListOptions{Scale: 1000, Mass: 1000, BatchSize: 2}
+	count, err := InsertStarsFromReader(db, strings.NewReader(list), 1, opts)
+	if err != nil {
+		t.Fatalf("InsertStarsFromReader() error = %v", err)
+	}
+	if count != 5 {
+		t.Errorf("InsertStarsFromReader() = %d, want 5", count)
+	}
+
+	got := mustListOfStarsTree(t, 1)
+	if len(got) != 5 {
+		t.Fatalf("tree contains %d stars, want 5", len(got))
+	}
+	want := structs.Star2D{C: structs.Vec2{X: -70, Y: -80}, V: structs.Vec2{X: 3, Y: 4}, M: 500}
+	found := false
+	for _, star := range got {
+		found = found || star == want
+	}
+	if !found {
+		t.Errorf("tree contains %v, want it to contain %v", got, want)
+	}
+
+	// invalid records stop the insertion
+	count, err = InsertStarsFromReader(db, strings.NewReader("1, 2\nx, 3\n"), 1, opts)
+	if err == nil || count != 0 {
+		t.Errorf("InsertStarsFromReader() = %d, %v, want an error before inserting anything", count, err)
+	}
+
+	// a star that can't be placed into the tree rolls back its whole batch, while the batches committed before stay
+	// inserted and placed
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION reject_mass_13() RETURNS trigger AS $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM stars WHERE star_id=NEW.star_id AND m=13) THEN
+				RAISE EXCEPTION 'unplaceable star';
+			END IF;
+			RETURN NEW;
+		END; $$ LANGUAGE plpgsql`)
+	if err != nil {
+		t.Fatalf("creating the trigger function: %v", err)
+	}
+	if _, err := db.Exec("CREATE TRIGGER reject_mass_13 BEFORE UPDATE ON nodes FOR EACH ROW EXECUTE PROCEDURE reject_mass_13()"); err != nil {
+		t.Fatalf("creating the trigger: %v", err)
+	}
+	defer db.Exec("DROP FUNCTION reject_mass_13() CASCADE")
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	failing := "10000, 20000\n-30000, 40000\n50000, -60000, 0, 0, 13\n-70000, -80000\n90000, 10000\n"
+	count, err = InsertStarsFromReader(db, strings.NewReader(failing), 1, opts)
+	if err == nil || count != 2 {
+		t.Errorf("InsertStarsFromReader() = %d, %v, want an error after the first batch", count, err)
+	}
+	if got := mustListOfStarsTree(t, 1); len(got) != 2 {
+		t.Errorf("tree contains %d stars, want the 2 stars of the first batch", len(got))
+	}
+	if unplaced, err := UnplacedStars(db); err != nil || len(unplaced) != 0 {
+		t.Errorf("UnplacedStars() = %v, %v, want no stars left by the failed batch", unplaced, err)
+	}
+
+	if _, err := InsertStarsFromReader(db, strings.NewReader(list), 2, opts); err != ErrTreeNotFound {
+		t.Errorf("InsertStarsFromReader() error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestDynamicalTime(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// two stars of 1e20 kg 100 m away from their center of mass
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 150, Y: 50}, M: 1e20},
+		{C: structs.Vec2{X: -50, Y: 50}, M: 1e20},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	got, err := DynamicalTime(db, index)
+	if err != nil {
+		t.Fatalf("DynamicalTime() error = %v", err)
+	}
+
+	density := 2e20 / (4.0 / 3.0 * math.Pi * 1e6)
+	want := 1 / math.Sqrt(6.6726e-11*density)
+	if math.Abs(got-want) > 1e-9*want {
+		t.Errorf("DynamicalTime() = %v, want %v", got, want)
+	}
+
+	// an empty tree doesn't have a dynamical time
+	if _, err := DynamicalTime(db, store.newTreeIndex(1000)); err == nil {
+		t.Errorf("DynamicalTime() of an empty tree succeeded")
+	}
+}
+
+func TestStarsWithForces(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 1, Y: 2}, M: 1e10},
+		{C: structs.Vec2{X: -200, Y: 50}, V: structs.Vec2{X: 3, Y: 4}, M: 2e10},
+		{C: structs.Vec2{X: 150, Y: -300}, V: structs.Vec2{X: 5, Y: 6}, M: 3e10},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	forces, err := CalcForcesForTree(db, index, 0)
+	if err != nil {
+		t.Fatalf("CalcForcesForTree() error = %v", err)
+	}
+	if err := StoreForces(db, forces); err != nil {
+		t.Fatalf("StoreForces() error = %v", err)
+	}
+
+	got, err := StarsWithForces(db, index)
+	if err != nil {
+		t.Fatalf("StarsWithForces() error = %v", err)
+	}
+	if len(got) != len(stars) {
+		t.Fatalf("StarsWithForces() returned %d stars, want %d", len(got), len(stars))
+	}
+	for i, s := range got {
+		if s.Star != stars[i] {
+			t.Errorf("StarsWithForces()[%d].Star = %v, want %v", i, s.Star, stars[i])
+		}
+		want := forces[s.ID]
+		if math.Abs(s.Force.X-want.X) > 1e-9*math.Abs(want.X) || math.Abs(s.Force.Y-want.Y) > 1e-9*math.Abs(want.Y) {
+			t.Errorf("StarsWithForces()[%d].Force = %v, want %v", i, s.Force, want)
+		}
+	}
+}
+
+func TestValidateNodeInvariant(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+	leafID := store.findLeaf(rootID, structs.Vec2{X: 100, Y: 100})
+	starID := store.getStarID(leafID)
+
+	for _, nodeID := range []int64{rootID, leafID} {
+		if err := ValidateNodeInvariant(db, nodeID); err != nil {
+			t.Errorf("ValidateNodeInvariant(%d) error = %v, want nil", nodeID, err)
+		}
+	}
+
+	// an internal node storing a star
+	if _, err := db.Exec("UPDATE nodes SET star_id=$1 WHERE node_id=$2", starID, rootID); err != nil {
+		t.Fatalf("corrupting the root: %v", err)
+	}
+	if err := ValidateNodeInvariant(db, rootID); err == nil {
+		t.Errorf("ValidateNodeInvariant() didn't report the internal node %d storing a star", rootID)
+	}
+
+	// a leaf marked as an internal node
+	if _, err := db.Exec("UPDATE nodes SET isleaf=FALSE WHERE node_id=$1", leafID); err != nil {
+		t.Fatalf("corrupting the leaf: %v", err)
+	}
+	if err := ValidateNodeInvariant(db, leafID); err == nil {
+		t.Errorf("ValidateNodeInvariant() didn't report the leaf %d marked as an internal node", leafID)
+	}
+}
+
+func TestAdaptiveSoftening(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a dense core around the origin and a lonely star far away from it
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 0, Y: 0}, M: 1000},
+		{C: structs.Vec2{X: 2, Y: 0}, M: 1000},
+		{C: structs.Vec2{X: 0, Y: 3}, M: 1000},
+		{C: structs.Vec2{X: 400, Y: 400}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	dense, err := AdaptiveSoftening(db, index, structs.Vec2{X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("AdaptiveSoftening() error = %v", err)
+	}
+	if want := adaptiveSofteningFraction * 2; dense != want {
+		t.Errorf("AdaptiveSoftening() in the core = %v, want %v", dense, want)
+	}
+
+	sparse, err := AdaptiveSoftening(db, index, structs.Vec2{X: 400, Y: 400})
+	if err != nil {
+		t.Fatalf("AdaptiveSoftening() error = %v", err)
+	}
+	if sparse <= dense {
+		t.Errorf("AdaptiveSoftening() = %v in the sparse region, want more than %v in the core", sparse, dense)
+	}
+}
+
+func TestTreeDOT(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: 300, Y: 300}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := TreeDOT(db, index, &buf); err != nil {
+		t.Fatalf("TreeDOT() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if lines[0] != "digraph tree {" || lines[len(lines)-1] != "}" {
+		t.Fatalf("TreeDOT() = %q, want a digraph", buf.String())
+	}
+
+	// every statement is either a node or an edge between two nodes of the graph
+	nodeLine := regexp.MustCompile(`^\tn(\d+) \[label="[^"]*"\];$`)
+	edgeLine := regexp.MustCompile(`^\tn(\d+) -> n(\d+);$`)
+	nodes := make(map[string]bool)
+	var edges [][]string
+	for _, line := range lines[1 : len(lines)-1] {
+		if m := nodeLine.FindStringSubmatch(line); m != nil {
+			nodes[m[1]] = true
+		} else if m := edgeLine.FindStringSubmatch(line); m != nil {
+			edges = append(edges, m[1:])
+		} else {
+			t.Errorf("TreeDOT() contains the invalid line %q", line)
+		}
+	}
+	for _, edge := range edges {
+		if !nodes[edge[0]] || !nodes[edge[1]] {
+			t.Errorf("TreeDOT() contains the edge %v between unknown nodes", edge)
+		}
+	}
+
+	nodeIDs, err := TimestepNodeIDs(db, index)
+	if err != nil {
+		t.Fatalf("TimestepNodeIDs() error = %v", err)
+	}
+	if len(nodes) != len(nodeIDs) || len(edges) != len(nodeIDs)-1 {
+		t.Errorf("TreeDOT() contains %d nodes and %d edges, want %d nodes and %d edges", len(nodes), len(edges), len(nodeIDs), len(nodeIDs)-1)
+	}
+}
+
+func TestClosestPair(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// spread out stars with a close pair in between them
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	for _, c := range []structs.Vec2{{X: -400, Y: -400}, {X: 400, Y: -300}, {X: -300, Y: 400}, {X: 350, Y: 350}} {
+		InsertStar(db, structs.Star2D{C: c, M: 1000}, 1)
+	}
+	wantA := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 10, Y: 20}, M: 1000}, 1)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 200, Y: 20}, M: 1000}, 1)
+	wantB := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 13, Y: 24}, M: 1000}, 1)
+
+	idA, idB, dist, err := ClosestPair(db, 1)
+	if err != nil {
+		t.Fatalf("ClosestPair() error = %v", err)
+	}
+	if idA != wantA || idB != wantB || dist != 5 {
+		t.Errorf("ClosestPair() = %d, %d, %v, want %d, %d, 5", idA, idB, dist, wantA, wantB)
+	}
+
+	// a single star doesn't form a pair
+	index := store.newTreeIndex(1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: 1, Y: 1}, M: 1000}, index)
+	if _, _, _, err := ClosestPair(db, index); err == nil {
+		t.Errorf("ClosestPair() of a single star succeeded")
+	}
+}
+
+func TestForcesDontOverwriteVelocities(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 1, Y: 2}, M: 1e10},
+		{C: structs.Vec2{X: -200, Y: 50}, V: structs.Vec2{X: 3, Y: 4}, M: 2e10},
+		{C: structs.Vec2{X: 150, Y: -300}, V: structs.Vec2{X: 5, Y: 6}, M: 0},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	starIDs, _, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	// the force pass of the step functions, a step of the length 0 doesn't move the stars
+	if _, err := StepSimulation(db, index, 0, 0); err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+
+	// and the single star update
+	updated := store.updateStarForce(starIDs[0], structs.Vec2{X: 7, Y: 8})
+	if updated.V != stars[0].V {
+		t.Errorf("updateStarForce() velocity = %v, want %v", updated.V, stars[0].V)
+	}
+
+	withForces, err := StarsWithForces(db, index)
+	if err != nil {
+		t.Fatalf("StarsWithForces() error = %v", err)
+	}
+	for i, s := range withForces {
+		if got := GetStar(db, s.ID).V; got != stars[i].V {
+			t.Errorf("velocity of star %d = %v, want the unchanged %v", i, got, stars[i].V)
+		}
+	}
+	if got := withForces[0].Force; got != (structs.Vec2{X: 7, Y: 8}) {
+		t.Errorf("force of star 0 = %v, want %v", got, structs.Vec2{X: 7, Y: 8})
+	}
+	if got := withForces[1].Force; got == (structs.Vec2{}) {
+		t.Errorf("force of star 1 wasn't stored")
+	}
+}
+
+func TestFramePair(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// the timesteps 1 and 2 of a star moving to the right
+	frames := [][]structs.Star2D{
+		{{C: structs.Vec2{X: 10, Y: 10}, M: 1}, {C: structs.Vec2{X: -10, Y: -10}, M: 1}},
+		{{C: structs.Vec2{X: 20, Y: 10}, M: 1}, {C: structs.Vec2{X: -20, Y: -10}, M: 1}},
+	}
+	for _, frame := range frames {
+		index := store.newTreeIndex(1000)
+		for _, star := range frame {
+			InsertStar(db, star, index)
+		}
+	}
+
+	before, after, alpha, err := FramePair(db, 1, 1.25)
+	if err != nil {
+		t.Fatalf("FramePair() error = %v", err)
+	}
+	if alpha != 0.25 {
+		t.Errorf("FramePair() alpha = %v, want 0.25", alpha)
+	}
+	if !reflect.DeepEqual(before, frames[0]) || !reflect.DeepEqual(after, frames[1]) {
+		t.Errorf("FramePair() = %v, %v, want %v, %v", before, after, frames[0], frames[1])
+	}
+
+	// an integer timestep doesn't need a following frame
+	before, after, alpha, err = FramePair(db, 1, 2)
+	if err != nil || alpha != 0 || !reflect.DeepEqual(before, frames[1]) || !reflect.DeepEqual(after, frames[1]) {
+		t.Errorf("FramePair(2) = %v, %v, %v, %v, want the frame of the timestep 2 twice", before, after, alpha, err)
+	}
+
+	if _, _, _, err := FramePair(db, 1, 2.5); err != ErrTreeNotFound {
+		t.Errorf("FramePair(2.5) error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestInsertStarsFromReaderBatches(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	tests := []struct {
+		name      string
+		list      string
+		wantCount int64
+		wantErr   bool
+	}{
+		{name: "all stars land across commits", list: "1, 1\n2, 2\n3, 3\n4, 4\n5, 5\n", wantCount: 5},
+		{name: "committed batches are kept", list: "1, 1\n2, 2\n3, 3\n4, 4\n5, 5\nx, 6\n", wantCount: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DeleteAllStars(db)
+			DeleteAllNodes(db)
+			NewTree(db, 1000)
+
+			count, err := InsertStarsFromReader(db, strings.NewReader(tt.list), 1, InsertListOptions{Mass: 1000, BatchSize: 2})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InsertStarsFromReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("InsertStarsFromReader() = %d, want %d", count, tt.wantCount)
+			}
+			if got := mustListOfStarsTree(t, 1); int64(len(got)) != tt.wantCount {
+				t.Errorf("tree contains %d stars, want %d", len(got), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestUnplacedStars(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	_, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// a star row without a node, like after a failed insertion
+	var unplacedID int64
+	if err := db.QueryRow("INSERT INTO stars (x, y, vx, vy, m) VALUES (1, 2, 0, 0, 1000) RETURNING star_id").Scan(&unplacedID); err != nil {
+		t.Fatalf("inserting the star: %v", err)
+	}
+
+	got, err := UnplacedStars(db)
+	if err != nil {
+		t.Fatalf("UnplacedStars() error = %v", err)
+	}
+	if want := []int64{unplacedID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnplacedStars() = %v, want %v", got, want)
+	}
+}
+
+func TestPlaceUnplacedStars(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// an orphan star row without a node
+	var orphanID int64
+	if err := db.QueryRow("INSERT INTO stars (x, y, vx, vy, m) VALUES (300, -200, 0, 0, 1000) RETURNING star_id").Scan(&orphanID); err != nil {
+		t.Fatalf("inserting the star: %v", err)
+	}
+
+	placed, err := PlaceUnplacedStars(db, index)
+	if err != nil {
+		t.Fatalf("PlaceUnplacedStars() error = %v", err)
+	}
+	if placed != 1 {
+		t.Errorf("PlaceUnplacedStars() = %d, want 1", placed)
+	}
+
+	// the star is reachable by walking down the tree
+	stars, err := StarsInTreeAtTimestep(db, index, index)
+	if err != nil {
+		t.Fatalf("StarsInTreeAtTimestep() error = %v", err)
+	}
+	orphan := structs.Star2D{C: structs.Vec2{X: 300, Y: -200}, M: 1000}
+	found := false
+	for _, star := range stars {
+		found = found || star == orphan
+	}
+	if !found || len(stars) != 3 {
+		t.Errorf("tree contains %v, want the 3 stars including %v", stars, orphan)
+	}
+
+	if unplaced, err := UnplacedStars(db); err != nil || len(unplaced) != 0 {
+		t.Errorf("UnplacedStars() = %v, %v after placing them", unplaced, err)
+	}
+}
+
+func TestStarsWithColor(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 3000},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 5000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	got, err := StarsWithColor(db, index, ViridisColormap)
+	if err != nil {
+		t.Fatalf("StarsWithColor() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("StarsWithColor() returned %d stars, want 3", len(got))
+	}
+
+	lightest, heaviest := ViridisColormap(0, 0, 1), ViridisColormap(1, 0, 1)
+	if lightest != [3]uint8{68, 1, 84} || heaviest != [3]uint8{253, 231, 37} {
+		t.Errorf("ViridisColormap() endpoints = %v, %v", lightest, heaviest)
+	}
+	if got[1].Color != lightest {
+		t.Errorf("color of the lightest star = %v, want %v", got[1].Color, lightest)
+	}
+	if got[2].Color != heaviest {
+		t.Errorf("color of the heaviest star = %v, want %v", got[2].Color, heaviest)
+	}
+	if want := ViridisColormap(3000, 1000, 5000); got[0].Color != want {
+		t.Errorf("color of the star in between = %v, want %v", got[0].Color, want)
+	}
+}
+
+func TestBoundingCircle(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -350, Y: 120}, M: 1},
+		{C: structs.Vec2{X: 40, Y: -400}, M: 20},
+		{C: structs.Vec2{X: 10, Y: 20}, M: 5000},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	center, radius, err := BoundingCircle(db, index)
+	if err != nil {
+		t.Fatalf("BoundingCircle() error = %v", err)
+	}
+	for _, star := range stars {
+		if d := math.Hypot(star.C.X-center.X, star.C.Y-center.Y); d > radius*(1+1e-9) {
+			t.Errorf("star %v is %v away from the center %v, outside of the radius %v", star, d, center, radius)
+		}
+	}
+
+	// a tree without stars
+	center, radius, err = BoundingCircle(db, store.newTreeIndex(1000))
+	if err != nil || center != (structs.Vec2{}) || radius != 0 {
+		t.Errorf("BoundingCircle() of an empty tree = %v, %v, %v", center, radius, err)
+	}
+}
+
+func TestInsertListWithOptionsMapping(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	f, err := ioutil.TempFile("", "stars*.csv")
+	if err != nil {
+		t.Fatalf("TempFile() error = %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("100, 100\n-200, 50\n300, -400\n"); err != nil {
+		t.Fatalf("writing the list: %v", err)
+	}
+	f.Close()
+
+	mapping, err := InsertListWithOptions(db, f.Name(), 1, InsertListOptions{Mass: 1000, MapLines: true, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("InsertListWithOptions() error = %v", err)
+	}
+	if len(mapping) != 3 {
+		t.Fatalf("InsertListWithOptions() = %v, want a mapping of 3 lines", mapping)
+	}
+
+	want := []structs.Vec2{{X: 100, Y: 100}, {X: -200, Y: 50}, {X: 300, Y: -400}}
+	for i, m := range mapping {
+		if m.Line != i+1 {
+			t.Errorf("mapping[%d].Line = %d, want %d", i, m.Line, i+1)
+		}
+		if got := GetStar(db, m.StarID).C; got != want[i] {
+			t.Errorf("star %d of line %d is at %v, want %v", m.StarID, m.Line, got, want[i])
+		}
+	}
+
+	// without MapLines, no mapping is returned
+	mapping, err = InsertListWithOptions(db, f.Name(), 1, InsertListOptions{Mass: 1000})
+	if err != nil || mapping != nil {
+		t.Errorf("InsertListWithOptions() = %v, %v, want no mapping", mapping, err)
+	}
+}
+
+func TestInsertListTx(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	writeList := func(content string) string {
+		f, err := ioutil.TempFile("", "stars*.csv")
+		if err != nil {
+			t.Fatalf("TempFile() error = %v", err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("writing the list: %v", err)
+		}
+		f.Close()
+		return f.Name()
+	}
+
+	// a failed import into an empty database doesn't leave the tree created for it behind
+	broken := writeList("not, a star\n")
+	defer os.Remove(broken)
+	if inserted, err := InsertListTx(db, broken); err == nil || inserted != 0 {
+		t.Errorf("InsertListTx() = %d, %v, want an error", inserted, err)
+	}
+	if _, err := RootNodeID(db, 1); err != ErrTreeNotFound {
+		t.Errorf("RootNodeID() after the failed import error = %v, want %v", err, ErrTreeNotFound)
+	}
+
+	// the tree with the index 1 is created for the list
+	valid := writeList("10000000, 10000000\n-20000000, 5000000\n30000000, -40000000\n")
+	defer os.Remove(valid)
+	inserted, err := InsertListTx(db, valid)
+	if err != nil {
+		t.Fatalf("InsertListTx() error = %v", err)
+	}
+	if inserted != 3 {
+		t.Errorf("InsertListTx() = %d, want 3", inserted)
+	}
+
+	stars, err := GetListOfStarsTree(db, 1)
+	if err != nil {
+		t.Fatalf("GetListOfStarsTree() error = %v", err)
+	}
+	if len(stars) != 3 {
+		t.Fatalf("the tree contains %d stars, want 3", len(stars))
+	}
+	if want := (structs.Vec2{X: 100, Y: 100}); stars[0].C != want && stars[1].C != want && stars[2].C != want {
+		t.Errorf("stars = %v, want a star at %v", stars, want)
+	}
+
+	// a record that can't be parsed rolls back the stars inserted before it
+	invalid := writeList("100000, 100000\nnot, a star\n")
+	defer os.Remove(invalid)
+	if inserted, err := InsertListTx(db, invalid); err == nil || inserted != 0 {
+		t.Errorf("InsertListTx() = %d, %v, want an error", inserted, err)
+	}
+
+	stars, err = GetListOfStarsTree(db, 1)
+	if err != nil {
+		t.Fatalf("GetListOfStarsTree() error = %v", err)
+	}
+	if len(stars) != 3 {
+		t.Errorf("the tree contains %d stars after the failed import, want 3", len(stars))
+	}
+
+	// a failure while placing a star into the tree rolls back the import as well: the star next to (100, 100) has
+	// to subdivide the leaf storing it, but no nodes can be inserted
+	count := func(table string) int64 {
+		var n int64
+		if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatalf("count %s: %v", table, err)
+		}
+		return n
+	}
+	starCount, nodeCount := count("stars"), count("nodes")
+
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION reject_nodes() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'no new nodes'; END; $$ LANGUAGE plpgsql`)
+	if err != nil {
+		t.Fatalf("creating the trigger function: %v", err)
+	}
+	if _, err := db.Exec("CREATE TRIGGER reject_nodes BEFORE INSERT ON nodes FOR EACH ROW EXECUTE PROCEDURE reject_nodes()"); err != nil {
+		t.Fatalf("creating the trigger: %v", err)
+	}
+	defer db.Exec("DROP FUNCTION reject_nodes() CASCADE")
+
+	unplaceable := writeList("-100000, 100000\n5000000, 5000000\n")
+	defer os.Remove(unplaceable)
+	if inserted, err := InsertListTx(db, unplaceable); err == nil || inserted != 0 {
+		t.Errorf("InsertListTx() = %d, %v, want an error", inserted, err)
+	}
+
+	if got := count("stars"); got != starCount {
+		t.Errorf("the failed tree insertion left %d stars, want %d", got, starCount)
+	}
+	if got := count("nodes"); got != nodeCount {
+		t.Errorf("the failed tree insertion left %d nodes, want %d", got, nodeCount)
+	}
+}
+
+func TestVelocityDispersionProfile(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// rings of a width of 100 around (10, 10): speeds of 1 and 3 in the first ring, 2, 6 and 4 in the second one,
+	// no stars in the third one and a star outside of all of them
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 60, Y: 10}, V: structs.Vec2{X: 1}, M: 1000},
+		{C: structs.Vec2{X: 10, Y: -40}, V: structs.Vec2{Y: 3}, M: 1000},
+		{C: structs.Vec2{X: 160, Y: 10}, V: structs.Vec2{X: -2}, M: 1000},
+		{C: structs.Vec2{X: 10, Y: 160}, V: structs.Vec2{X: 6}, M: 1000},
+		{C: structs.Vec2{X: -140, Y: 10}, V: structs.Vec2{X: 0, Y: -4}, M: 1000},
+		{C: structs.Vec2{X: 410, Y: 10}, V: structs.Vec2{X: 100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	got, err := VelocityDispersionProfile(db, index, structs.Vec2{X: 10, Y: 10}, 3, 300)
+	if err != nil {
+		t.Fatalf("VelocityDispersionProfile() error = %v", err)
+	}
+
+	want := []float64{1, math.Sqrt(8.0 / 3.0), 0}
+	if len(got) != len(want) {
+		t.Fatalf("VelocityDispersionProfile() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("VelocityDispersionProfile() = %v, want %v", got, want)
+			break
+		}
+	}
+
+	if _, err := VelocityDispersionProfile(db, index, structs.Vec2{}, 0, 300); err == nil {
+		t.Errorf("VelocityDispersionProfile() without rings succeeded")
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	channel := "galaxy_changes_test"
+	store := NewStoreWithOptions(db, Options{NotifyChannel: channel})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the notifications are received using the configuration of the database, which doesn't have to be opened
+	// using ConnectToDB
+	connector, err := pq.NewConnector(ConnString(DBNAME))
+	if err != nil {
+		t.Fatalf("NewConnector() error = %v", err)
+	}
+	watched := sql.OpenDB(connector)
+	defer watched.Close()
+	watched.SetMaxOpenConns(1)
+
+	notifications, err := WatchChanges(ctx, watched, channel)
+	if err != nil {
+		t.Fatalf("WatchChanges() error = %v", err)
+	}
+
+	store.InsertStar(structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+
+	select {
+	case n, ok := <-notifications:
+		if !ok {
+			t.Fatalf("WatchChanges() closed the channel before receiving a notification")
+		}
+		if want := (Notification{Channel: channel, Payload: "insert 1"}); n != want {
+			t.Errorf("WatchChanges() received %v, want %v", n, want)
+		}
+	case <-ctx.Done():
+		t.Fatalf("WatchChanges() didn't receive a notification")
+	}
+
+	// a step inserting several stars sends a single notification after the tree has been built
+	index, err := store.StepSimulation(1, 0.5, 0)
+	if err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+	if _, err := store.InsertStarsFromReader(strings.NewReader("1, 2\n3, 4\n"), index, InsertListOptions{}); err != nil {
+		t.Fatalf("InsertStarsFromReader() error = %v", err)
+	}
+	for _, want := range []string{fmt.Sprintf("step %d", index), fmt.Sprintf("insert %d", index)} {
+		select {
+		case n := <-notifications:
+			if n.Payload != want {
+				t.Errorf("WatchChanges() received %q, want %q", n.Payload, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("WatchChanges() didn't receive the notification %q", want)
+		}
+	}
+	select {
+	case n := <-notifications:
+		t.Errorf("WatchChanges() received the additional notification %v", n)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// the channel is closed once the context is done and the connection stops listening
+	cancel()
+	for range notifications {
+	}
+	var listening int
+	if err := watched.QueryRow("SELECT count(*) FROM pg_listening_channels()").Scan(&listening); err != nil {
+		t.Fatalf("pg_listening_channels() error = %v", err)
+	}
+	if listening != 0 {
+		t.Errorf("the connection returned to the pool listens on %d channels, want 0", listening)
+	}
+
+	// a database that can't be connected to fails right away
+	missing := dbConnect(ConnString("no_such_database"))
+	defer missing.Close()
+	if _, err := WatchChanges(context.Background(), missing, channel); err == nil {
+		t.Errorf("WatchChanges() of a missing database succeeded")
+	}
+}
+
+func TestTidalRadius(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a host of 3e12 and a satellite of 1e9 at a distance of 500
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	InsertStar(db, structs.Star2D{C: structs.Vec2{X: -100, Y: -100}, M: 3e12}, 1)
+	satelliteID := InsertStar(db, structs.Star2D{C: structs.Vec2{X: 200, Y: 300}, M: 1e9}, 1)
+
+	got, err := TidalRadius(db, 1, satelliteID, 3e12)
+	if err != nil {
+		t.Fatalf("TidalRadius() error = %v", err)
+	}
+
+	// 500 * (1e9 / 9e12)^(1/3)
+	want := 500 * math.Cbrt(1e9/9e12)
+	if math.Abs(got-want) > 1e-9*want {
+		t.Errorf("TidalRadius() = %v, want %v", got, want)
+	}
+
+	if _, err := TidalRadius(db, 2, satelliteID, 3e12); err == nil {
+		t.Errorf("TidalRadius() of a star outside of the tree succeeded")
+	}
+}
+
+func TestQueryStarsRaw(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 3000},
+		{C: structs.Vec2{X: -100, Y: 100}, M: 2000},
+		{C: structs.Vec2{X: -200, Y: -100}, M: 1000},
+		{C: structs.Vec2{X: 200, Y: -100}, M: 500},
+	}
+	if _, err := BuildFixtureTree(db, stars); err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	got, err := QueryStarsRaw(db, "m > $1 AND x < $2", 900, 150)
+	if err != nil {
+		t.Fatalf("QueryStarsRaw() error = %v", err)
+	}
+	if want := stars[:3]; !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryStarsRaw() = %v, want %v", got, want)
+	}
+
+	// values passed as arguments are never interpreted as SQL
+	got, err = QueryStarsRaw(db, "x::text = $1", "0 OR TRUE")
+	if err != nil {
+		t.Fatalf("QueryStarsRaw() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("QueryStarsRaw() = %v, want no stars", got)
+	}
+}
+
+func TestDensityGrid(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// massless stars spanning the box from (-400, -400) to (400, 400) and all the mass in the cell from (0, 0) to
+	// (200, 200)
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: -400, Y: -400}, M: 0},
+		{C: structs.Vec2{X: 400, Y: 400}, M: 0},
+		{C: structs.Vec2{X: 10, Y: 10}, M: 1000},
+		{C: structs.Vec2{X: 150, Y: 30}, M: 2000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	grid, err := DensityGrid(db, index, 4)
+	if err != nil {
+		t.Fatalf("DensityGrid() error = %v", err)
+	}
+	if len(grid) != 4 {
+		t.Fatalf("DensityGrid() has %d columns, want 4", len(grid))
+	}
+	for i := range grid {
+		if len(grid[i]) != 4 {
+			t.Fatalf("DensityGrid() column %d has %d cells, want 4", i, len(grid[i]))
+		}
+		for j, mass := range grid[i] {
+			want := 0.0
+			if i == 2 && j == 2 {
+				want = 3000
+			}
+			if mass != want {
+				t.Errorf("DensityGrid()[%d][%d] = %v, want %v", i, j, mass, want)
+			}
+		}
+	}
+}
+
+func TestSimulateSteps(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 50, Y: 0}, V: structs.Vec2{X: 0, Y: 1}, M: 1e12},
+		{C: structs.Vec2{X: -50, Y: 0}, V: structs.Vec2{X: 0, Y: -1}, M: 1e12},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	indices, err := SimulateSteps(db, index, 0.5, 10, 3)
+	if err != nil {
+		t.Fatalf("SimulateSteps() error = %v", err)
+	}
+	if len(indices) != 3 {
+		t.Fatalf("SimulateSteps() = %v, want 3 timesteps", indices)
+	}
+
+	previous := index
+	for _, i := range indices {
+		if i <= previous {
+			t.Errorf("SimulateSteps() = %v, want the timesteps after %d in order", indices, index)
+		}
+		if got := mustListOfStarsTree(t, i); len(got) != len(stars) {
+			t.Errorf("timestep %d contains %d stars, want %d", i, len(got), len(stars))
+		}
+		previous = i
+	}
+
+	// a missing tree stops the simulation at the first step
+	indices, err = SimulateSteps(db, previous+100, 0.5, 10, 3)
+	if err == nil || len(indices) != 0 {
+		t.Errorf("SimulateSteps() of a missing tree = %v, %v, want an error", indices, err)
+	}
+}
+
+func TestStepDatabaseError(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 50, Y: 0}, M: 1e12},
+		{C: structs.Vec2{X: -50, Y: 0}, M: 1e12},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// updating the total masses fails, which has to fail the steps instead of exiting
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION reject_total_mass() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'no total masses'; END; $$ LANGUAGE plpgsql`)
+	if err != nil {
+		t.Fatalf("creating the trigger function: %v", err)
+	}
+	query := "CREATE TRIGGER reject_total_mass BEFORE UPDATE OF total_mass ON nodes FOR EACH ROW EXECUTE PROCEDURE reject_total_mass()"
+	if _, err := db.Exec(query); err != nil {
+		t.Fatalf("creating the trigger: %v", err)
+	}
+	defer db.Exec("DROP FUNCTION reject_total_mass() CASCADE")
+
+	if _, err := StepSimulation(db, index, 0.5, 1); err == nil {
+		t.Errorf("StepSimulation() error = nil, want the error of the total mass update")
+	}
+	if _, err := StepLeapfrogKDK(db, index, 0.5, 1); err == nil {
+		t.Errorf("StepLeapfrogKDK() error = nil, want the error of the total mass update")
+	}
+}
+
+func TestStarByExtID(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+
+	star := structs.Star2D{C: structs.Vec2{X: 100, Y: 200}, V: structs.Vec2{X: 1, Y: 2}, M: 1000}
+	starID, err := UpsertStarByExtID(db, 1, "HIP 11767", star)
+	if err != nil {
+		t.Fatalf("UpsertStarByExtID() error = %v", err)
+	}
+
+	gotID, got, err := StarByExtID(db, "HIP 11767")
+	if err != nil {
+		t.Fatalf("StarByExtID() error = %v", err)
+	}
+	if gotID != starID || got != star {
+		t.Errorf("StarByExtID() = %d, %v, want %d, %v", gotID, got, starID, star)
+	}
+
+	// upserting the same external id again updates the star
+	moved := structs.Star2D{C: structs.Vec2{X: -300, Y: 50}, V: structs.Vec2{X: 3, Y: 4}, M: 2000}
+	movedID, err := UpsertStarByExtID(db, 1, "HIP 11767", moved)
+	if err != nil {
+		t.Fatalf("UpsertStarByExtID() error = %v", err)
+	}
+	if movedID != starID {
+		t.Errorf("UpsertStarByExtID() = %d, want the existing star %d", movedID, starID)
+	}
+	if _, got, _ := StarByExtID(db, "HIP 11767"); got != moved {
+		t.Errorf("StarByExtID() = %v after the update, want %v", got, moved)
+	}
+	if got := mustListOfStarsTree(t, 1); !reflect.DeepEqual(got, []structs.Star2D{moved}) {
+		t.Errorf("tree contains %v, want only %v", got, moved)
+	}
+
+	if _, _, err := StarByExtID(db, "HIP 0"); err != ErrStarNotFound {
+		t.Errorf("StarByExtID() error = %v, want %v", err, ErrStarNotFound)
+	}
+}
+
+func TestGetNodeCOMVelocity(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, V: structs.Vec2{X: 10, Y: 0}, M: 1000},
+		{C: structs.Vec2{X: -200, Y: 50}, V: structs.Vec2{X: -5, Y: 20}, M: 3000},
+		{C: structs.Vec2{X: 150, Y: -300}, V: structs.Vec2{X: 0, Y: -8}, M: 4000},
+		// a star on the y axis must be weighted like the others
+		{C: structs.Vec2{X: 0, Y: 300}, V: structs.Vec2{X: 7, Y: 7}, M: 2000},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// the mass-weighted average of the velocities
+	var totalMass float64
+	var want structs.Vec2
+	for _, star := range stars {
+		totalMass += star.M
+		want.X += star.V.X * star.M
+		want.Y += star.V.Y * star.M
+	}
+	want = structs.Vec2{X: want.X / totalMass, Y: want.Y / totalMass}
+
+	rootID := mustRootNodeID(t, index)
+	got, err := GetNodeCOMVelocity(db, rootID)
+	if err != nil {
+		t.Fatalf("GetNodeCOMVelocity() error = %v", err)
+	}
+	if math.Abs(got.X-want.X) > 1e-9 || math.Abs(got.Y-want.Y) > 1e-9 {
+		t.Errorf("GetNodeCOMVelocity() = %v, want %v", got, want)
+	}
+
+	// the cached velocity follows the mutators of the galaxy
+	if err := ScaleGalaxy(db, index, 1, 2, 1); err != nil {
+		t.Fatalf("ScaleGalaxy() error = %v", err)
+	}
+	got, err = GetNodeCOMVelocity(db, rootID)
+	if err != nil {
+		t.Fatalf("GetNodeCOMVelocity() error = %v", err)
+	}
+	if math.Abs(got.X-2*want.X) > 1e-9 || math.Abs(got.Y-2*want.Y) > 1e-9 {
+		t.Errorf("GetNodeCOMVelocity() after ScaleGalaxy() = %v, want %v", got, want.Multiply(2))
+	}
+
+	if err := RemoveBulkMotion(db, index); err != nil {
+		t.Fatalf("RemoveBulkMotion() error = %v", err)
+	}
+	got, err = GetNodeCOMVelocity(db, rootID)
+	if err != nil {
+		t.Fatalf("GetNodeCOMVelocity() error = %v", err)
+	}
+	if math.Abs(got.X) > 1e-9 || math.Abs(got.Y) > 1e-9 {
+		t.Errorf("GetNodeCOMVelocity() after RemoveBulkMotion() = %v, want ~(0, 0)", got)
+	}
+}
+
+func TestOrbitTrace(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// the stars can be told apart by their masses, which the steps don't change
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 50, Y: 0}, V: structs.Vec2{X: 0, Y: 1}, M: 1e12},
+		{C: structs.Vec2{X: -50, Y: 0}, V: structs.Vec2{X: 0, Y: -1}, M: 2e12},
+		{C: structs.Vec2{X: 0, Y: 300}, V: structs.Vec2{X: 1, Y: 0}, M: 3e12},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// the first star is dropped after the first step, so the frames of the steps differ in length
+	first, err := StepSimulation(db, index, 0.5, 10)
+	if err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+	starIDs, _, err := store.treeStars(first)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if err := DeleteStar(db, starIDs[0]); err != nil {
+		t.Fatalf("DeleteStar() error = %v", err)
+	}
+	second, err := StepSimulation(db, first, 0.5, 10)
+	if err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+
+	positionOf := func(timestep int64, mass float64) structs.Vec2 {
+		t.Helper()
+		frame, err := GetListOfStarsTree(db, timestep)
+		if err != nil {
+			t.Fatalf("GetListOfStarsTree() error = %v", err)
+		}
+		for _, star := range frame {
+			if star.M == mass {
+				return star.C
+			}
+		}
+		t.Fatalf("the tree %d doesn't contain a star with the mass %v", timestep, mass)
+		return structs.Vec2{}
+	}
+
+	// the second star is followed through all the timesteps
+	trace, err := OrbitTrace(db, index, structs.Vec2{X: -45, Y: 5}, 2)
+	if err != nil {
+		t.Fatalf("OrbitTrace() error = %v", err)
+	}
+	if len(trace) != 3 {
+		t.Fatalf("OrbitTrace() = %v, want 3 positions", trace)
+	}
+	for i, timestep := range []int64{index, first, second} {
+		if want := positionOf(timestep, 2e12); trace[i] != want {
+			t.Errorf("OrbitTrace()[%d] = %v, want %v", i, trace[i], want)
+		}
+	}
+
+	// the trace of the dropped star ends with it
+	trace, err = OrbitTrace(db, index, structs.Vec2{X: 45, Y: 5}, 2)
+	if err != ErrStarDropped {
+		t.Errorf("OrbitTrace() of the dropped star error = %v, want %v", err, ErrStarDropped)
+	}
+	if want := []structs.Vec2{positionOf(index, 1e12)}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("OrbitTrace() of the dropped star = %v, want %v", trace, want)
+	}
+
+	if _, err := OrbitTrace(db, index, structs.Vec2{}, 3); err != ErrTreeNotFound {
+		t.Errorf("OrbitTrace() past the last timestep error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestValidateSubdivision(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+	leafID := store.findLeaf(rootID, structs.Vec2{X: 100, Y: 100})
+
+	for _, nodeID := range []int64{rootID, leafID} {
+		if err := ValidateSubdivision(db, nodeID); err != nil {
+			t.Errorf("ValidateSubdivision(%d) error = %v, want nil", nodeID, err)
+		}
+	}
+
+	// both stars are stored in children of the root, move the first one on top of the second one
+	otherLeafID := store.findLeaf(rootID, structs.Vec2{X: -100, Y: -100})
+	query := "UPDATE nodes SET box_center=(SELECT box_center FROM nodes WHERE node_id=$1) WHERE node_id=$2"
+	if _, err := db.Exec(query, otherLeafID, leafID); err != nil {
+		t.Fatalf("corrupting the children: %v", err)
+	}
+	if err := ValidateSubdivision(db, rootID); err == nil {
+		t.Errorf("ValidateSubdivision() didn't report the overlapping children of node %d", rootID)
+	}
+}
+
+func TestStarsWithAcceleration(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// a tight pair and a distant star
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 10, Y: 10}, M: 1e10},
+		{C: structs.Vec2{X: 12, Y: 10}, M: 1e10},
+		{C: structs.Vec2{X: -400, Y: -400}, M: 1e10},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	starIDs, _, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	accelerations, err := StarsWithAcceleration(db, index, 0)
+	if err != nil {
+		t.Fatalf("StarsWithAcceleration() error = %v", err)
+	}
+	if len(accelerations) != 3 {
+		t.Fatalf("StarsWithAcceleration() = %v, want 3 stars", accelerations)
+	}
+
+	distant := accelerations[starIDs[2]]
+	for _, starID := range starIDs[:2] {
+		if accelerations[starID] <= 100*distant {
+			t.Errorf("acceleration of star %d in the pair = %v, want much more than the %v of the distant star",
+				starID, accelerations[starID], distant)
+		}
+	}
+
+	// the accelerations are only read, the forces of the stars aren't stored
+	withForces, err := StarsWithForces(db, index)
+	if err != nil {
+		t.Fatalf("StarsWithForces() error = %v", err)
+	}
+	for _, s := range withForces {
+		if s.Force != (structs.Vec2{}) {
+			t.Errorf("StarsWithAcceleration() stored the force %v of star %d", s.Force, s.ID)
+		}
+	}
+}
+
+func TestRotationCurve(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a point mass in the center and massless test particles around it
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 0, Y: 0}, M: 1e12},
+		{C: structs.Vec2{X: 150, Y: 0}, M: 0},
+		{C: structs.Vec2{X: 0, Y: -350}, M: 0},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	curve, err := RotationCurve(db, index, structs.Vec2{}, 4, 400)
+	if err != nil {
+		t.Fatalf("RotationCurve() error = %v", err)
+	}
+	if len(curve) != 4 {
+		t.Fatalf("RotationCurve() = %v, want 4 rings", curve)
+	}
+
+	// Keplerian falloff: v*sqrt(r) is constant
+	for i := 1; i < len(curve); i++ {
+		want := curve[0] * math.Sqrt(1/float64(i+1))
+		if math.Abs(curve[i]-want) > 1e-9*want {
+			t.Errorf("RotationCurve()[%d] = %v, want %v", i, curve[i], want)
+		}
+	}
+
+	if _, err := RotationCurve(db, index, structs.Vec2{}, 0, 400); err == nil {
+		t.Errorf("RotationCurve() with zero bins succeeded")
+	}
+}
+
+func TestProjectOntoAxis(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 200}, M: 1000},
+		{C: structs.Vec2{X: -300, Y: 50}, M: 1000},
+		{C: structs.Vec2{X: 25, Y: -400}, M: 1000},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// the length of the axis doesn't matter
+	projections, err := ProjectOntoAxis(db, index, structs.Vec2{X: 5, Y: 0})
+	if err != nil {
+		t.Fatalf("ProjectOntoAxis() error = %v", err)
+	}
+
+	var want []float64
+	for _, star := range stars {
+		want = append(want, star.C.X)
+	}
+	if !reflect.DeepEqual(projections, want) {
+		t.Errorf("ProjectOntoAxis() = %v, want %v", projections, want)
+	}
+
+	if _, err := ProjectOntoAxis(db, index, structs.Vec2{}); err == nil {
+		t.Errorf("ProjectOntoAxis() onto the zero vector succeeded")
+	}
+}
+
+func TestDetectEncounters(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// one close pair (the second and the fourth star) among dispersed stars
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: -400, Y: 300}, M: 1000},
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: 350, Y: -250}, M: 1000},
+		{C: structs.Vec2{X: 103, Y: 98}, M: 1000},
+		{C: structs.Vec2{X: -200, Y: -420}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	starIDs, _, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+
+	pairs, err := DetectEncounters(db, index, 5)
+	if err != nil {
+		t.Fatalf("DetectEncounters() error = %v", err)
+	}
+	want := [][2]int64{{starIDs[1], starIDs[3]}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("DetectEncounters() = %v, want %v", pairs, want)
+	}
+
+	// a radius smaller than the distance of the pair doesn't find any encounters
+	if pairs, err := DetectEncounters(db, index, 3); err != nil || len(pairs) != 0 {
+		t.Errorf("DetectEncounters() = %v, %v, want no encounters", pairs, err)
+	}
+}
+
+func TestStarMeta(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+	NewTree(db, 1000)
+	starID, err := InsertStarStrict(db, structs.Star2D{C: structs.Vec2{X: 100, Y: 100}, M: 1000}, 1)
+	if err != nil {
+		t.Fatalf("InsertStarStrict() error = %v", err)
+	}
+
+	if meta, err := GetStarMeta(db, starID); err != nil || len(meta) != 0 {
+		t.Errorf("GetStarMeta() of a star without metadata = %v, %v, want an empty map", meta, err)
+	}
+
+	meta := map[string]interface{}{
+		"name":          "Sirius",
+		"spectral_type": "A1V",
+		"magnitude":     -1.46,
+		"binary":        true,
+		"aliases":       []interface{}{"Alpha Canis Majoris", "Dog Star"},
+	}
+	if err := SetStarMeta(db, starID, meta); err != nil {
+		t.Fatalf("SetStarMeta() error = %v", err)
+	}
+
+	got, err := GetStarMeta(db, starID)
+	if err != nil {
+		t.Fatalf("GetStarMeta() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("GetStarMeta() = %v, want %v", got, meta)
+	}
+
+	if err := SetStarMeta(db, starID+1000, meta); err != ErrStarNotFound {
+		t.Errorf("SetStarMeta() of a missing star error = %v, want %v", err, ErrStarNotFound)
+	}
+	if _, err := GetStarMeta(db, starID+1000); err != ErrStarNotFound {
+		t.Errorf("GetStarMeta() of a missing star error = %v, want %v", err, ErrStarNotFound)
+	}
+}
+
+func TestGridAggregate(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	// a box from (-400, -400) to (400, 400) split into 2x2 cells, stars in the lower left and the upper right cell
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: -400, Y: -400}, V: structs.Vec2{X: 2, Y: 4}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -300}, V: structs.Vec2{X: 4, Y: -2}, M: 3000},
+		{C: structs.Vec2{X: 400, Y: 400}, V: structs.Vec2{X: -6, Y: 0}, M: 500},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	grid, err := GridAggregate(db, index, 2)
+	if err != nil {
+		t.Fatalf("GridAggregate() error = %v", err)
+	}
+
+	want := [][]CellStats{
+		{{Count: 2, Mass: 4000, MeanVelocity: structs.Vec2{X: 3, Y: 1}}, {}},
+		{{}, {Count: 1, Mass: 500, MeanVelocity: structs.Vec2{X: -6, Y: 0}}},
+	}
+	if !reflect.DeepEqual(grid, want) {
+		t.Errorf("GridAggregate() = %v, want %v", grid, want)
+	}
+}
+
+func TestRecomputeDepths(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	index, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 100}, M: 1000},
+		{C: structs.Vec2{X: 120, Y: 110}, M: 1000},
+		{C: structs.Vec2{X: -100, Y: -100}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	rootID := mustRootNodeID(t, index)
+	leafID := store.findLeaf(rootID, structs.Vec2{X: 100, Y: 100})
+	want := store.getNodeDepth(leafID)
+
+	for _, nodeID := range []int64{rootID, leafID} {
+		if _, err := db.Exec("UPDATE nodes SET depth=42 WHERE node_id=$1", nodeID); err != nil {
+			t.Fatalf("corrupting the depth of node %d: %v", nodeID, err)
+		}
+	}
+
+	if err := RecomputeDepths(db, index); err != nil {
+		t.Fatalf("RecomputeDepths() error = %v", err)
+	}
+	if got := store.getNodeDepth(rootID); got != 0 {
+		t.Errorf("depth of the root = %d, want 0", got)
+	}
+	if got := store.getNodeDepth(leafID); got != want || want < 2 {
+		t.Errorf("depth of the leaf = %d, want %d (at least 2)", got, want)
+	}
+
+	if err := RecomputeDepths(db, index+100); err != ErrTreeNotFound {
+		t.Errorf("RecomputeDepths() of a missing tree error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestStarsArrow(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	stars := []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 200}, V: structs.Vec2{X: 1, Y: 2}, M: 1000},
+		{C: structs.Vec2{X: -300, Y: 50}, V: structs.Vec2{X: 3, Y: -4}, M: 2000},
+		{C: structs.Vec2{X: 25, Y: -400}, V: structs.Vec2{X: -5, Y: 6}, M: 3000},
+	}
+	index, err := BuildFixtureTree(db, stars)
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := StarsArrow(db, index, &buf); err != nil {
+		t.Fatalf("StarsArrow() error = %v", err)
+	}
+
+	// read the stream back with the reference implementation
+	r, err := ipc.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ipc.NewReader() error = %v", err)
+	}
+	defer r.Release()
+
+	var names []string
+	for _, f := range r.Schema().Fields() {
+		if f.Type.ID() != arrow.FLOAT64 || f.Nullable {
+			t.Errorf("StarsArrow() column %s has the type %v (nullable %t), want a non nullable float64", f.Name, f.Type, f.Nullable)
+		}
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"x", "y", "vx", "vy", "m"}) {
+		t.Fatalf("StarsArrow() columns = %v, want x, y, vx, vy and m", names)
+	}
+
+	if !r.Next() {
+		t.Fatalf("StarsArrow() contains no record batch: %v", r.Err())
+	}
+	rec := r.Record()
+	if rec.NumRows() != int64(len(stars)) {
+		t.Fatalf("StarsArrow() = %d rows, want %d", rec.NumRows(), len(stars))
+	}
+	columns := make([][]float64, rec.NumCols())
+	for i, col := range rec.Columns() {
+		columns[i] = col.(*array.Float64).Float64Values()
+	}
+	for i, star := range stars {
+		got := structs.Star2D{
+			C: structs.Vec2{X: columns[0][i], Y: columns[1][i]},
+			V: structs.Vec2{X: columns[2][i], Y: columns[3][i]},
+			M: columns[4][i],
+		}
+		if got != star {
+			t.Errorf("StarsArrow() row %d = %v, want %v", i, got, star)
+		}
+	}
+
+	if r.Next() {
+		t.Errorf("StarsArrow() contains more than one record batch")
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("reading the StarsArrow() stream: %v", err)
+	}
+}
+
+func TestStarsChangedSince(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	snapshot, err := BuildFixtureTree(db, []structs.Star2D{
+		{C: structs.Vec2{X: 100, Y: 200}, M: 1000},
+		{C: structs.Vec2{X: -300, Y: 50}, M: 1000},
+		{C: structs.Vec2{X: 25, Y: -400}, M: 1000},
+	})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+
+	// a step of the length 0 copies the stars into a new tree, then one of them is moved
+	index, err := StepSimulation(db, snapshot, 0.5, 0)
+	if err != nil {
+		t.Fatalf("StepSimulation() error = %v", err)
+	}
+	if changed, err := StarsChangedSince(db, index, snapshot); err != nil || len(changed) != 0 {
+		t.Errorf("StarsChangedSince() of an unchanged tree = %v, %v, want no stars", changed, err)
+	}
+
+	starIDs, _, err := store.treeStars(index)
+	if err != nil {
+		t.Fatalf("treeStars() error = %v", err)
+	}
+	if err := MoveStar(db, starIDs[1], index, structs.Vec2{X: -250, Y: 80}); err != nil {
+		t.Fatalf("MoveStar() error = %v", err)
+	}
+
+	changed, err := StarsChangedSince(db, index, snapshot)
+	if err != nil {
+		t.Fatalf("StarsChangedSince() error = %v", err)
+	}
+	want := []StarRecord{{ID: starIDs[1], Star: structs.Star2D{C: structs.Vec2{X: -250, Y: 80}, M: 1000}}}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("StarsChangedSince() = %v, want %v", changed, want)
+	}
+
+	// dropping a star doesn't make the stars following it look changed
+	if err := DeleteStar(db, starIDs[0]); err != nil {
+		t.Fatalf("DeleteStar() error = %v", err)
+	}
+	changed, err = StarsChangedSince(db, index, snapshot)
+	if err != nil {
+		t.Fatalf("StarsChangedSince() error = %v", err)
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("StarsChangedSince() after dropping a star = %v, want %v", changed, want)
+	}
+
+	if _, err := StarsChangedSince(db, index, index+100); err != ErrTreeNotFound {
+		t.Errorf("StarsChangedSince() of a missing snapshot error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestInterGalaxyForce(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	// two point-mass galaxies 400 apart along the x axis
+	a := structs.Star2D{C: structs.Vec2{X: -200, Y: 0}, M: 1e10}
+	b := structs.Star2D{C: structs.Vec2{X: 200, Y: 0}, M: 2e10}
+	indexA, err := BuildFixtureTree(db, []structs.Star2D{a})
+	if err != nil {
+		t.Fatalf("BuildFixtureTree() error = %v", err)
+	}
+	indexB := store.newTreeIndex(1000)
+	InsertStar(db, b, indexB)
+	UpdateTotalMass(db, indexB)
+	UpdateCenterOfMass(db, indexB)
+
+	// the force is calculated without writing to the database
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION reject_node_updates() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'no node updates'; END; $$ LANGUAGE plpgsql`)
+	if err != nil {
+		t.Fatalf("creating the trigger function: %v", err)
+	}
+	if _, err := db.Exec("CREATE TRIGGER reject_node_updates BEFORE UPDATE ON nodes FOR EACH ROW EXECUTE PROCEDURE reject_node_updates()"); err != nil {
+		t.Fatalf("creating the trigger: %v", err)
+	}
+	defer db.Exec("DROP FUNCTION reject_node_updates() CASCADE")
+
+	force, err := InterGalaxyForce(db, indexA, indexB, 0.5)
+	if err != nil {
+		t.Fatalf("InterGalaxyForce() error = %v", err)
+	}
+
+	// attractive: galaxy A is pulled towards galaxy B along the line connecting them
+	G := 6.6726 * math.Pow(10, -11)
+	want := G * a.M * b.M / (400 * 400)
+	if force.Y != 0 || math.Abs(force.X-want) > 1e-9*want {
+		t.Errorf("InterGalaxyForce() = %v, want (%v, 0)", force, want)
+	}
+
+	if _, err := InterGalaxyForce(db, indexA, indexB+100, 0.5); err != ErrTreeNotFound {
+		t.Errorf("InterGalaxyForce() of a missing galaxy error = %v, want %v", err, ErrTreeNotFound)
+	}
+}
+
+func TestGetBoxCenter(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+	store := NewStore(db)
+
+	DeleteAllNodes(db)
+	nodeID := mustNewNode(t, 10, -20, 100, 1, 1)
+
+	if got := store.getBoxCenter(nodeID); !reflect.DeepEqual(got, []float64{10, -20}) {
+		t.Errorf("getBoxCenter() = %v, want [10 -20]", got)
+	}
+}
+
+func TestStore(t *testing.T) {
+	// define a database
+	db = ConnectToDB(DBNAME)
+	db.SetMaxOpenConns(75)
+
+	DeleteAllStars(db)
+	DeleteAllNodes(db)
+
+	// two stores with their own connection pools used at once
+	stores := []*Store{NewStore(db), NewStore(ConnectToDB(DBNAME))}
+	indices := make([]int64, len(stores))
+	errs := make([]error, len(stores))
+
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		wg.Add(1)
+		go func(i int, store *Store) {
+			defer wg.Done()
+
+			indices[i], _, errs[i] = store.NewTreeAt(structs.Vec2{}, 1000)
+			if errs[i] != nil {
+				return
+			}
+
+			for j := 1; j <= 3; j++ {
+				store.InsertStar(structs.Star2D{C: structs.Vec2{X: float64(100 * j), Y: float64(-50 * i)}, M: 1000}, indices[i])
+			}
+		}(i, store)
+	}
+	wg.Wait()
+
+	for i, store := range stores {
+		if errs[i] != nil {
+			t.Fatalf("NewTreeAt() error = %v", errs[i])
+		}
+
+		stars, err := store.GetListOfStarsTree(indices[i])
+		if err != nil {
+			t.Fatalf("GetListOfStarsTree() error = %v", err)
+		}
+		if len(stars) != 3 {
+			t.Errorf("tree %d contains %d stars, want 3", indices[i], len(stars))
+		}
+		for _, star := range stars {
+			if star.C.Y != float64(-50*i) {
+				t.Errorf("tree %d contains the star %v of the other store", indices[i], star)
+			}
+		}
+	}
+}
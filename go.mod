@@ -4,5 +4,7 @@ go 1.12
 
 require (
 	git.darknebu.la/GalaxySimulator/structs v0.0.0-20190205205735-9dd56b9448e5
-	github.com/lib/pq v1.0.0
+	github.com/apache/arrow/go/arrow v0.0.0-20211112161151-bc219186db40
+	github.com/lib/pq v1.10.9
+	google.golang.org/protobuf v1.27.1
 )